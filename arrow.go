@@ -0,0 +1,261 @@
+package difftable
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// recordTable is a table over a stream of Arrow record batches.
+type recordTable struct {
+	rdr     array.RecordReader
+	key     []string
+	cols    map[string]string
+	colIdxs map[string]int
+
+	rec arrow.RecordBatch
+	idx int
+}
+
+// newRecordTable returns a table over the record batches with the column
+// types. The column indexes are taken from the schema of the reader.
+func newRecordTable(rdr array.RecordReader, key []string, renames map[string]string, types []string) *recordTable {
+	for i, k := range key {
+		if n, ok := renames[k]; ok {
+			key[i] = n
+		}
+	}
+
+	fields := rdr.Schema().Fields()
+
+	cols := make(map[string]string, len(fields))
+	colIdxs := make(map[string]int, len(fields))
+
+	for i, f := range fields {
+		c := f.Name
+		if n, ok := renames[c]; ok {
+			c = n
+		}
+		colIdxs[c] = i
+		cols[c] = types[i]
+	}
+
+	return &recordTable{
+		rdr:     rdr,
+		key:     key,
+		cols:    cols,
+		colIdxs: colIdxs,
+	}
+}
+
+func (t *recordTable) Key() []string {
+	return t.key
+}
+
+func (t *recordTable) Cols() map[string]string {
+	return t.cols
+}
+
+func (t *recordTable) Row() Row {
+	return &recordRow{
+		colIdxs: t.colIdxs,
+		rec:     t.rec,
+		idx:     t.idx,
+	}
+}
+
+func (t *recordTable) Next() (bool, error) {
+	if t.rec != nil {
+		t.idx++
+		if int64(t.idx) < t.rec.NumRows() {
+			return true, nil
+		}
+	}
+
+	// Advance to the next non-empty batch. The reader releases the
+	// previous batch.
+	for {
+		if !t.rdr.Next() {
+			t.rec = nil
+			return false, t.rdr.Err()
+		}
+
+		t.rec = t.rdr.RecordBatch()
+		t.idx = 0
+
+		if t.rec.NumRows() > 0 {
+			return true, nil
+		}
+	}
+}
+
+type recordRow struct {
+	colIdxs map[string]int
+	rec     arrow.RecordBatch
+	idx     int
+}
+
+func (r *recordRow) Bytes(col string) []byte {
+	i, ok := r.colIdxs[col]
+	if !ok || r.rec == nil {
+		return nil
+	}
+
+	return arrowBytes(r.rec.Column(i), r.idx)
+}
+
+func (r *recordRow) Value(col string) interface{} {
+	i, ok := r.colIdxs[col]
+	if !ok || r.rec == nil {
+		return nil
+	}
+
+	return arrowValue(r.rec.Column(i), r.idx)
+}
+
+// arrowValue returns the value at the index as a native Go value. Dates and
+// timestamps are returned as time.Time, decimals as json.Number, dictionary
+// values as the value they encode, lists as []interface{} and structs and
+// maps as map[string]interface{}, so they can be spilled when sorted.
+func arrowValue(arr arrow.Array, i int) interface{} {
+	if arr.IsNull(i) {
+		return nil
+	}
+
+	switch a := arr.(type) {
+	case *array.Boolean:
+		return a.Value(i)
+	case *array.Int8:
+		return a.Value(i)
+	case *array.Int16:
+		return a.Value(i)
+	case *array.Int32:
+		return a.Value(i)
+	case *array.Int64:
+		return a.Value(i)
+	case *array.Uint8:
+		return a.Value(i)
+	case *array.Uint16:
+		return a.Value(i)
+	case *array.Uint32:
+		return a.Value(i)
+	case *array.Uint64:
+		return a.Value(i)
+	case *array.Float32:
+		return a.Value(i)
+	case *array.Float64:
+		return a.Value(i)
+	case *array.String:
+		return a.Value(i)
+	case *array.LargeString:
+		return a.Value(i)
+	case *array.StringView:
+		return a.Value(i)
+	case *array.Binary:
+		return a.Value(i)
+	case *array.LargeBinary:
+		return a.Value(i)
+	case *array.BinaryView:
+		return a.Value(i)
+	case *array.FixedSizeBinary:
+		return a.Value(i)
+	case *array.Date32:
+		return a.Value(i).ToTime()
+	case *array.Date64:
+		return a.Value(i).ToTime()
+	case *array.Timestamp:
+		return a.Value(i).ToTime(a.DataType().(*arrow.TimestampType).Unit)
+	case *array.Decimal128:
+		return json.Number(a.Value(i).ToString(a.DataType().(arrow.DecimalType).GetScale()))
+	case *array.Decimal256:
+		return json.Number(a.Value(i).ToString(a.DataType().(arrow.DecimalType).GetScale()))
+	case *array.Dictionary:
+		return arrowValue(a.Dictionary(), a.GetValueIndex(i))
+	case *array.Struct:
+		m := make(map[string]interface{}, a.NumField())
+		for j := 0; j < a.NumField(); j++ {
+			m[a.DataType().(*arrow.StructType).Field(j).Name] = arrowValue(a.Field(j), i)
+		}
+		return m
+	case *array.Map:
+		start, end := a.ValueOffsets(i)
+		m := make(map[string]interface{}, end-start)
+		for j := int(start); j < int(end); j++ {
+			m[string(arrowBytes(a.Keys(), j))] = arrowValue(a.Items(), j)
+		}
+		return m
+	case array.ListLike:
+		start, end := a.ValueOffsets(i)
+		l := make([]interface{}, 0, end-start)
+		for j := int(start); j < int(end); j++ {
+			l = append(l, arrowValue(a.ListValues(), j))
+		}
+		return l
+	}
+
+	return arr.GetOneForMarshal(i)
+}
+
+// arrowBytes encodes the value at the index for comparison. Numbers are
+// formatted as text, dates as YYYY-MM-DD and timestamps in RFC 3339 format
+// so they compare the same as other text sources. Nested values are
+// encoded as JSON.
+func arrowBytes(arr arrow.Array, i int) []byte {
+	if arr.IsNull(i) {
+		return nil
+	}
+
+	switch a := arr.(type) {
+	case *array.Boolean:
+		return strconv.AppendBool(nil, a.Value(i))
+	case *array.Int8:
+		return strconv.AppendInt(nil, int64(a.Value(i)), 10)
+	case *array.Int16:
+		return strconv.AppendInt(nil, int64(a.Value(i)), 10)
+	case *array.Int32:
+		return strconv.AppendInt(nil, int64(a.Value(i)), 10)
+	case *array.Int64:
+		return strconv.AppendInt(nil, a.Value(i), 10)
+	case *array.Uint8:
+		return strconv.AppendUint(nil, uint64(a.Value(i)), 10)
+	case *array.Uint16:
+		return strconv.AppendUint(nil, uint64(a.Value(i)), 10)
+	case *array.Uint32:
+		return strconv.AppendUint(nil, uint64(a.Value(i)), 10)
+	case *array.Uint64:
+		return strconv.AppendUint(nil, a.Value(i), 10)
+	case *array.Float32:
+		return strconv.AppendFloat(nil, float64(a.Value(i)), 'g', -1, 32)
+	case *array.Float64:
+		return strconv.AppendFloat(nil, a.Value(i), 'g', -1, 64)
+	case *array.String, *array.LargeString, *array.StringView:
+		return []byte(a.ValueStr(i))
+	case *array.Binary:
+		return a.Value(i)
+	case *array.LargeBinary:
+		return a.Value(i)
+	case *array.BinaryView:
+		return a.Value(i)
+	case *array.FixedSizeBinary:
+		return a.Value(i)
+	case *array.Date32, *array.Date64:
+		return []byte(arrowValue(arr, i).(time.Time).Format("2006-01-02"))
+	case *array.Timestamp:
+		return []byte(arrowValue(arr, i).(time.Time).UTC().Format(time.RFC3339Nano))
+	case *array.Decimal128, *array.Decimal256:
+		return []byte(arrowValue(arr, i).(json.Number))
+	case *array.Dictionary:
+		return arrowBytes(a.Dictionary(), a.GetValueIndex(i))
+	}
+
+	b, err := json.Marshal(arr.GetOneForMarshal(i))
+	if err != nil {
+		return []byte(fmt.Sprint(arr.GetOneForMarshal(i)))
+	}
+
+	return b
+}
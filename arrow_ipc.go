@@ -0,0 +1,668 @@
+package difftable
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/decimal128"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// Magic bytes and padding at the start of an Arrow IPC file.
+var arrowFileMagic = []byte("ARROW1\x00\x00")
+
+// ArrowTable returns a table of the record batches in an Arrow IPC file,
+// also known as Feather version 2, or an Arrow IPC stream. Batches are
+// read one at a time and values are read from the columns of the batch.
+//
+// Files are read like streams, which they contain, so they can be read
+// from stdin or decompressed without random access. Column types are named
+// like those of ParquetTable, such as string, int64, double, date,
+// timestamptz or decimal(10,2). Dictionary-encoded columns have the type of
+// their values.
+func ArrowTable(r io.Reader, key []string, renames map[string]string) (Table, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.Peek(len(arrowFileMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.Equal(header, arrowFileMagic) {
+		br.Discard(len(arrowFileMagic))
+	}
+
+	rdr, err := ipc.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := rdr.Schema().Fields()
+
+	types := make([]string, len(fields))
+	for i, f := range fields {
+		types[i] = arrowType(f.Type)
+	}
+
+	return newRecordTable(rdr, key, renames, types), nil
+}
+
+// arrowType returns the column type name of an Arrow data type.
+func arrowType(dt arrow.DataType) string {
+	switch t := dt.(type) {
+	case *arrow.BooleanType:
+		return "boolean"
+	case *arrow.Float16Type:
+		return "float16"
+	case *arrow.Float32Type:
+		return "float"
+	case *arrow.Float64Type:
+		return "double"
+	case *arrow.StringType, *arrow.LargeStringType, *arrow.StringViewType:
+		return "string"
+	case *arrow.BinaryType, *arrow.LargeBinaryType, *arrow.BinaryViewType:
+		return "bytes"
+	case *arrow.FixedSizeBinaryType:
+		return fmt.Sprintf("fixed(%d)", t.ByteWidth)
+	case *arrow.Date32Type, *arrow.Date64Type:
+		return "date"
+	case *arrow.Time32Type, *arrow.Time64Type:
+		return "time"
+	case *arrow.TimestampType:
+		if t.TimeZone != "" {
+			return "timestamptz"
+		}
+		return "timestamp"
+	case arrow.DecimalType:
+		return fmt.Sprintf("decimal(%d,%d)", t.GetPrecision(), t.GetScale())
+	case *arrow.ListType, *arrow.LargeListType, *arrow.FixedSizeListType, *arrow.ListViewType:
+		return "list"
+	case *arrow.MapType:
+		return "map"
+	case *arrow.StructType:
+		return "struct"
+	case *arrow.DictionaryType:
+		return arrowType(t.ValueType)
+	}
+
+	// Integers are named by the Arrow type, e.g. int32 or uint8.
+	return dt.Name()
+}
+
+// DefaultArrowBatchSize is the default number of rows per record batch
+// written by WriteArrowSnapshot and ArrowEventWriter.
+const DefaultArrowBatchSize = 64 * 1024
+
+// ArrowWriterOptions are options for writing Arrow IPC.
+type ArrowWriterOptions struct {
+	// Stream writes the IPC stream format rather than the file format.
+	Stream bool
+
+	// BatchSize is the number of rows per record batch. Defaults to
+	// DefaultArrowBatchSize.
+	BatchSize int
+}
+
+// arrowColumn is a column written to Arrow with the name of the value in
+// the row data.
+type arrowColumn struct {
+	name string
+	typ  string
+}
+
+// arrowBatchWriter buffers rows in a record builder and writes a batch
+// each time it is full.
+type arrowBatchWriter struct {
+	w interface {
+		Write(arrow.RecordBatch) error
+		Close() error
+	}
+	b    *array.RecordBuilder
+	rows int
+	size int
+}
+
+func newArrowBatchWriter(w io.Writer, sc *arrow.Schema, opts ArrowWriterOptions) (*arrowBatchWriter, error) {
+	bw := &arrowBatchWriter{
+		b:    array.NewRecordBuilder(memory.DefaultAllocator, sc),
+		size: opts.BatchSize,
+	}
+
+	if bw.size <= 0 {
+		bw.size = DefaultArrowBatchSize
+	}
+
+	if opts.Stream {
+		bw.w = ipc.NewWriter(w, ipc.WithSchema(sc))
+	} else {
+		fw, err := ipc.NewFileWriter(w, ipc.WithSchema(sc))
+		if err != nil {
+			return nil, err
+		}
+		bw.w = fw
+	}
+
+	return bw, nil
+}
+
+// row is called after the values of a row are appended.
+func (w *arrowBatchWriter) row() error {
+	w.rows++
+	if w.rows < w.size {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *arrowBatchWriter) flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+
+	rec := w.b.NewRecordBatch()
+	defer rec.Release()
+
+	w.rows = 0
+
+	return w.w.Write(rec)
+}
+
+// Close writes the remaining rows and ends the file or stream.
+func (w *arrowBatchWriter) Close() error {
+	defer w.b.Release()
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	return w.w.Close()
+}
+
+// arrowColumns returns the columns of the table in the order described by
+// the table or, if it does not describe them, the key columns followed by
+// the other columns sorted by name.
+func arrowColumns(t Table) []*arrowColumn {
+	cols := t.Cols()
+
+	var names []string
+
+	if cd, ok := t.(ColumnDescriber); ok {
+		for _, c := range cd.Columns() {
+			if _, ok := cols[c.Name]; ok {
+				names = append(names, c.Name)
+			}
+		}
+	}
+
+	if len(names) != len(cols) {
+		names = names[:0]
+
+		seen := make(map[string]bool, len(cols))
+		for _, k := range t.Key() {
+			if _, ok := cols[k]; ok && !seen[k] {
+				names = append(names, k)
+				seen[k] = true
+			}
+		}
+
+		var rest []string
+		for c := range cols {
+			if !seen[c] {
+				rest = append(rest, c)
+			}
+		}
+		sort.Strings(rest)
+
+		names = append(names, rest...)
+	}
+
+	acols := make([]*arrowColumn, len(names))
+	for i, n := range names {
+		acols[i] = &arrowColumn{
+			name: n,
+			typ:  cols[n],
+		}
+	}
+
+	return acols
+}
+
+// WriteArrowSnapshot writes the rows of the table to Arrow IPC with a
+// column for each column of the table, see ArrowEventWriter for how types
+// are converted.
+func WriteArrowSnapshot(w io.Writer, t Table, opts ArrowWriterOptions) error {
+	cols := arrowColumns(t)
+
+	fields := make([]arrow.Field, len(cols))
+	for i, c := range cols {
+		fields[i] = arrow.Field{Name: c.name, Type: arrowFieldType(c.typ), Nullable: true}
+	}
+
+	bw, err := newArrowBatchWriter(w, arrow.NewSchema(fields, nil), opts)
+	if err != nil {
+		return err
+	}
+
+	err = Snapshot(t, func(e *Event) error {
+		for i, c := range cols {
+			if err := arrowAppend(bw.b.Field(i), e.Data[c.name]); err != nil {
+				return fmt.Errorf("column `%s`: %s", c.name, err)
+			}
+		}
+		return bw.row()
+	})
+	if err != nil {
+		bw.b.Release()
+		return err
+	}
+
+	return bw.Close()
+}
+
+// ArrowEventWriter writes the row events of a diff to Arrow IPC with one
+// row per event. The columns are:
+//
+//   - event, the event type such as row-added
+//   - offset, the offset of the event
+//   - a column for each column of either table with the data of the row,
+//     which is the new data except for removed rows
+//   - changed, the names of the columns that changed in row-changed events
+//   - old.<column> for each column with the old value of changed columns
+//
+// Columns are typed by converting the column type names of the tables,
+// preferring the type in the new table, to Arrow types: integers to int64,
+// except uint64, floats to float64, decimals and numbers with a precision
+// to decimal128, dates to date32, timestamps to timestamps in
+// microseconds, booleans and bytes to themselves and all others, including
+// numbers without a precision, to strings. Nested values are written as JSON strings. Column
+// events are not written since the schema describes the columns.
+type ArrowEventWriter struct {
+	bw   *arrowBatchWriter
+	cols []*arrowColumn
+	idx  map[string]int
+
+	// Error of a failed write, after which the builder is released.
+	err error
+}
+
+// NewArrowEventWriter returns a writer of events of a diff of the tables.
+// Write is a handler for DiffEvents and Close must be called once the diff
+// is done.
+func NewArrowEventWriter(w io.Writer, t1, t2 Table, opts ArrowWriterOptions) (*ArrowEventWriter, error) {
+	cols := arrowColumns(t2)
+
+	seen := make(map[string]bool, len(cols))
+	for _, c := range cols {
+		seen[c.name] = true
+	}
+	for _, c := range arrowColumns(t1) {
+		if !seen[c.name] {
+			cols = append(cols, c)
+		}
+	}
+
+	fields := []arrow.Field{
+		{Name: "event", Type: arrow.BinaryTypes.String},
+		{Name: "offset", Type: arrow.PrimitiveTypes.Int64},
+	}
+
+	for _, c := range cols {
+		fields = append(fields, arrow.Field{Name: c.name, Type: arrowFieldType(c.typ), Nullable: true})
+	}
+
+	fields = append(fields, arrow.Field{Name: "changed", Type: arrow.ListOf(arrow.BinaryTypes.String), Nullable: true})
+
+	for _, c := range cols {
+		fields = append(fields, arrow.Field{Name: "old." + c.name, Type: arrowFieldType(c.typ), Nullable: true})
+	}
+
+	bw, err := newArrowBatchWriter(w, arrow.NewSchema(fields, nil), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := make(map[string]int, len(cols))
+	for i, c := range cols {
+		idx[c.name] = i
+	}
+
+	return &ArrowEventWriter{
+		bw:   bw,
+		cols: cols,
+		idx:  idx,
+	}, nil
+}
+
+// Write writes a row event. Other events are ignored. Once a write fails,
+// the writer is unusable and the error is returned by later calls.
+func (w *ArrowEventWriter) Write(e *Event) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := w.write(e); err != nil {
+		w.bw.b.Release()
+		w.err = err
+		return err
+	}
+
+	return nil
+}
+
+func (w *ArrowEventWriter) write(e *Event) error {
+	switch e.Type {
+	case EventRowAdded, EventRowChanged, EventRowRemoved, EventRowStored:
+	default:
+		return nil
+	}
+
+	b := w.bw.b
+	n := len(w.cols)
+
+	b.Field(0).(*array.StringBuilder).Append(e.Type)
+	b.Field(1).(*array.Int64Builder).Append(e.Offset)
+
+	// Data of the row, or the key if the data was omitted.
+	data := e.Data
+	if data == nil {
+		data = e.Key
+	}
+
+	for i, c := range w.cols {
+		if err := arrowAppend(b.Field(2+i), data[c.name]); err != nil {
+			return fmt.Errorf("column `%s`: %s", c.name, err)
+		}
+	}
+
+	lb := b.Field(2 + n).(*array.ListBuilder)
+
+	if e.Type != EventRowChanged {
+		lb.AppendNull()
+	} else {
+		lb.Append(true)
+
+		var changed []string
+		for c := range e.Changes {
+			changed = append(changed, c)
+		}
+		sort.Strings(changed)
+
+		vb := lb.ValueBuilder().(*array.StringBuilder)
+		for _, c := range changed {
+			vb.Append(c)
+		}
+	}
+
+	for i, c := range w.cols {
+		var v interface{}
+		if ch, ok := e.Changes[c.name]; ok {
+			v = ch.Old
+		}
+
+		if err := arrowAppend(b.Field(3+n+i), v); err != nil {
+			return fmt.Errorf("column `old.%s`: %s", c.name, err)
+		}
+	}
+
+	return w.bw.row()
+}
+
+// Close writes the remaining events and ends the file or stream.
+func (w *ArrowEventWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.bw.Close()
+}
+
+// arrowFieldType returns the Arrow type values of the column type are
+// written as.
+func arrowFieldType(typ string) arrow.DataType {
+	t := normalizeType(typ)
+
+	// Unsigned integers, such as MySQL's, may not fit an int64.
+	if isIntegerType(t) && strings.Contains(strings.ToLower(typ), "unsigned") {
+		return arrow.PrimitiveTypes.Uint64
+	}
+
+	switch t {
+	case "boolean", "bool":
+		return arrow.FixedWidthTypes.Boolean
+
+	case "float", "double", "real", "float4", "float8", "float16", "double precision":
+		return arrow.PrimitiveTypes.Float64
+
+	case "uint64":
+		return arrow.PrimitiveTypes.Uint64
+
+	case "date":
+		return arrow.FixedWidthTypes.Date32
+
+	case "timestamptz", "timestamp with time zone":
+		return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+
+	case "timestamp", "timestamp without time zone", "datetime", "datetime2":
+		return &arrow.TimestampType{Unit: arrow.Microsecond}
+
+	case "bytes", "bytea", "binary", "varbinary", "blob", "fixed":
+		return arrow.BinaryTypes.Binary
+
+	// Numbers without a precision, such as those of JSON or Oracle, may
+	// not fit a float or decimal so they are written as text.
+	case "decimal", "numeric", "number":
+		var p, s int32
+		m := strings.ToLower(strings.ReplaceAll(typ, " ", ""))
+		if _, err := fmt.Sscanf(m, t+"(%d,%d)", &p, &s); err != nil {
+			s = 0
+			if _, err := fmt.Sscanf(m, t+"(%d)", &p); err != nil {
+				p = 0
+			}
+		}
+		if p > 0 && p <= 38 && s >= 0 && s <= p {
+			return &arrow.Decimal128Type{Precision: p, Scale: s}
+		}
+		return arrow.BinaryTypes.String
+
+	default:
+		if isIntegerType(t) {
+			return arrow.PrimitiveTypes.Int64
+		}
+	}
+
+	return arrow.BinaryTypes.String
+}
+
+// arrowAppend appends a value returned by Row.Value to the builder.
+func arrowAppend(b array.Builder, v interface{}) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+
+	switch b := b.(type) {
+	case *array.Int64Builder:
+		i, err := arrowInt(v)
+		if err != nil {
+			return err
+		}
+		b.Append(i)
+
+	case *array.Uint64Builder:
+		i, err := arrowUint(v)
+		if err != nil {
+			return err
+		}
+		b.Append(i)
+
+	case *array.Float64Builder:
+		f, err := arrowFloat(v)
+		if err != nil {
+			return err
+		}
+		b.Append(f)
+
+	case *array.BooleanBuilder:
+		switch x := v.(type) {
+		case bool:
+			b.Append(x)
+		default:
+			p, err := strconv.ParseBool(arrowString(v))
+			if err != nil {
+				return err
+			}
+			b.Append(p)
+		}
+
+	case *array.Date32Builder:
+		t, err := arrowTime(v)
+		if err != nil {
+			return err
+		}
+		b.Append(arrow.Date32FromTime(t))
+
+	case *array.TimestampBuilder:
+		t, err := arrowTime(v)
+		if err != nil {
+			return err
+		}
+		b.Append(arrow.Timestamp(t.UnixMicro()))
+
+	case *array.BinaryBuilder:
+		switch x := v.(type) {
+		case []byte:
+			b.Append(x)
+		default:
+			b.Append([]byte(arrowString(v)))
+		}
+
+	case *array.Decimal128Builder:
+		dt := b.Type().(*arrow.Decimal128Type)
+		d, err := decimal128.FromString(arrowString(v), dt.Precision, dt.Scale)
+		if err != nil {
+			return err
+		}
+		b.Append(d)
+
+	case *array.StringBuilder:
+		b.Append(arrowString(v))
+
+	default:
+		return fmt.Errorf("unsupported builder %T", b)
+	}
+
+	return nil
+}
+
+// arrowString formats a value as text. Times are formatted in RFC 3339
+// format, times of day as 15:04:05.999999 and nested values as JSON.
+func arrowString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	case json.Number:
+		return string(x)
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	case time.Duration:
+		return time.Time{}.Add(x).Format("15:04:05.999999")
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(x)
+		if err == nil {
+			return string(b)
+		}
+	}
+
+	return fmt.Sprint(v)
+}
+
+func arrowInt(v interface{}) (int64, error) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), nil
+	case int8:
+		return int64(x), nil
+	case int16:
+		return int64(x), nil
+	case int32:
+		return int64(x), nil
+	case int64:
+		return x, nil
+	case uint8:
+		return int64(x), nil
+	case uint16:
+		return int64(x), nil
+	case uint32:
+		return int64(x), nil
+	case uint64:
+		if x > math.MaxInt64 {
+			return 0, fmt.Errorf("%d overflows int64", x)
+		}
+		return int64(x), nil
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1<<63 {
+			return int64(x), nil
+		}
+		return 0, fmt.Errorf("%v is not an integer", x)
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(arrowString(v)), 10, 64)
+}
+
+func arrowUint(v interface{}) (uint64, error) {
+	switch x := v.(type) {
+	case uint64:
+		return x, nil
+	case int, int8, int16, int32, int64, uint8, uint16, uint32, float64:
+		i, err := arrowInt(v)
+		if err != nil {
+			return 0, err
+		}
+		if i < 0 {
+			return 0, fmt.Errorf("%d is negative", i)
+		}
+		return uint64(i), nil
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(arrowString(v)), 10, 64)
+}
+
+func arrowFloat(v interface{}) (float64, error) {
+	switch x := v.(type) {
+	case float32:
+		return float64(x), nil
+	case float64:
+		return x, nil
+	case int, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+		i, err := arrowInt(v)
+		return float64(i), err
+	}
+
+	return strconv.ParseFloat(strings.TrimSpace(arrowString(v)), 64)
+}
+
+func arrowTime(v interface{}) (time.Time, error) {
+	if t, ok := v.(time.Time); ok {
+		return t, nil
+	}
+
+	s := arrowString(v)
+
+	t, ok := parseTime([]byte(s))
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid time `%s`", s)
+	}
+
+	return t, nil
+}
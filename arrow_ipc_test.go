@@ -0,0 +1,413 @@
+package difftable
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// writeArrow writes the rows to an Arrow IPC file or, as two record
+// batches, to a stream. The color column is dictionary encoded and files
+// only support a single dictionary per column.
+func writeArrow(t *testing.T, stream bool, ids []int64, colors []string) []byte {
+	sc := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "color", Type: &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int32, ValueType: arrow.BinaryTypes.String}, Nullable: true},
+	}, nil)
+
+	var buf bytes.Buffer
+
+	var w interface {
+		Write(arrow.RecordBatch) error
+		Close() error
+	}
+
+	if stream {
+		w = ipc.NewWriter(&buf, ipc.WithSchema(sc))
+	} else {
+		fw, err := ipc.NewFileWriter(&buf, ipc.WithSchema(sc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = fw
+	}
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, sc)
+	defer b.Release()
+
+	for i := range ids {
+		b.Field(0).(*array.Int64Builder).Append(ids[i])
+		if colors[i] == "" {
+			b.Field(1).AppendNull()
+		} else if err := b.Field(1).(*array.BinaryDictionaryBuilder).AppendString(colors[i]); err != nil {
+			t.Fatal(err)
+		}
+
+		if (stream && i == len(ids)/2) || i == len(ids)-1 {
+			rec := b.NewRecordBatch()
+			if err := w.Write(rec); err != nil {
+				t.Fatal(err)
+			}
+			rec.Release()
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestArrowTable(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		data := writeArrow(t, stream, []int64{1, 2, 10}, []string{"red", "", "blue"})
+
+		tb, err := ArrowTable(bytes.NewReader(data), []string{"id"}, map[string]string{"color": "colour"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{
+			"id":     "int64",
+			"colour": "string",
+		}
+
+		if s1, s2, ok := jsonEqual(expected, tb.Cols()); !ok {
+			t.Errorf("column types don't match. expected:\n%s\ngot:\n%s", s1, s2)
+		}
+
+		var colors []interface{}
+		for {
+			ok, err := tb.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			colors = append(colors, tb.Row().Value("colour"))
+		}
+
+		if s1, s2, ok := jsonEqual([]interface{}{"red", nil, "blue"}, colors); !ok {
+			t.Errorf("unexpected values (stream %v). expected:\n%s\ngot:\n%s", stream, s1, s2)
+		}
+	}
+}
+
+func TestArrowTableSortNested(t *testing.T) {
+	sc := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "tags", Type: arrow.ListOf(arrow.BinaryTypes.String), Nullable: true},
+		{Name: "point", Type: arrow.StructOf(arrow.Field{Name: "x", Type: arrow.PrimitiveTypes.Int64}), Nullable: true},
+		{Name: "attrs", Type: arrow.MapOf(arrow.BinaryTypes.String, arrow.PrimitiveTypes.Int64), Nullable: true},
+	}, nil)
+
+	var buf bytes.Buffer
+	w := ipc.NewWriter(&buf, ipc.WithSchema(sc))
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, sc)
+	defer b.Release()
+
+	// Rows in descending key order so they are sorted in spilled runs.
+	n := 200
+	for i := n; i > 0; i-- {
+		b.Field(0).(*array.Int64Builder).Append(int64(i))
+
+		lb := b.Field(1).(*array.ListBuilder)
+		lb.Append(true)
+		lb.ValueBuilder().(*array.StringBuilder).Append("a")
+		lb.ValueBuilder().(*array.StringBuilder).Append(fmt.Sprint(i))
+
+		sb := b.Field(2).(*array.StructBuilder)
+		sb.Append(true)
+		sb.FieldBuilder(0).(*array.Int64Builder).Append(int64(i))
+
+		mb := b.Field(3).(*array.MapBuilder)
+		mb.Append(true)
+		mb.KeyBuilder().(*array.StringBuilder).Append("n")
+		mb.ItemBuilder().(*array.Int64Builder).Append(int64(i))
+	}
+
+	rec := b.NewRecordBatch()
+	if err := w.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	rec.Release()
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tb, err := ArrowTable(&buf, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := Sort(tb, SortOptions{MemoryLimit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= n; i++ {
+		ok, err := st.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("expected %d rows, got %d", n, i-1)
+		}
+
+		r := st.Row()
+
+		expected := map[string]interface{}{
+			"id":    i,
+			"tags":  []interface{}{"a", fmt.Sprint(i)},
+			"point": map[string]interface{}{"x": i},
+			"attrs": map[string]interface{}{"n": i},
+		}
+
+		actual := map[string]interface{}{
+			"id":    r.Value("id"),
+			"tags":  r.Value("tags"),
+			"point": r.Value("point"),
+			"attrs": r.Value("attrs"),
+		}
+
+		if s1, s2, ok := jsonEqual(expected, actual); !ok {
+			t.Fatalf("unexpected row. expected:\n%s\ngot:\n%s", s1, s2)
+		}
+	}
+}
+
+func TestWriteArrowSnapshot(t *testing.T) {
+	rdr := writeParquet(t, []parquetTestRow{
+		{1, "John", 17532, 1050},
+		{2, "", 17533, -25},
+		{10, "Sam", 17534, 0},
+	})
+	defer rdr.Close()
+
+	tb, err := ParquetTable(rdr, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteArrowSnapshot(&buf, tb, ArrowWriterOptions{BatchSize: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	rdr2 := writeParquet(t, []parquetTestRow{
+		{1, "John", 17532, 1050},
+		{2, "", 17533, -25},
+		{10, "Sam", 17534, 0},
+	})
+	defer rdr2.Close()
+
+	t1, err := ArrowTable(&buf, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := ParquetTable(rdr2, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s1, s2, ok := jsonEqual(t2.Cols(), t1.Cols()); !ok {
+		t.Errorf("column types don't match. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	diff, err := Diff(t1, t2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.RowsAdded != 0 || diff.RowsDeleted != 0 || diff.RowsChanged != 0 {
+		t.Errorf("expected the snapshot to match the table, got %+v", diff)
+	}
+}
+
+func TestArrowEventWriter(t *testing.T) {
+	key := []string{"id"}
+
+	csv := func(s string) Table {
+		tb, err := CSVTable(NewCSVReader(bytes.NewBufferString(s), ','), key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tb
+	}
+
+	t1 := csv(csvTable1)
+	t2 := csv(csvTable2)
+
+	var buf bytes.Buffer
+
+	w, err := NewArrowEventWriter(&buf, t1, t2, ArrowWriterOptions{Stream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DiffEvents(t1, t2, w.Write); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tb, err := ArrowTable(&buf, []string{"offset"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ty := tb.Cols()["changed"]; ty != "list" {
+		t.Errorf("expected changed to be a list, got %s", ty)
+	}
+
+	events := make(map[string]int)
+	for {
+		ok, err := tb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+
+		r := tb.Row()
+		typ := r.Value("event").(string)
+		events[typ]++
+
+		if typ == EventRowChanged && r.Value("changed") == nil {
+			t.Errorf("expected changed columns for %s", r.Value("id"))
+		}
+		if typ != EventRowChanged && r.Value("changed") != nil {
+			t.Errorf("expected no changed columns for %s", typ)
+		}
+	}
+
+	diff, err := Diff(csv(csvTable1), csv(csvTable2), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]int{
+		EventRowAdded:   diff.RowsAdded,
+		EventRowRemoved: diff.RowsDeleted,
+		EventRowChanged: diff.RowsChanged,
+	}
+	for k, v := range expected {
+		if v == 0 {
+			delete(expected, k)
+		}
+	}
+
+	if s1, s2, ok := jsonEqual(expected, events); !ok {
+		t.Errorf("unexpected events. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestWriteArrowSnapshotTypes(t *testing.T) {
+	tb := &sliceTable{
+		key: []string{"id"},
+		cols: map[string]string{
+			"id":     "uint64",
+			"amount": "NUMBER(38)",
+			"price":  "number(10,2)",
+			"score":  "number",
+		},
+		rows: []map[string]interface{}{
+			{
+				"id":     uint64(math.MaxUint64),
+				"amount": "12345678901234567890123456789012345678",
+				"price":  "12.50",
+				"score":  "0.1000000000000000055511151231257827",
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteArrowSnapshot(&buf, tb, ArrowWriterOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	at, err := ArrowTable(&buf, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"id":     "uint64",
+		"amount": "decimal(38,0)",
+		"price":  "decimal(10,2)",
+		"score":  "string",
+	}
+	if s1, s2, ok := jsonEqual(expected, at.Cols()); !ok {
+		t.Errorf("unexpected column types. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	if ok, err := at.Next(); err != nil || !ok {
+		t.Fatalf("expected a row, got %v, %v", ok, err)
+	}
+
+	r := at.Row()
+	for c, v := range map[string]string{
+		"id":     "18446744073709551615",
+		"amount": "12345678901234567890123456789012345678",
+		"price":  "12.50",
+		"score":  "0.1000000000000000055511151231257827",
+	} {
+		if b := string(r.Bytes(c)); b != v {
+			t.Errorf("%s: expected %s, got %s", c, v, b)
+		}
+	}
+}
+
+func TestArrowFieldTypeUnsigned(t *testing.T) {
+	for _, typ := range []string{"UNSIGNED BIGINT", "int(10) unsigned", "uint64"} {
+		if dt := arrowFieldType(typ); dt != arrow.PrimitiveTypes.Uint64 {
+			t.Errorf("%s: expected uint64, got %s", typ, dt)
+		}
+	}
+}
+
+func TestArrowEventWriterError(t *testing.T) {
+	tb := &sliceTable{
+		key:  []string{"id"},
+		cols: map[string]string{"id": "integer", "name": "string"},
+	}
+
+	var buf bytes.Buffer
+
+	w, err := NewArrowEventWriter(&buf, tb, tb, ArrowWriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Write(&Event{
+		Type: EventRowAdded,
+		Data: map[string]interface{}{"id": "x", "name": "a"},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a non-integer id")
+	}
+
+	err2 := w.Write(&Event{
+		Type: EventRowAdded,
+		Data: map[string]interface{}{"id": 1, "name": "a"},
+	})
+	if err2 != err {
+		t.Errorf("expected the first error after a failed write, got %v", err2)
+	}
+
+	if err := w.Close(); err != err2 {
+		t.Errorf("expected the first error on close, got %v", err)
+	}
+}
@@ -1,31 +1,75 @@
 package difftable
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/linkedin/goavro"
 )
 
 type avroRow struct {
+	fields map[string]*avroField
 	record map[string]interface{}
 }
 
+// Bytes returns the value as text so it compares with the values of other
+// sources. Null values are nil, numbers are exact, dates and times are
+// formatted like other sources and arrays, maps and records are encoded as
+// JSON with sorted keys.
 func (r *avroRow) Bytes(col string) []byte {
-	val := r.record[col]
-	return []byte(fmt.Sprint(val))
+	f, ok := r.fields[col]
+	if !ok {
+		return nil
+	}
+
+	switch x := r.Value(col).(type) {
+	case []byte:
+		return x
+	case float32:
+		return []byte(strconv.FormatFloat(float64(x), 'f', -1, 32))
+	case float64:
+		return []byte(strconv.FormatFloat(x, 'f', -1, 64))
+	case time.Duration:
+		return []byte(formatClock(x))
+	case time.Time:
+		if f.Type.String() == "date" {
+			return []byte(x.Format("2006-01-02"))
+		}
+		return []byte(x.Format(time.RFC3339Nano))
+	case int32:
+		return []byte(strconv.FormatInt(int64(x), 10))
+	case int64:
+		return []byte(strconv.FormatInt(x, 10))
+	default:
+		return jsonBytes(x)
+	}
+}
+
+// CanonicalBytes returns the canonical encoding of the value so values of
+// different types, such as null and "<nil>", never compare equal when both
+// tables are Avro tables.
+func (r *avroRow) CanonicalBytes(col string) []byte {
+	return CanonicalBytes(r.Value(col))
 }
 
 func (r *avroRow) Value(col string) interface{} {
-	return r.record[col]
+	f, ok := r.fields[col]
+	if !ok {
+		return nil
+	}
+
+	return f.Type.value(r.record[f.Name])
 }
 
 type avroTable struct {
-	rdr    *goavro.OCFReader
-	key    []string
-	cols   map[string]string
-	record map[string]interface{}
+	rdr     *goavro.OCFReader
+	key     []string
+	cols    map[string]string
+	colInfo []*Column
+	names   []string
+	fields  map[string]*avroField
+	record  map[string]interface{}
 }
 
 func (a *avroTable) Key() []string {
@@ -36,8 +80,36 @@ func (a *avroTable) Cols() map[string]string {
 	return a.cols
 }
 
+// Columns returns the fields of the record schema in order. Fields of a
+// union with null are nullable.
+func (a *avroTable) Columns() []*Column {
+	return a.colInfo
+}
+
+// Schema returns the fields of the record schema.
+func (a *avroTable) Schema() interface{} {
+	return &avroColumns{
+		names:  a.names,
+		fields: a.fields,
+	}
+}
+
+// DiffSchema compares the record schema to the schema of an older Avro
+// table, reporting changes in nullability, defaults and enum symbols,
+// nested fields that were added, removed or changed and columns and fields
+// renamed according to their aliases.
+func (a *avroTable) DiffSchema(old interface{}) (*SchemaDiff, error) {
+	o, ok := old.(*avroColumns)
+	if !ok {
+		return nil, nil
+	}
+
+	return diffAvroColumns(o, a.Schema().(*avroColumns)), nil
+}
+
 func (a *avroTable) Row() Row {
 	return &avroRow{
+		fields: a.fields,
 		record: a.record,
 	}
 }
@@ -57,6 +129,9 @@ func (a *avroTable) Next() (bool, error) {
 	return true, nil
 }
 
+// AvroTable returns a table of the records in an Avro object container file.
+// The schema must be a record. Columns are typed by their schema, see
+// avroSchema.String, and values are converted from their logical types.
 func AvroTable(rdr *goavro.OCFReader, key []string, renames map[string]string) (Table, error) {
 	for i, k := range key {
 		if n, ok := renames[k]; ok {
@@ -64,39 +139,33 @@ func AvroTable(rdr *goavro.OCFReader, key []string, renames map[string]string) (
 		}
 	}
 
-	var m map[string]interface{}
-	err := json.Unmarshal([]byte(rdr.Codec().Schema()), &m)
+	s, err := parseAvroSchema(rdr.Codec().Schema())
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("avro schema: %s", err)
 	}
 
-	if t, ok := m["type"].(string); !ok || t != "record" {
-		return nil, fmt.Errorf("record schema required, got %s", t)
+	if s.Type != "record" {
+		return nil, fmt.Errorf("record schema required, got %s", s.Type)
 	}
 
-	fields, ok := m["fields"].([]interface{})
-	if !ok {
-		return nil, errors.New("could not parse record fields")
+	t := &avroTable{
+		rdr:    rdr,
+		key:    key,
+		cols:   make(map[string]string, len(s.Fields)),
+		fields: make(map[string]*avroField, len(s.Fields)),
 	}
 
-	cols := make(map[string]string)
-	for _, x := range fields {
-		f := x.(map[string]interface{})
-
-		name, ok := f["name"].(string)
-		if !ok {
-			return nil, errors.New("invalid field name")
-		}
-		ftype, ok := f["type"].(string)
-		if !ok {
-			return nil, errors.New("invalid field type")
+	for _, f := range s.Fields {
+		name := f.Name
+		if n, ok := renames[name]; ok {
+			name = n
 		}
-		cols[name] = ftype
+
+		t.cols[name] = f.Type.String()
+		t.fields[name] = f
+		t.names = append(t.names, name)
+		t.colInfo = append(t.colInfo, f.column(name))
 	}
 
-	return &avroTable{
-		rdr:  rdr,
-		key:  key,
-		cols: cols,
-	}, nil
+	return t, nil
 }
@@ -0,0 +1,289 @@
+package difftable
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// avroColumns is the schema of an avroTable returned by Schema.
+type avroColumns struct {
+	// Column names in schema order.
+	names  []string
+	fields map[string]*avroField
+}
+
+// avroSchemaDiff collects the events of an Avro schema diff.
+type avroSchemaDiff struct {
+	diff *SchemaDiff
+
+	// Pairs of record types being compared on the current path, so
+	// recursive types end.
+	seen map[[2]*avroSchema]bool
+}
+
+// diffAvroColumns compares the fields of two record schemas. Columns are
+// matched by name or, if a new field lists the name of an old field that
+// is no longer present among its aliases, as a rename.
+func diffAvroColumns(old, cur *avroColumns) *SchemaDiff {
+	d := &avroSchemaDiff{
+		diff: &SchemaDiff{
+			Renamed: make(map[string]string),
+		},
+		seen: make(map[[2]*avroSchema]bool),
+	}
+
+	// Old columns by the name of their field in the schema, which may
+	// differ from the column name if it was renamed by the caller.
+	oldByField := make(map[string]string, len(old.names))
+	for _, c := range old.names {
+		oldByField[old.fields[c].Name] = c
+	}
+
+	// Old columns already matched by a rename.
+	taken := make(map[string]bool)
+
+	for _, c := range cur.names {
+		if _, ok := old.fields[c]; ok {
+			continue
+		}
+
+		for _, a := range cur.fields[c].Aliases {
+			oc := a
+			if _, ok := old.fields[oc]; !ok {
+				if oc, ok = oldByField[a]; !ok {
+					continue
+				}
+			}
+
+			// The old column is still present or already renamed.
+			if _, ok := cur.fields[oc]; ok || taken[oc] {
+				continue
+			}
+
+			d.diff.Renamed[c] = oc
+			taken[oc] = true
+			break
+		}
+	}
+
+	for _, c := range cur.names {
+		oc := c
+		if n, ok := d.diff.Renamed[c]; ok {
+			oc = n
+		}
+
+		of, ok := old.fields[oc]
+		if !ok {
+			continue
+		}
+
+		d.field(c, "", of, cur.fields[c])
+	}
+
+	return d.diff
+}
+
+// avroFieldPath returns the path of a field nested at the path.
+func avroFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func (d *avroSchemaDiff) emit(e *Event) {
+	d.diff.Events = append(d.diff.Events, e)
+}
+
+// field compares a field of the column at the path.
+func (d *avroSchemaDiff) field(col, path string, of, nf *avroField) {
+	_, on := of.Type.nullable()
+	_, nn := nf.Type.nullable()
+
+	if on != nn {
+		d.emit(&Event{
+			Type:        EventNullability,
+			Column:      col,
+			Field:       path,
+			OldNullable: &on,
+			NewNullable: &nn,
+		})
+	}
+
+	od := avroDefault(of)
+	nd := avroDefault(nf)
+
+	if !bytes.Equal(od, nd) {
+		d.emit(&Event{
+			Type:       EventDefaultChanged,
+			Column:     col,
+			Field:      path,
+			OldDefault: od,
+			NewDefault: nd,
+		})
+	}
+
+	d.typ(col, path, of.Type, nf.Type)
+}
+
+// avroDefault returns the default of a field as JSON or nil if it has
+// none.
+func avroDefault(f *avroField) json.RawMessage {
+	if !f.HasDefault {
+		return nil
+	}
+
+	b, err := json.Marshal(f.Default)
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
+
+// typ compares the types of a field. Changes to the type of a column are
+// reported as column-changed events, so only nested fields are reported
+// here.
+func (d *avroSchemaDiff) typ(col, path string, ot, nt *avroSchema) {
+	if t, ok := ot.nullable(); ok {
+		ot = t
+	}
+	if t, ok := nt.nullable(); ok {
+		nt = t
+	}
+
+	if path != "" && ot.String() != nt.String() {
+		d.emit(&Event{
+			Type:    EventFieldChanged,
+			Column:  col,
+			Field:   path,
+			OldType: ot.String(),
+			NewType: nt.String(),
+		})
+	}
+
+	if ot.Type != nt.Type {
+		return
+	}
+
+	switch ot.Type {
+	case "enum":
+		added, removed := avroSymbolChanges(ot.Symbols, nt.Symbols)
+
+		if len(added) > 0 || len(removed) > 0 {
+			d.emit(&Event{
+				Type:           EventSymbolsChanged,
+				Column:         col,
+				Field:          path,
+				SymbolsAdded:   added,
+				SymbolsRemoved: removed,
+			})
+		}
+
+	case "record":
+		pair := [2]*avroSchema{ot, nt}
+		if d.seen[pair] {
+			return
+		}
+		d.seen[pair] = true
+
+		d.record(col, path, ot, nt)
+
+		delete(d.seen, pair)
+
+	case "array":
+		d.typ(col, path+"[]", ot.Items, nt.Items)
+
+	case "map":
+		d.typ(col, path+"[]", ot.Values, nt.Values)
+	}
+}
+
+// record compares the fields of nested records.
+func (d *avroSchemaDiff) record(col, path string, ot, nt *avroSchema) {
+	oldFields := make(map[string]*avroField, len(ot.Fields))
+	for _, f := range ot.Fields {
+		oldFields[f.Name] = f
+	}
+
+	newFields := make(map[string]bool, len(nt.Fields))
+	for _, f := range nt.Fields {
+		newFields[f.Name] = true
+	}
+
+	matched := make(map[string]bool, len(ot.Fields))
+
+	for _, nf := range nt.Fields {
+		p := avroFieldPath(path, nf.Name)
+
+		of, ok := oldFields[nf.Name]
+
+		if !ok {
+			for _, a := range nf.Aliases {
+				if f, ok := oldFields[a]; ok && !newFields[a] && !matched[a] {
+					of = f
+
+					d.emit(&Event{
+						Type:     EventFieldRenamed,
+						Column:   col,
+						Field:    p,
+						OldField: avroFieldPath(path, a),
+					})
+					break
+				}
+			}
+		}
+
+		if of == nil {
+			d.emit(&Event{
+				Type:    EventFieldAdded,
+				Column:  col,
+				Field:   p,
+				NewType: nf.Type.String(),
+			})
+			continue
+		}
+
+		matched[of.Name] = true
+
+		d.field(col, p, of, nf)
+	}
+
+	for _, of := range ot.Fields {
+		if matched[of.Name] {
+			continue
+		}
+
+		d.emit(&Event{
+			Type:    EventFieldRemoved,
+			Column:  col,
+			Field:   avroFieldPath(path, of.Name),
+			OldType: of.Type.String(),
+		})
+	}
+}
+
+// avroSymbolChanges returns the symbols added to and removed from an enum.
+func avroSymbolChanges(old, cur []string) (added, removed []string) {
+	oldSet := make(map[string]bool, len(old))
+	for _, s := range old {
+		oldSet[s] = true
+	}
+
+	curSet := make(map[string]bool, len(cur))
+	for _, s := range cur {
+		curSet[s] = true
+
+		if !oldSet[s] {
+			added = append(added, s)
+		}
+	}
+
+	for _, s := range old {
+		if !curSet[s] {
+			removed = append(removed, s)
+		}
+	}
+
+	return added, removed
+}
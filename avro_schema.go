@@ -0,0 +1,504 @@
+package difftable
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// avroSchema is a parsed Avro schema.
+type avroSchema struct {
+	// Type is the primitive type name or one of record, enum, array, map,
+	// fixed or union.
+	Type string
+
+	// Name is the full name of records, enums and fixed types.
+	Name    string
+	Aliases []string
+
+	// Logical is the logical type if it is valid for the type.
+	Logical   string
+	Precision int
+	Scale     int
+
+	Size    int
+	Symbols []string
+	Fields  []*avroField
+	Items   *avroSchema
+	Values  *avroSchema
+
+	// Branches are the types of a union.
+	Branches []*avroSchema
+}
+
+// avroField is a field of a record.
+type avroField struct {
+	Name    string
+	Aliases []string
+	Type    *avroSchema
+
+	Default    interface{}
+	HasDefault bool
+}
+
+var avroPrimitives = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"int":     true,
+	"long":    true,
+	"float":   true,
+	"double":  true,
+	"bytes":   true,
+	"string":  true,
+}
+
+// parseAvroSchema parses the JSON of an Avro schema.
+func parseAvroSchema(s string) (*avroSchema, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+
+	p := &avroSchemaParser{
+		named: make(map[string]*avroSchema),
+	}
+
+	return p.parse(v, "")
+}
+
+// avroSchemaParser tracks named types so later references resolve.
+type avroSchemaParser struct {
+	named map[string]*avroSchema
+}
+
+// avroFullName qualifies a name with the namespace unless it already is.
+func avroFullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// avroNamespace returns the namespace of a full name.
+func avroNamespace(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+func (p *avroSchemaParser) parse(v interface{}, namespace string) (*avroSchema, error) {
+	switch x := v.(type) {
+	case string:
+		if avroPrimitives[x] {
+			return &avroSchema{Type: x}, nil
+		}
+		if s, ok := p.named[avroFullName(x, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := p.named[x]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown type `%s`", x)
+
+	case []interface{}:
+		s := &avroSchema{Type: "union"}
+		for _, b := range x {
+			bs, err := p.parse(b, namespace)
+			if err != nil {
+				return nil, err
+			}
+			s.Branches = append(s.Branches, bs)
+		}
+		return s, nil
+
+	case map[string]interface{}:
+		return p.parseMap(x, namespace)
+	}
+
+	return nil, fmt.Errorf("invalid schema %v", v)
+}
+
+func (p *avroSchemaParser) parseMap(m map[string]interface{}, namespace string) (*avroSchema, error) {
+	var s *avroSchema
+
+	switch t := m["type"].(type) {
+	case string:
+		switch t {
+		case "record", "error", "enum", "fixed":
+			var err error
+			if s, err = p.parseNamed(t, m, namespace); err != nil {
+				return nil, err
+			}
+
+		case "array":
+			items, err := p.parse(m["items"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("array items: %s", err)
+			}
+			s = &avroSchema{Type: t, Items: items}
+
+		case "map":
+			values, err := p.parse(m["values"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("map values: %s", err)
+			}
+			s = &avroSchema{Type: t, Values: values}
+
+		default:
+			ps, err := p.parse(t, namespace)
+			if err != nil {
+				return nil, err
+			}
+
+			// Copy primitives so the logical type can be set.
+			if !avroPrimitives[t] {
+				return ps, nil
+			}
+			c := *ps
+			s = &c
+		}
+
+	case nil:
+		return nil, fmt.Errorf("missing type")
+
+	default:
+		return p.parse(t, namespace)
+	}
+
+	if l, ok := m["logicalType"].(string); ok {
+		p.parseLogical(s, l, m)
+	}
+
+	return s, nil
+}
+
+// parseNamed parses records, enums and fixed types.
+func (p *avroSchemaParser) parseNamed(t string, m map[string]interface{}, namespace string) (*avroSchema, error) {
+	name, ok := m["name"].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("%s without a name", t)
+	}
+	if ns, ok := m["namespace"].(string); ok && ns != "" {
+		namespace = ns
+	}
+
+	s := &avroSchema{
+		Type:    t,
+		Name:    avroFullName(name, namespace),
+		Aliases: avroStrings(m["aliases"]),
+	}
+	if t == "error" {
+		s.Type = "record"
+	}
+
+	// Register before parsing fields so records can refer to themselves.
+	p.named[s.Name] = s
+
+	namespace = avroNamespace(s.Name)
+
+	switch s.Type {
+	case "enum":
+		s.Symbols = avroStrings(m["symbols"])
+
+	case "fixed":
+		size, ok := m["size"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("fixed `%s` without a size", s.Name)
+		}
+		s.Size = int(size)
+
+	case "record":
+		fields, ok := m["fields"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record `%s` without fields", s.Name)
+		}
+
+		for _, x := range fields {
+			fm, ok := x.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("record `%s`: invalid field", s.Name)
+			}
+
+			fname, ok := fm["name"].(string)
+			if !ok {
+				return nil, fmt.Errorf("record `%s`: invalid field name", s.Name)
+			}
+
+			ft, err := p.parse(fm["type"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("field `%s`: %s", fname, err)
+			}
+
+			f := &avroField{
+				Name:    fname,
+				Aliases: avroStrings(fm["aliases"]),
+				Type:    ft,
+			}
+			f.Default, f.HasDefault = fm["default"]
+
+			s.Fields = append(s.Fields, f)
+		}
+	}
+
+	return s, nil
+}
+
+// parseLogical sets the logical type if it is valid for the underlying type.
+// Invalid logical types are ignored as the specification requires.
+func (p *avroSchemaParser) parseLogical(s *avroSchema, l string, m map[string]interface{}) {
+	switch l {
+	case "date", "time-millis":
+		if s.Type != "int" {
+			return
+		}
+
+	case "time-micros", "timestamp-millis", "timestamp-micros",
+		"local-timestamp-millis", "local-timestamp-micros":
+		if s.Type != "long" {
+			return
+		}
+
+	case "uuid":
+		if s.Type != "string" {
+			return
+		}
+
+	case "decimal":
+		if s.Type != "bytes" && s.Type != "fixed" {
+			return
+		}
+
+		precision, _ := m["precision"].(float64)
+		scale, _ := m["scale"].(float64)
+
+		if precision <= 0 || scale < 0 || scale > precision {
+			return
+		}
+
+		s.Precision = int(precision)
+		s.Scale = int(scale)
+
+	default:
+		return
+	}
+
+	s.Logical = l
+}
+
+// avroStrings returns the strings in a decoded JSON array.
+func avroStrings(v interface{}) []string {
+	a, _ := v.([]interface{})
+
+	var s []string
+	for _, x := range a {
+		if str, ok := x.(string); ok {
+			s = append(s, str)
+		}
+	}
+
+	return s
+}
+
+// nullable returns the non-null type of a union of null and one other type.
+func (s *avroSchema) nullable() (*avroSchema, bool) {
+	if s.Type != "union" || len(s.Branches) != 2 {
+		return nil, false
+	}
+
+	switch {
+	case s.Branches[0].Type == "null":
+		return s.Branches[1], true
+	case s.Branches[1].Type == "null":
+		return s.Branches[0], true
+	}
+
+	return nil, false
+}
+
+// branchName is the name goavro uses for the type as a key of union values.
+func (s *avroSchema) branchName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Type
+}
+
+// String returns the type name reported by Table.Cols. Logical types are
+// named for what they represent, e.g. date or decimal(10,2), nullable
+// unions by their non-null type and other unions as union<int,string>.
+func (s *avroSchema) String() string {
+	switch s.Logical {
+	case "date":
+		return "date"
+	case "time-millis", "time-micros":
+		return "time"
+	case "timestamp-millis", "timestamp-micros":
+		return "timestamptz"
+	case "local-timestamp-millis", "local-timestamp-micros":
+		return "timestamp"
+	case "uuid":
+		return "uuid"
+	case "decimal":
+		return fmt.Sprintf("decimal(%d,%d)", s.Precision, s.Scale)
+	}
+
+	switch s.Type {
+	case "fixed":
+		return fmt.Sprintf("fixed(%d)", s.Size)
+	case "array":
+		return "array<" + s.Items.String() + ">"
+	case "map":
+		return "map<" + s.Values.String() + ">"
+	case "union":
+		if t, ok := s.nullable(); ok {
+			return t.String()
+		}
+
+		names := make([]string, len(s.Branches))
+		for i, b := range s.Branches {
+			names[i] = b.String()
+		}
+		return "union<" + strings.Join(names, ",") + ">"
+	}
+
+	return s.Type
+}
+
+// column describes a field as a Column.
+func (f *avroField) column(name string) *Column {
+	c := &Column{
+		Name:        name,
+		Type:        f.Type.String(),
+		HasNullable: true,
+	}
+
+	t := f.Type
+	if n, ok := t.nullable(); ok {
+		c.Nullable = true
+		t = n
+	} else if t.Type == "null" {
+		c.Nullable = true
+	}
+
+	if t.Logical == "decimal" {
+		c.Precision = int64(t.Precision)
+		c.Scale = int64(t.Scale)
+		c.HasPrecisionScale = true
+	}
+	if t.Type == "fixed" {
+		c.Length = int64(t.Size)
+		c.HasLength = true
+	}
+
+	return c
+}
+
+// value converts a value decoded by goavro to the Go value of the type.
+// Union values are unwrapped, dates and timestamps become time.Time, times
+// of day time.Duration and decimals json.Number.
+func (s *avroSchema) value(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	switch s.Type {
+	case "union":
+		// goavro wraps union values in a map keyed by the branch name.
+		m, ok := v.(map[string]interface{})
+		if !ok || len(m) != 1 {
+			return v
+		}
+		for n, x := range m {
+			for _, b := range s.Branches {
+				if b.branchName() == n {
+					return b.value(x)
+				}
+			}
+			return x
+		}
+
+	case "record":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		r := make(map[string]interface{}, len(m))
+		for _, f := range s.Fields {
+			r[f.Name] = f.Type.value(m[f.Name])
+		}
+		return r
+
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return v
+		}
+		r := make([]interface{}, len(a))
+		for i, x := range a {
+			r[i] = s.Items.value(x)
+		}
+		return r
+
+	case "map":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		r := make(map[string]interface{}, len(m))
+		for k, x := range m {
+			r[k] = s.Values.value(x)
+		}
+		return r
+	}
+
+	switch s.Logical {
+	case "date":
+		if d, ok := v.(int32); ok {
+			return time.Unix(int64(d)*86400, 0).UTC()
+		}
+
+	case "time-millis":
+		if d, ok := v.(int32); ok {
+			return time.Duration(d) * time.Millisecond
+		}
+
+	case "time-micros":
+		if d, ok := v.(int64); ok {
+			return time.Duration(d) * time.Microsecond
+		}
+
+	case "timestamp-millis", "local-timestamp-millis":
+		if d, ok := v.(int64); ok {
+			return time.Unix(d/1e3, d%1e3*1e6).UTC()
+		}
+
+	case "timestamp-micros", "local-timestamp-micros":
+		if d, ok := v.(int64); ok {
+			return time.Unix(d/1e6, d%1e6*1e3).UTC()
+		}
+
+	case "decimal":
+		if b, ok := v.([]byte); ok {
+			return avroDecimal(b, s.Scale)
+		}
+	}
+
+	return v
+}
+
+// avroDecimal decodes the big-endian two's complement bytes of a decimal.
+func avroDecimal(b []byte, scale int) json.Number {
+	n := new(big.Int).SetBytes(b)
+
+	// Negative if the sign bit is set.
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+
+	r := new(big.Rat).SetFrac(n, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+
+	return json.Number(r.FloatString(scale))
+}
@@ -0,0 +1,393 @@
+package difftable
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro"
+)
+
+const avroTestSchema = `{
+  "type": "record",
+  "name": "Person",
+  "namespace": "com.example",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "name", "type": ["null", "string"], "default": null},
+    {"name": "born", "type": {"type": "int", "logicalType": "date"}},
+    {"name": "seen", "type": ["null", {"type": "long", "logicalType": "timestamp-millis"}]},
+    {"name": "balance", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+    {"name": "uid", "type": {"type": "string", "logicalType": "uuid"}},
+    {"name": "color", "type": {"type": "enum", "name": "Color", "symbols": ["RED", "BLUE"]}},
+    {"name": "tags", "type": {"type": "array", "items": "string"}},
+    {"name": "scores", "type": {"type": "map", "values": "int"}},
+    {"name": "address", "type": {"type": "record", "name": "Address", "fields": [
+      {"name": "city", "type": "string"},
+      {"name": "zip", "type": ["null", "string"]}
+    ]}},
+    {"name": "home", "type": ["null", "Address"]},
+    {"name": "code", "type": {"type": "fixed", "name": "Code", "size": 2}},
+    {"name": "any", "type": ["int", "string"]}
+  ]
+}`
+
+// writeAvro writes the records to an object container file and returns a
+// reader of it.
+func writeAvro(t *testing.T, schema string, records ...map[string]interface{}) *goavro.OCFReader {
+	var buf bytes.Buffer
+
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:      &buf,
+		Schema: schema,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]interface{}, len(records))
+	for i, r := range records {
+		data[i] = r
+	}
+
+	if err := w.Append(data); err != nil {
+		t.Fatal(err)
+	}
+
+	rdr, err := goavro.NewOCFReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rdr
+}
+
+func TestAvroTable(t *testing.T) {
+	rdr := writeAvro(t, avroTestSchema, map[string]interface{}{
+		"id":      int64(1),
+		"name":    goavro.Union("string", "John"),
+		"born":    int32(366),
+		"seen":    nil,
+		"balance": []byte{0xfe, 0x0c},
+		"uid":     "c1b4d7a2-0b4e-4c59-9a57-2b1e5c6f1a3d",
+		"color":   "BLUE",
+		"tags":    []interface{}{"a", "b"},
+		"scores":  map[string]interface{}{"math": int32(90)},
+		"address": map[string]interface{}{
+			"city": "Trenton",
+			"zip":  goavro.Union("string", "08608"),
+		},
+		"home": goavro.Union("com.example.Address", map[string]interface{}{
+			"city": "Philadelphia",
+			"zip":  nil,
+		}),
+		"code": []byte("PA"),
+		"any":  goavro.Union("int", int32(3)),
+	})
+
+	tb, err := AvroTable(rdr, []string{"id"}, map[string]string{"name": "full_name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCols := map[string]string{
+		"id":        "long",
+		"full_name": "string",
+		"born":      "date",
+		"seen":      "timestamptz",
+		"balance":   "decimal(10,2)",
+		"uid":       "uuid",
+		"color":     "enum",
+		"tags":      "array<string>",
+		"scores":    "map<int>",
+		"address":   "record",
+		"home":      "record",
+		"code":      "fixed(2)",
+		"any":       "union<int,string>",
+	}
+	if s1, s2, ok := jsonEqual(expectedCols, tb.Cols()); !ok {
+		t.Errorf("unexpected columns. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	cols := tb.(ColumnDescriber).Columns()
+	if !cols[1].Nullable || cols[0].Nullable {
+		t.Errorf("expected only full_name to be nullable, got %v and %v", cols[0].Nullable, cols[1].Nullable)
+	}
+	if c := cols[4]; c.Precision != 10 || c.Scale != 2 {
+		t.Errorf("expected decimal(10,2), got precision %d and scale %d", c.Precision, c.Scale)
+	}
+
+	ok, err := tb.Next()
+	if !ok || err != nil {
+		t.Fatalf("expected a row, got %v", err)
+	}
+
+	row := tb.Row()
+
+	expected := map[string]interface{}{
+		"id":        int64(1),
+		"full_name": "John",
+		"born":      time.Date(1971, 1, 2, 0, 0, 0, 0, time.UTC),
+		"seen":      nil,
+		"balance":   json.Number("-5.00"),
+		"uid":       "c1b4d7a2-0b4e-4c59-9a57-2b1e5c6f1a3d",
+		"color":     "BLUE",
+		"tags":      []interface{}{"a", "b"},
+		"scores":    map[string]interface{}{"math": int32(90)},
+		"address":   map[string]interface{}{"city": "Trenton", "zip": "08608"},
+		"home":      map[string]interface{}{"city": "Philadelphia", "zip": nil},
+		"code":      []byte("PA"),
+		"any":       int32(3),
+	}
+
+	got := make(map[string]interface{})
+	for c := range expectedCols {
+		got[c] = row.Value(c)
+	}
+
+	if s1, s2, ok := jsonEqual(expected, got); !ok {
+		t.Errorf("unexpected values. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	if v, ok := row.Value("born").(time.Time); !ok || !v.Equal(expected["born"].(time.Time)) {
+		t.Errorf("expected born to be a time, got %#v", row.Value("born"))
+	}
+}
+
+func TestParseAvroSchemaUnknownType(t *testing.T) {
+	if _, err := parseAvroSchema(`{"type": "record", "name": "Bad", "fields": [{"name": "x", "type": "Missing"}]}`); err == nil {
+		t.Error("expected an error for an unknown type")
+	}
+}
+
+func TestAvroTableNullValues(t *testing.T) {
+	schema := `{"type": "record", "name": "Row", "fields": [
+	  {"name": "id", "type": "long"},
+	  {"name": "name", "type": ["null", "string"]}
+	]}`
+
+	t1, err := AvroTable(writeAvro(t, schema,
+		map[string]interface{}{"id": int64(2), "name": nil},
+		map[string]interface{}{"id": int64(10), "name": goavro.Union("string", "Bob")},
+	), []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := AvroTable(writeAvro(t, schema,
+		map[string]interface{}{"id": int64(2), "name": goavro.Union("string", "<nil>")},
+		map[string]interface{}{"id": int64(10), "name": goavro.Union("string", "Bob")},
+	), []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys 2 and 10 are in numeric order.
+	diff, err := Diff(t1, t2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.RowsChanged != 1 || diff.RowsAdded != 0 || diff.RowsDeleted != 0 {
+		t.Errorf("expected the null name to change, got %d changed, %d added and %d deleted", diff.RowsChanged, diff.RowsAdded, diff.RowsDeleted)
+	}
+}
+
+func TestAvroDiffSchema(t *testing.T) {
+	schema1 := `{"type": "record", "name": "Person", "fields": [
+	  {"name": "id", "type": "long"},
+	  {"name": "name", "type": "string"},
+	  {"name": "nickname", "type": "string"},
+	  {"name": "color", "type": {"type": "enum", "name": "Color", "symbols": ["RED", "BLUE"]}},
+	  {"name": "address", "type": {"type": "record", "name": "Address", "fields": [
+	    {"name": "city", "type": "string"},
+	    {"name": "zip", "type": "string"},
+	    {"name": "number", "type": "int"}
+	  ]}}
+	]}`
+
+	schema2 := `{"type": "record", "name": "Person", "fields": [
+	  {"name": "id", "type": "long"},
+	  {"name": "name", "type": ["null", "string"], "default": null},
+	  {"name": "alias", "type": "string", "aliases": ["nickname"]},
+	  {"name": "color", "type": {"type": "enum", "name": "Color", "symbols": ["RED", "GREEN"]}},
+	  {"name": "address", "type": {"type": "record", "name": "Address", "fields": [
+	    {"name": "city", "type": "string"},
+	    {"name": "postcode", "type": "string", "aliases": ["zip"]},
+	    {"name": "number", "type": "long"},
+	    {"name": "country", "type": "string", "default": "US"}
+	  ]}}
+	]}`
+
+	t1, err := AvroTable(writeAvro(t, schema1, map[string]interface{}{
+		"id":       int64(1),
+		"name":     "John",
+		"nickname": "JJ",
+		"color":    "RED",
+		"address":  map[string]interface{}{"city": "Trenton", "zip": "08608", "number": int32(1)},
+	}), []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := AvroTable(writeAvro(t, schema2, map[string]interface{}{
+		"id":      int64(1),
+		"name":    goavro.Union("string", "John"),
+		"alias":   "JJ",
+		"color":   "RED",
+		"address": map[string]interface{}{"city": "Trenton", "postcode": "08608", "number": int64(1), "country": "US"},
+	}), []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		columnEvents []*Event
+		schemaEvents []*Event
+		rowEvents    []*Event
+	)
+
+	err = DiffEvents(t1, t2, func(e *Event) error {
+		e.Time = 0
+
+		switch e.Type {
+		case EventColumnAdded, EventColumnRemoved, EventColumnChanged, EventColumnRenamed:
+			if len(schemaEvents) > 0 || len(rowEvents) > 0 {
+				t.Errorf("column event %s after schema or row events", e.Type)
+			}
+			columnEvents = append(columnEvents, e)
+
+		case EventRowAdded, EventRowRemoved, EventRowChanged:
+			rowEvents = append(rowEvents, e)
+
+		default:
+			if len(rowEvents) > 0 {
+				t.Errorf("schema event %s after row events", e.Type)
+			}
+			schemaEvents = append(schemaEvents, e)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	no := false
+	yes := true
+
+	expectedColumns := []*Event{
+		{Type: EventColumnRenamed, Column: "alias", OldColumn: "nickname", OldType: "string", NewType: "string"},
+	}
+	if s1, s2, ok := jsonEqualEvents(expectedColumns, columnEvents); !ok {
+		t.Errorf("unexpected column events. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	expectedSchema := []*Event{
+		{Type: EventNullability, Column: "name", OldNullable: &no, NewNullable: &yes},
+		{Type: EventDefaultChanged, Column: "name", NewDefault: json.RawMessage("null")},
+		{Type: EventSymbolsChanged, Column: "color", SymbolsAdded: []string{"GREEN"}, SymbolsRemoved: []string{"BLUE"}},
+		{Type: EventFieldRenamed, Column: "address", Field: "postcode", OldField: "zip"},
+		{Type: EventFieldChanged, Column: "address", Field: "number", OldType: "int", NewType: "long"},
+		{Type: EventFieldAdded, Column: "address", Field: "country", NewType: "string"},
+	}
+	if s1, s2, ok := jsonEqualEvents(expectedSchema, schemaEvents); !ok {
+		t.Errorf("unexpected schema events. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	// The renamed column is compared to the old one, so only the address
+	// with the new field changed.
+	if len(rowEvents) != 1 || len(rowEvents[0].Changes) != 1 || rowEvents[0].Changes["address"] == nil {
+		s, _ := json.Marshal(rowEvents)
+		t.Errorf("expected only the address to change, got:\n%s", s)
+	}
+}
+
+func TestAvroTableCSV(t *testing.T) {
+	schema := `{"type": "record", "name": "Row", "fields": [
+	  {"name": "id", "type": "long"},
+	  {"name": "name", "type": ["null", "string"]},
+	  {"name": "born", "type": {"type": "int", "logicalType": "date"}},
+	  {"name": "score", "type": "double"}
+	]}`
+
+	t1, err := AvroTable(writeAvro(t, schema,
+		map[string]interface{}{"id": int64(2), "name": goavro.Union("string", "Ann"), "born": int32(366), "score": 1.5},
+		map[string]interface{}{"id": int64(10), "name": nil, "born": int32(0), "score": 0.1},
+	), []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := CSVTable(NewCSVReader(bytes.NewBufferString("id,name,born,score\n2,Ann,1971-01-02,1.5\n10,Bob,1970-01-01,0.1\n"), ','), []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := Diff(t1, t2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.RowsChanged != 1 || diff.RowsAdded != 0 || diff.RowsDeleted != 0 {
+		t.Fatalf("expected one changed row, got %d changed, %d added and %d deleted", diff.RowsChanged, diff.RowsAdded, diff.RowsDeleted)
+	}
+
+	expected := map[string]*ValueChange{
+		"name": {Old: nil, New: "Bob"},
+	}
+
+	if s1, s2, ok := jsonEqual(expected, diff.RowDiffs[0].Changes); !ok {
+		t.Errorf("unexpected changes. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestAvroDiffSchemaSharedRecord(t *testing.T) {
+	schema1 := `{"type": "record", "name": "Person", "fields": [
+	  {"name": "id", "type": "long"},
+	  {"name": "home", "type": {"type": "record", "name": "Address", "fields": [
+	    {"name": "city", "type": "string"}
+	  ]}},
+	  {"name": "work", "type": "Address"},
+	  {"name": "next", "type": {"type": "record", "name": "Node", "fields": [
+	    {"name": "next", "type": ["null", "Node"]}
+	  ]}}
+	]}`
+
+	schema2 := `{"type": "record", "name": "Person", "fields": [
+	  {"name": "id", "type": "long"},
+	  {"name": "home", "type": {"type": "record", "name": "Address", "fields": [
+	    {"name": "city", "type": "string"},
+	    {"name": "zip", "type": ["null", "string"], "default": null}
+	  ]}},
+	  {"name": "work", "type": "Address"},
+	  {"name": "next", "type": {"type": "record", "name": "Node", "fields": [
+	    {"name": "next", "type": ["null", "Node"]}
+	  ]}}
+	]}`
+
+	t1, err := AvroTable(writeAvro(t, schema1), []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := AvroTable(writeAvro(t, schema2), []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := t2.(SchemaDiffer).DiffSchema(t1.(SchemaDiffer).Schema())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*Event{
+		{Type: EventFieldAdded, Column: "home", Field: "zip", NewType: "string"},
+		{Type: EventFieldAdded, Column: "work", Field: "zip", NewType: "string"},
+	}
+
+	if s1, s2, ok := jsonEqualEvents(expected, sd.Events); !ok {
+		t.Errorf("unexpected events. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
@@ -0,0 +1,295 @@
+package difftable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Type tags of the canonical encoding. Values of different types never
+// compare equal and are ordered by their tag. The end of an array or map
+// is marked by tagEnd, which sorts before any tag so shorter arrays
+// sort first.
+const (
+	tagEnd byte = iota
+	tagNull
+	tagFalse
+	tagTrue
+	tagNumber
+	tagFloat
+	tagString
+	tagBytes
+	tagTime
+	tagDuration
+	tagArray
+	tagMap
+	tagOther
+)
+
+// Markers of the sign of numbers. Numbers are encoded by sign first so
+// negative numbers sort before zero and positive numbers.
+const (
+	signNegative byte = 0x01
+	signZero     byte = 0x02
+	signPositive byte = 0x03
+)
+
+// CanonicalBytes returns the canonical encoding of a value for CanonicalRow.
+// Nil is returned as nil like other null values. See AppendCanonical.
+func CanonicalBytes(v interface{}) []byte {
+	if v == nil {
+		return nil
+	}
+	return AppendCanonical(nil, v)
+}
+
+// AppendCanonical appends the canonical encoding of the value to buf. Each
+// value is prefixed with a tag of its type so, for example, null and the
+// string "<nil>" or the number 1 and the string "1" are different.
+//
+// The encoding is exact and deterministic: integers, decimals and
+// json.Number values are encoded as the same decimal number regardless of
+// their Go type, so 1, int32(1) and 1.0 as a json.Number are equal, while
+// floats are encoded by their bits. Map entries are sorted by key.
+//
+// Values of the same type compare with bytes.Compare in their natural
+// order, so CompareBytes orders keys encoded this way. Numbers compare by
+// value, strings and bytes byte-wise, times chronologically, and arrays
+// and maps element by element.
+func AppendCanonical(buf []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(buf, tagNull)
+
+	case bool:
+		if x {
+			return append(buf, tagTrue)
+		}
+		return append(buf, tagFalse)
+
+	case int:
+		return appendCanonicalInt(buf, int64(x))
+	case int8:
+		return appendCanonicalInt(buf, int64(x))
+	case int16:
+		return appendCanonicalInt(buf, int64(x))
+	case int32:
+		return appendCanonicalInt(buf, int64(x))
+	case int64:
+		return appendCanonicalInt(buf, x)
+	case uint:
+		return appendCanonicalDecimal(buf, new(big.Int).SetUint64(uint64(x)), 0)
+	case uint8:
+		return appendCanonicalInt(buf, int64(x))
+	case uint16:
+		return appendCanonicalInt(buf, int64(x))
+	case uint32:
+		return appendCanonicalInt(buf, int64(x))
+	case uint64:
+		return appendCanonicalDecimal(buf, new(big.Int).SetUint64(x), 0)
+	case *big.Int:
+		return appendCanonicalDecimal(buf, x, 0)
+	case *big.Rat:
+		return appendCanonicalRat(buf, x)
+
+	case json.Number:
+		if r, ok := new(big.Rat).SetString(canonicalNumber(string(x))); ok {
+			return appendCanonicalRat(buf, r)
+		}
+		buf = append(buf, tagOther)
+		return appendCanonicalString(buf, string(x))
+
+	case float32:
+		return appendCanonicalFloat(buf, float64(x))
+	case float64:
+		return appendCanonicalFloat(buf, x)
+
+	case string:
+		buf = append(buf, tagString)
+		return appendCanonicalString(buf, x)
+
+	case []byte:
+		buf = append(buf, tagBytes)
+		return appendCanonicalString(buf, string(x))
+
+	case time.Time:
+		buf = append(buf, tagTime)
+		buf = appendCanonicalUint(buf, uint64(x.Unix())^(1<<63))
+		return binary.BigEndian.AppendUint32(buf, uint32(x.Nanosecond()))
+
+	case time.Duration:
+		buf = append(buf, tagDuration)
+		return appendCanonicalUint(buf, uint64(x)^(1<<63))
+
+	case []interface{}:
+		buf = append(buf, tagArray)
+		for _, e := range x {
+			buf = AppendCanonical(buf, e)
+		}
+		return append(buf, tagEnd)
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf = append(buf, tagMap)
+		for _, k := range keys {
+			buf = append(buf, tagString)
+			buf = appendCanonicalString(buf, k)
+			buf = AppendCanonical(buf, x[k])
+		}
+		return append(buf, tagEnd)
+	}
+
+	// Other slices and maps are encoded by their elements.
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		a := make([]interface{}, rv.Len())
+		for i := range a {
+			a[i] = rv.Index(i).Interface()
+		}
+		return AppendCanonical(buf, a)
+
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			m := make(map[string]interface{}, rv.Len())
+			for _, k := range rv.MapKeys() {
+				m[k.String()] = rv.MapIndex(k).Interface()
+			}
+			return AppendCanonical(buf, m)
+		}
+
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return append(buf, tagNull)
+		}
+		return AppendCanonical(buf, rv.Elem().Interface())
+	}
+
+	buf = append(buf, tagOther)
+	return appendCanonicalString(buf, fmt.Sprintf("%T:%v", v, v))
+}
+
+// appendCanonicalString appends the bytes of s with zero bytes escaped as
+// 0x00 0xff and terminated by 0x00 0x01 so the end of a string sorts
+// before any of its extensions.
+func appendCanonicalString(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		buf = append(buf, s[i])
+		if s[i] == 0x00 {
+			buf = append(buf, 0xff)
+		}
+	}
+	return append(buf, 0x00, 0x01)
+}
+
+// appendCanonicalUint appends the big-endian bytes of the integer.
+func appendCanonicalUint(buf []byte, n uint64) []byte {
+	return binary.BigEndian.AppendUint64(buf, n)
+}
+
+// appendCanonicalFloat appends the bits of the float transformed so they
+// order like the values. Negative zero is encoded as zero and all NaNs as
+// one value that sorts after positive infinity.
+func appendCanonicalFloat(buf []byte, f float64) []byte {
+	switch {
+	case f == 0:
+		f = 0
+	case math.IsNaN(f):
+		f = math.NaN()
+	}
+
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+
+	buf = append(buf, tagFloat)
+	return appendCanonicalUint(buf, bits)
+}
+
+func appendCanonicalInt(buf []byte, n int64) []byte {
+	return appendCanonicalDecimal(buf, big.NewInt(n), 0)
+}
+
+// appendCanonicalRat appends a rational number, which must have a finite
+// decimal representation to be exact. Others are rounded to 100 digits.
+func appendCanonicalRat(buf []byte, r *big.Rat) []byte {
+	if r.IsInt() {
+		return appendCanonicalDecimal(buf, r.Num(), 0)
+	}
+
+	s := r.FloatString(100)
+	i := strings.IndexByte(s, '.')
+	digits := strings.TrimRight(s[:i]+s[i+1:], "0")
+	scale := len(s) - i - 1 - (len(s[:i]+s[i+1:]) - len(digits))
+
+	n, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		// Rounded to zero.
+		n = new(big.Int)
+	}
+
+	return appendCanonicalDecimal(buf, n, scale)
+}
+
+// appendCanonicalDecimal appends the number n * 10^-scale. The number is
+// normalized to 0.d1d2...dn * 10^e without trailing zeros, so equal numbers
+// have the same encoding, and encoded as the sign, the exponent and the
+// digits terminated by a zero byte. The exponent and digits of negative
+// numbers are complemented so larger magnitudes sort first.
+func appendCanonicalDecimal(buf []byte, n *big.Int, scale int) []byte {
+	buf = append(buf, tagNumber)
+
+	sign := n.Sign()
+	if sign == 0 {
+		return append(buf, signZero)
+	}
+
+	digits := new(big.Int).Abs(n).String()
+	trimmed := strings.TrimRight(digits, "0")
+	exp := int64(len(digits) - scale)
+
+	var mask byte
+	if sign < 0 {
+		mask = 0xff
+		buf = append(buf, signNegative)
+	} else {
+		buf = append(buf, signPositive)
+	}
+
+	e := uint64(exp) ^ (1 << 63)
+	if sign < 0 {
+		e = ^e
+	}
+	buf = appendCanonicalUint(buf, e)
+
+	for i := 0; i < len(trimmed); i++ {
+		buf = append(buf, trimmed[i]^mask)
+	}
+
+	return append(buf, 0x00^mask)
+}
+
+// CompareCanonical compares values encoded by CanonicalBytes. Like the
+// other comparators, nil values sort last.
+func CompareCanonical(a, b []byte) int {
+	if p, ok := compareNulls(a, b); ok {
+		return p
+	}
+	return bytes.Compare(a, b)
+}
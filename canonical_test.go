@@ -0,0 +1,149 @@
+package difftable
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+func TestCanonicalBytesEqual(t *testing.T) {
+	tests := []struct {
+		a, b  interface{}
+		equal bool
+	}{
+		{int32(1), int64(1), true},
+		{int64(1), json.Number("1.0"), true},
+		{json.Number("1.50"), big.NewRat(3, 2), true},
+		{json.Number("15e-1"), json.Number("1.5"), true},
+		{float32(1.5), 1.5, true},
+		{math.Copysign(0, -1), 0.0, true},
+		{math.NaN(), math.NaN(), true},
+		{map[string]interface{}{"a": 1, "b": "x"}, map[string]interface{}{"b": "x", "a": 1}, true},
+		{[]string{"a"}, []interface{}{"a"}, true},
+
+		{nil, "<nil>", false},
+		{int64(1), "1", false},
+		{"a", []byte("a"), false},
+		{0.1, math.Nextafter(0.1, 1), false},
+		{[]interface{}{"a", "b"}, []interface{}{"ab"}, false},
+		{[]interface{}{nil}, []interface{}{}, false},
+		{int64(1), 1.0, false},
+	}
+
+	for _, test := range tests {
+		a := AppendCanonical(nil, test.a)
+		b := AppendCanonical(nil, test.b)
+
+		if bytes.Equal(a, b) != test.equal {
+			t.Errorf("expected %#v and %#v equal to be %v", test.a, test.b, test.equal)
+		}
+	}
+
+	if CanonicalBytes(nil) != nil {
+		t.Error("expected nil bytes for nil")
+	}
+}
+
+func TestCanonicalBytesOrder(t *testing.T) {
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Each list of values of one type is in ascending order.
+	tests := [][]interface{}{
+		{int64(-100), int64(-11), int64(-10), int64(-1), int64(0), int64(1), int64(9), int64(10), int64(100)},
+		{json.Number("-1.5"), json.Number("-1.25"), json.Number("-0.001"), json.Number("0.01"), json.Number("0.1"), json.Number("0.11"), json.Number("2")},
+		{math.Inf(-1), -2.5, -1.0, 0.0, 1e-300, 1.0, 2.5, math.Inf(1), math.NaN()},
+		{"", "a", "a\x00", "ab", "b"},
+		{day.Add(-time.Hour), day, day.Add(time.Nanosecond), day.Add(time.Hour)},
+		{-time.Second, time.Duration(0), time.Second},
+		{[]interface{}{}, []interface{}{"a"}, []interface{}{"a", "a"}, []interface{}{"b"}},
+	}
+
+	for _, vals := range tests {
+		for i := 1; i < len(vals); i++ {
+			a := CanonicalBytes(vals[i-1])
+			b := CanonicalBytes(vals[i])
+
+			if CompareCanonical(a, b) >= 0 {
+				t.Errorf("expected %#v to sort before %#v", vals[i-1], vals[i])
+			}
+		}
+	}
+
+	if CompareCanonical(nil, CanonicalBytes("a")) <= 0 {
+		t.Error("expected nil to sort last")
+	}
+}
+
+func TestCanonicalDiffSortedAndHashed(t *testing.T) {
+	schema := `{"type": "record", "name": "Row", "fields": [
+	  {"name": "id", "type": "long"},
+	  {"name": "name", "type": ["null", "string"]}
+	]}`
+
+	avro := func(names ...interface{}) Table {
+		var recs []map[string]interface{}
+		for i, n := range names {
+			if n != nil {
+				n = goavro.Union("string", n)
+			}
+			recs = append(recs, map[string]interface{}{"id": int64(len(names) - i), "name": n})
+		}
+
+		tb, err := AvroTable(writeAvro(t, schema, recs...), []string{"id"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tb
+	}
+
+	sorted := func(tb Table, limit int64) Table {
+		st, err := Sort(tb, SortOptions{MemoryLimit: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	// Rows are in descending key order so the merge compares the sorted
+	// tables, which yield the null name of the last row.
+	modes := map[string]func(h func(*Event) error) error{
+		"sorted": func(h func(*Event) error) error {
+			return DiffEvents(sorted(avro("a", nil), 0), sorted(avro("a", ""), 0), h)
+		},
+		"spilled": func(h func(*Event) error) error {
+			return DiffEvents(sorted(avro("a", nil), 1), sorted(avro("a", ""), 1), h)
+		},
+		"hashed": func(h func(*Event) error) error {
+			return HashDiffEvents(avro("a", nil), avro("a", ""), nil, h)
+		},
+	}
+
+	for name, diff := range modes {
+		var changes []map[string]*ValueChange
+		err := diff(func(e *Event) error {
+			if e.Type != EventRowChanged {
+				t.Errorf("%s: unexpected %s event", name, e.Type)
+			}
+			if e.Type == EventRowChanged {
+				changes = append(changes, e.Changes)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []map[string]*ValueChange{
+			{"name": {Old: nil, New: ""}},
+		}
+
+		if s1, s2, ok := jsonEqual(expected, changes); !ok {
+			t.Errorf("%s: unexpected changes. expected:\n%s\ngot:\n%s", name, s1, s2)
+		}
+	}
+}
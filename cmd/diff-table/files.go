@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/parquet/file"
+	difftable "github.com/chop-dbhi/diff-table"
+	"github.com/linkedin/goavro"
+	"github.com/xuri/excelize/v2"
+)
+
+// opener opens the file at the path as a table with the key.
+type opener func(path string, key []string) (difftable.Table, error)
+
+// openTable opens the table at the path, which may be a glob or directory
+// of part files, see difftable.PartFiles. Parts are merged in key order or,
+// if the table requires sorting, concatenated and sorted.
+func openTable(path string, key []string, unsorted bool, sortOpts difftable.SortOptions, open opener) (difftable.Table, error) {
+	paths, err := difftable.PartFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]difftable.Table, len(paths))
+
+	for i, p := range paths {
+		// Tables rename the key in place.
+		parts[i], err = open(p, append([]string(nil), key...))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", p, err)
+		}
+	}
+
+	var t difftable.Table
+
+	switch {
+	case len(parts) == 1:
+		t = parts[0]
+	case unsorted:
+		t, err = difftable.ConcatTables(parts)
+	default:
+		t, err = difftable.MergeTables(parts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if unsorted {
+		return difftable.Sort(t, sortOpts)
+	}
+
+	return t, nil
+}
+
+// fileSet tracks the files opened for tables so they can be closed.
+type fileSet struct {
+	closers []io.Closer
+}
+
+// Close closes all of the files.
+func (fs *fileSet) Close() {
+	for _, c := range fs.closers {
+		c.Close()
+	}
+	fs.closers = nil
+}
+
+// open opens a file, decompressing it if necessary.
+func (fs *fileSet) open(path string) (io.ReadCloser, error) {
+	f, err := difftable.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.closers = append(fs.closers, f)
+
+	return f, nil
+}
+
+// csv opens CSV files.
+func (fs *fileSet) csv(dialect difftable.CSVDialect, renames map[string]string) opener {
+	return func(path string, key []string) (difftable.Table, error) {
+		f, err := fs.open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return difftable.CSVTableWithDialect(f, key, renames, dialect)
+	}
+}
+
+// fixedWidth opens fixed-width files with the layout.
+func (fs *fileSet) fixedWidth(layout *difftable.FixedWidthLayout, renames map[string]string) opener {
+	return func(path string, key []string) (difftable.Table, error) {
+		f, err := fs.open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return difftable.FixedWidthTable(f, layout, key, renames)
+	}
+}
+
+// avro opens Avro files.
+func (fs *fileSet) avro(renames map[string]string) opener {
+	return func(path string, key []string) (difftable.Table, error) {
+		f, err := fs.open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		rdr, err := goavro.NewOCFReader(f)
+		if err != nil {
+			return nil, err
+		}
+
+		return difftable.AvroTable(rdr, key, renames)
+	}
+}
+
+// jsonl opens JSON Lines files.
+func (fs *fileSet) jsonl(renames map[string]string, opts difftable.JSONLOptions) opener {
+	return func(path string, key []string) (difftable.Table, error) {
+		f, err := fs.open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return difftable.JSONLTableWithOptions(f, key, renames, opts)
+	}
+}
+
+// fhir opens FHIR Bulk Data NDJSON files.
+func (fs *fileSet) fhir(renames map[string]string, opts difftable.FHIROptions) opener {
+	return func(path string, key []string) (difftable.Table, error) {
+		f, err := fs.open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return difftable.FHIRTableWithOptions(f, key, renames, opts)
+	}
+}
+
+// xml opens XML files with the mapping.
+func (fs *fileSet) xml(mapping *difftable.XMLMapping, renames map[string]string) opener {
+	return func(path string, key []string) (difftable.Table, error) {
+		f, err := fs.open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return difftable.XMLTable(f, mapping, key, renames)
+	}
+}
+
+// seekable opens a file for random access. Compressed files and streams
+// are copied to a temporary file in the directory.
+func (fs *fileSet) seekable(path string, tmpDir string) (*os.File, error) {
+	rc, err := fs.open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if f, ok := rc.(*os.File); ok {
+		return f, nil
+	}
+
+	tmp, err := ioutil.TempFile(tmpDir, "diff-table-")
+	if err != nil {
+		return nil, err
+	}
+
+	fs.closers = append(fs.closers, &tempFile{tmp})
+
+	if _, err := io.Copy(tmp, rc); err != nil {
+		return nil, err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return tmp, nil
+}
+
+// parquet opens Parquet files. Compressed files and streams are copied to
+// a temporary file in the directory since the format requires random
+// access.
+func (fs *fileSet) parquet(renames map[string]string, tmpDir string) opener {
+	return func(path string, key []string) (difftable.Table, error) {
+		f, err := fs.seekable(path, tmpDir)
+		if err != nil {
+			return nil, err
+		}
+
+		rdr, err := file.NewParquetReader(f)
+		if err != nil {
+			return nil, err
+		}
+
+		return difftable.ParquetTable(rdr, key, renames)
+	}
+}
+
+// tempFile is a temporary file that is removed when closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// arrow opens Arrow IPC files and streams.
+func (fs *fileSet) arrow(renames map[string]string) opener {
+	return func(path string, key []string) (difftable.Table, error) {
+		f, err := fs.open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return difftable.ArrowTable(f, key, renames)
+	}
+}
+
+// sas opens SAS7BDAT datasets.
+func (fs *fileSet) sas(renames map[string]string) opener {
+	return func(path string, key []string) (difftable.Table, error) {
+		f, err := fs.open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return difftable.SASTable(f, key, renames)
+	}
+}
+
+// stata opens Stata datasets. Compressed files and streams are copied to a
+// temporary file in the directory since long strings and value labels are
+// stored after the observations.
+func (fs *fileSet) stata(renames map[string]string, opts difftable.StataOptions, tmpDir string) opener {
+	return func(path string, key []string) (difftable.Table, error) {
+		f, err := fs.seekable(path, tmpDir)
+		if err != nil {
+			return nil, err
+		}
+
+		return difftable.StataTableWithOptions(f, key, renames, opts)
+	}
+}
+
+// spss opens SPSS system and ZSAV files.
+func (fs *fileSet) spss(renames map[string]string, opts difftable.SPSSOptions) opener {
+	return func(path string, key []string) (difftable.Table, error) {
+		f, err := fs.open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return difftable.SPSSTableWithOptions(f, key, renames, opts)
+	}
+}
+
+// xlsx opens Excel workbooks. Compressed workbooks and streams are read
+// into memory.
+func (fs *fileSet) xlsx(renames map[string]string, opts difftable.XLSXOptions) opener {
+	return func(path string, key []string) (difftable.Table, error) {
+		rc, err := fs.open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var wb *excelize.File
+
+		if _, ok := rc.(*os.File); ok {
+			wb, err = excelize.OpenFile(path)
+		} else {
+			wb, err = excelize.OpenReader(rc)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fs.closers = append(fs.closers, wb)
+
+		return difftable.XLSXTableWithOptions(wb, key, renames, opts)
+	}
+}
+
+// eventLog replays the comma-separated snapshot and event logs, each of
+// which may be a glob or directory of part files.
+func (fs *fileSet) eventLog(paths string, key []string, renames map[string]string, opts difftable.EventLogOptions) (difftable.Table, error) {
+	var logs []io.Reader
+
+	for _, path := range strings.Split(paths, ",") {
+		parts, err := difftable.PartFiles(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range parts {
+			f, err := fs.open(p)
+			if err != nil {
+				return nil, err
+			}
+			logs = append(logs, f)
+		}
+	}
+
+	return difftable.EventLogTableWithOptions(logs, key, renames, opts)
+}
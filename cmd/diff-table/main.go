@@ -5,13 +5,18 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sort"
 	"strings"
+	"unicode/utf8"
 
 	difftable "github.com/chop-dbhi/diff-table"
-	"github.com/lib/pq"
-	"github.com/linkedin/goavro"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/microsoft/go-mssqldb"
+	_ "modernc.org/sqlite"
 )
 
 func main() {
@@ -20,69 +25,302 @@ func main() {
 		key2List string
 		diffRows bool
 
-		csv1      string
-		csv1delim string
-		csv1sort  bool
+		csv1        string
+		csv1delim   string
+		csv1quote   string
+		csv1escape  string
+		csv1comment string
+		csv1skip    int
+		csv1header  int
+		csv1strict  bool
+		csv1trim    string
+		csv1sort    bool
+
+		csv2        string
+		csv2delim   string
+		csv2quote   string
+		csv2escape  string
+		csv2comment string
+		csv2skip    int
+		csv2header  int
+		csv2strict  bool
+		csv2trim    string
+		csv2sort    bool
+
+		fixed1       string
+		fixed1layout string
+		fixed1sort   bool
+
+		fixed2       string
+		fixed2layout string
+		fixed2sort   bool
+
+		avro1     string
+		avro1sort bool
+
+		avro2     string
+		avro2sort bool
+
+		jsonl1       string
+		jsonl1sort   bool
+		jsonl1schema string
+
+		jsonl2       string
+		jsonl2sort   bool
+		jsonl2schema string
+
+		jsonlSample int
+
+		fhir1      string
+		fhir1sort  bool
+		fhir1type  string
+		fhir1paths string
+
+		fhir2      string
+		fhir2sort  bool
+		fhir2type  string
+		fhir2paths string
+
+		xml1        string
+		xml1mapping string
+		xml1sort    bool
+
+		xml2        string
+		xml2mapping string
+		xml2sort    bool
+
+		parquet1     string
+		parquet1sort bool
+
+		parquet2     string
+		parquet2sort bool
+
+		arrow1     string
+		arrow1sort bool
+
+		arrow2     string
+		arrow2sort bool
+
+		sas1     string
+		sas1sort bool
+
+		sas2     string
+		sas2sort bool
+
+		dta1       string
+		dta1sort   bool
+		dta1labels bool
+
+		dta2       string
+		dta2sort   bool
+		dta2labels bool
+
+		sav1       string
+		sav1sort   bool
+		sav1labels bool
+
+		sav2       string
+		sav2sort   bool
+		sav2labels bool
+
+		xlsx1       string
+		xlsx1sheet  string
+		xlsx1header int
+		xlsx1sort   bool
+
+		xlsx2       string
+		xlsx2sheet  string
+		xlsx2header int
+		xlsx2sort   bool
 
-		csv2      string
-		csv2delim string
-		csv2sort  bool
+		log1       string
+		log1schema string
 
-		avro1 string
-		avro2 string
+		log2       string
+		log2schema string
 
-		url1    string
-		schema1 string
-		table1  string
-		sql1    string
+		url1     string
+		schema1  string
+		table1   string
+		sql1     string
+		sql1sort bool
 
-		url2    string
-		schema2 string
-		table2  string
-		sql2    string
+		url2     string
+		schema2  string
+		table2   string
+		sql2     string
+		sql2sort bool
 
 		events   bool
 		fulldata bool
 		snapshot bool
 
+		arrowOut    string
+		arrowStream bool
+
 		rename1 string
 		rename2 string
+
+		order    string
+		hashJoin bool
+
+		sortMem    int64
+		sortTmpDir string
 	)
 
 	flag.StringVar(&key1List, "key", "", "Comma-separate list of columns in table 1.")
 	flag.StringVar(&key2List, "key2", "", "Comma-separate list of columns in table 2. Default to key option.")
 	flag.BoolVar(&diffRows, "diff", false, "Diff row values and output changes.")
 
-	flag.StringVar(&csv1, "csv1", "", "Path to CSV file.")
-	flag.StringVar(&csv1delim, "csv1.delim", ",", "CSV delimiter.")
+	flag.StringVar(&csv1, "csv1", "", "Path to CSV file or - for stdin.")
+	flag.StringVar(&csv1delim, "csv1.delim", ",", "CSV delimiter. Use \\t for tabs.")
+	flag.StringVar(&csv1quote, "csv1.quote", "\"", "CSV quote character.")
+	flag.StringVar(&csv1escape, "csv1.escape", "", "CSV escape character, such as \\. Defaults to doubling quotes.")
+	flag.StringVar(&csv1comment, "csv1.comment", "", "Prefix of CSV comment lines, such as #.")
+	flag.IntVar(&csv1skip, "csv1.skip", 0, "Number of CSV lines to skip before the header.")
+	flag.IntVar(&csv1header, "csv1.header", 1, "Number of the CSV record with the column names after the skipped lines.")
+	flag.BoolVar(&csv1strict, "csv1.strict", false, "Reject bare and unterminated CSV quotes.")
+	flag.StringVar(&csv1trim, "csv1.trim", "left", "Side CSV whitespace is trimmed from: both, left, right or none.")
 	flag.BoolVar(&csv1sort, "csv1.sort", false, "CSV requires sorting.")
 
-	flag.StringVar(&csv2, "csv2", "", "Path to CSV file.")
-	flag.StringVar(&csv2delim, "csv2.delim", ",", "CSV delimiter.")
+	flag.StringVar(&csv2, "csv2", "", "Path to CSV file or - for stdin.")
+	flag.StringVar(&csv2delim, "csv2.delim", ",", "CSV delimiter. Use \\t for tabs.")
+	flag.StringVar(&csv2quote, "csv2.quote", "\"", "CSV quote character.")
+	flag.StringVar(&csv2escape, "csv2.escape", "", "CSV escape character, such as \\. Defaults to doubling quotes.")
+	flag.StringVar(&csv2comment, "csv2.comment", "", "Prefix of CSV comment lines, such as #.")
+	flag.IntVar(&csv2skip, "csv2.skip", 0, "Number of CSV lines to skip before the header.")
+	flag.IntVar(&csv2header, "csv2.header", 1, "Number of the CSV record with the column names after the skipped lines.")
+	flag.BoolVar(&csv2strict, "csv2.strict", false, "Reject bare and unterminated CSV quotes.")
+	flag.StringVar(&csv2trim, "csv2.trim", "left", "Side CSV whitespace is trimmed from: both, left, right or none.")
 	flag.BoolVar(&csv2sort, "csv2.sort", false, "CSV requires sorting.")
 
-	flag.StringVar(&avro1, "avro1", "", "Path to Avro file.")
-	flag.StringVar(&avro2, "avro2", "", "Path to Avro file.")
+	flag.StringVar(&fixed1, "fixed1", "", "Path to fixed-width file or - for stdin.")
+	flag.StringVar(&fixed1layout, "fixed1.layout", "", "Path to the JSON layout of the fixed-width columns.")
+	flag.BoolVar(&fixed1sort, "fixed1.sort", false, "Fixed-width file requires sorting.")
+
+	flag.StringVar(&fixed2, "fixed2", "", "Path to fixed-width file or - for stdin.")
+	flag.StringVar(&fixed2layout, "fixed2.layout", "", "Path to the JSON layout of the fixed-width columns. Defaults to fixed1.layout option.")
+	flag.BoolVar(&fixed2sort, "fixed2.sort", false, "Fixed-width file requires sorting.")
+
+	flag.StringVar(&avro1, "avro1", "", "Path to Avro file or - for stdin.")
+	flag.BoolVar(&avro1sort, "avro1.sort", false, "Avro requires sorting.")
+
+	flag.StringVar(&avro2, "avro2", "", "Path to Avro file or - for stdin.")
+	flag.BoolVar(&avro2sort, "avro2.sort", false, "Avro requires sorting.")
+
+	flag.StringVar(&jsonl1, "jsonl1", "", "Path to JSON Lines file or - for stdin.")
+	flag.BoolVar(&jsonl1sort, "jsonl1.sort", false, "JSON Lines requires sorting.")
+	flag.StringVar(&jsonl1schema, "jsonl1.schema", "", "Path to a JSON object mapping column names to types. Defaults to discovering the columns.")
+
+	flag.StringVar(&jsonl2, "jsonl2", "", "Path to JSON Lines file or - for stdin.")
+	flag.BoolVar(&jsonl2sort, "jsonl2.sort", false, "JSON Lines requires sorting.")
+	flag.StringVar(&jsonl2schema, "jsonl2.schema", "", "Path to a JSON object mapping column names to types. Defaults to discovering the columns.")
+
+	flag.IntVar(&jsonlSample, "jsonl.sample", difftable.DefaultJSONLSampleSize, "Number of JSON Lines records or FHIR resources read to discover the columns.")
+
+	flag.StringVar(&fhir1, "fhir1", "", "Path to FHIR Bulk Data NDJSON file or - for stdin.")
+	flag.BoolVar(&fhir1sort, "fhir1.sort", false, "FHIR resources require sorting.")
+	flag.StringVar(&fhir1type, "fhir1.type", "", "Resource type to read. Defaults to the type of the first resource.")
+	flag.StringVar(&fhir1paths, "fhir1.paths", "", "Path to a JSON object mapping column names to FHIRPath-like paths. Defaults to the top-level elements.")
+
+	flag.StringVar(&fhir2, "fhir2", "", "Path to FHIR Bulk Data NDJSON file or - for stdin.")
+	flag.BoolVar(&fhir2sort, "fhir2.sort", false, "FHIR resources require sorting.")
+	flag.StringVar(&fhir2type, "fhir2.type", "", "Resource type to read. Defaults to fhir1.type.")
+	flag.StringVar(&fhir2paths, "fhir2.paths", "", "Path to a JSON object mapping column names to FHIRPath-like paths. Defaults to fhir1.paths.")
+
+	flag.StringVar(&xml1, "xml1", "", "Path to XML file or - for stdin.")
+	flag.StringVar(&xml1mapping, "xml1.mapping", "", "Path to the JSON mapping of the record element and XPath columns.")
+	flag.BoolVar(&xml1sort, "xml1.sort", false, "XML records require sorting.")
+
+	flag.StringVar(&xml2, "xml2", "", "Path to XML file or - for stdin.")
+	flag.StringVar(&xml2mapping, "xml2.mapping", "", "Path to the JSON mapping of the record element and XPath columns. Defaults to xml1.mapping option.")
+	flag.BoolVar(&xml2sort, "xml2.sort", false, "XML records require sorting.")
+
+	flag.StringVar(&parquet1, "parquet1", "", "Path to Parquet file or - for stdin.")
+	flag.BoolVar(&parquet1sort, "parquet1.sort", false, "Parquet requires sorting.")
+
+	flag.StringVar(&parquet2, "parquet2", "", "Path to Parquet file or - for stdin.")
+	flag.BoolVar(&parquet2sort, "parquet2.sort", false, "Parquet requires sorting.")
+
+	flag.StringVar(&arrow1, "arrow1", "", "Path to Arrow IPC file or stream or - for stdin.")
+	flag.BoolVar(&arrow1sort, "arrow1.sort", false, "Arrow requires sorting.")
+
+	flag.StringVar(&arrow2, "arrow2", "", "Path to Arrow IPC file or stream or - for stdin.")
+	flag.BoolVar(&arrow2sort, "arrow2.sort", false, "Arrow requires sorting.")
+
+	flag.StringVar(&sas1, "sas1", "", "Path to SAS7BDAT dataset or - for stdin.")
+	flag.BoolVar(&sas1sort, "sas1.sort", false, "SAS dataset requires sorting.")
+
+	flag.StringVar(&sas2, "sas2", "", "Path to SAS7BDAT dataset or - for stdin.")
+	flag.BoolVar(&sas2sort, "sas2.sort", false, "SAS dataset requires sorting.")
+
+	flag.StringVar(&dta1, "dta1", "", "Path to Stata dataset or - for stdin.")
+	flag.BoolVar(&dta1sort, "dta1.sort", false, "Stata dataset requires sorting.")
+	flag.BoolVar(&dta1labels, "dta1.labels", false, "Output value labels rather than values.")
+
+	flag.StringVar(&dta2, "dta2", "", "Path to Stata dataset or - for stdin.")
+	flag.BoolVar(&dta2sort, "dta2.sort", false, "Stata dataset requires sorting.")
+	flag.BoolVar(&dta2labels, "dta2.labels", false, "Output value labels rather than values.")
+
+	flag.StringVar(&sav1, "sav1", "", "Path to SPSS system or ZSAV file or - for stdin.")
+	flag.BoolVar(&sav1sort, "sav1.sort", false, "SPSS file requires sorting.")
+	flag.BoolVar(&sav1labels, "sav1.labels", false, "Output value labels rather than values.")
+
+	flag.StringVar(&sav2, "sav2", "", "Path to SPSS system or ZSAV file or - for stdin.")
+	flag.BoolVar(&sav2sort, "sav2.sort", false, "SPSS file requires sorting.")
+	flag.BoolVar(&sav2labels, "sav2.labels", false, "Output value labels rather than values.")
+
+	flag.StringVar(&xlsx1, "xlsx1", "", "Path to Excel workbook or - for stdin.")
+	flag.StringVar(&xlsx1sheet, "xlsx1.sheet", "", "Name of the worksheet. Defaults to the first sheet.")
+	flag.IntVar(&xlsx1header, "xlsx1.header", 1, "Row number of the column names.")
+	flag.BoolVar(&xlsx1sort, "xlsx1.sort", false, "Worksheet requires sorting.")
+
+	flag.StringVar(&xlsx2, "xlsx2", "", "Path to Excel workbook or - for stdin.")
+	flag.StringVar(&xlsx2sheet, "xlsx2.sheet", "", "Name of the worksheet. Defaults to the first sheet.")
+	flag.IntVar(&xlsx2header, "xlsx2.header", 1, "Row number of the column names.")
+	flag.BoolVar(&xlsx2sort, "xlsx2.sort", false, "Worksheet requires sorting.")
+
+	flag.StringVar(&log1, "log1", "", "Comma-separated paths to a snapshot and event logs replayed in order.")
+	flag.StringVar(&log1schema, "log1.schema", "", "Path to a JSON object mapping column names to types. Defaults to the types in the logs.")
+
+	flag.StringVar(&log2, "log2", "", "Comma-separated paths to a snapshot and event logs replayed in order.")
+	flag.StringVar(&log2schema, "log2.schema", "", "Path to a JSON object mapping column names to types. Defaults to the types in the logs.")
 
 	flag.StringVar(&url1, "db", "", "Database 1 connection URL.")
 	flag.StringVar(&schema1, "schema", "", "Name of the first schema.")
 	flag.StringVar(&table1, "table1", "", "Name of the first table.")
 	flag.StringVar(&sql1, "sql1", "", "SQL statement of the first table.")
+	flag.BoolVar(&sql1sort, "sql1.sort", false, "SQL statement of the first table requires sorting.")
 
 	flag.StringVar(&url2, "db2", "", "Database 2 connection URL. Defaults to db option.")
 	flag.StringVar(&schema2, "schema2", "", "Name of the second schema. Default to schema option.")
 	flag.StringVar(&table2, "table2", "", "Name of the second table.")
 	flag.StringVar(&sql2, "sql2", "", "SQL statement of the second table.")
+	flag.BoolVar(&sql2sort, "sql2.sort", false, "SQL statement of the second table requires sorting.")
 
 	flag.BoolVar(&events, "events", false, "Write an event stream to stdout.")
 	flag.BoolVar(&fulldata, "data", false, "Include the row data in row-changed and row-deleted events.")
 	flag.BoolVar(&snapshot, "snapshot", false, "Create a snapshot of the table as events to stdout.")
 
+	flag.StringVar(&arrowOut, "arrow.out", "", "Path to write the snapshot or row events as Arrow IPC or - for stdout.")
+	flag.BoolVar(&arrowStream, "arrow.stream", false, "Write the Arrow IPC stream format rather than the file format.")
+
 	flag.StringVar(&rename1, "rename1", "", "Comma and colon delimited map of table 1 columns to rename before diffing ('new:old,foo:bar').")
 	flag.StringVar(&rename2, "rename2", "", "Comma and colon delimited map of table 2 columns to rename before diffing ('new:old,foo:bar').")
 
+	flag.BoolVar(&hashJoin, "hash", false, "Match rows using an in-memory hash index of table 1 rather than requiring sorted tables.")
+	flag.StringVar(&order, "order", "fail", "Policy for keys that are out of order or duplicated: fail, warn or ignore.")
+
+	flag.Int64Var(&sortMem, "sort.mem", difftable.DefaultSortMemory, "Approximate bytes of row data held in memory when sorting before spilling to disk.")
+	flag.StringVar(&sortTmpDir, "sort.tmpdir", "", "Directory for spilled sort runs. Defaults to the system temporary directory.")
+
 	flag.Parse()
 
+	// FHIR resources are identified by id.
+	if key1List == "" && (fhir1 != "" || fhir2 != "") {
+		key1List = "id"
+	}
+
 	if key1List == "" {
 		log.Fatal("key required")
 		return
@@ -97,26 +335,59 @@ func main() {
 		key2 = strings.Split(key2List, ",")
 	}
 
+	if arrowOut != "" && !snapshot && !events {
+		log.Fatal("arrow.out requires snapshot or events")
+	}
+
 	if !snapshot {
 		if len(key1) != len(key2) {
 			log.Fatal("keys must be the same length")
 		}
 	}
 
-	if url2 == "" {
-		url2 = url1
-	}
-
 	if schema2 == "" {
 		schema2 = schema1
 	}
 
+	if fixed2layout == "" {
+		fixed2layout = fixed1layout
+	}
+
+	if fhir2type == "" {
+		fhir2type = fhir1type
+	}
+
+	if fhir2paths == "" {
+		fhir2paths = fhir1paths
+	}
+
+	if xml2mapping == "" {
+		xml2mapping = xml1mapping
+	}
+
 	var (
 		t1, t2   difftable.Table
 		db1, db2 *sql.DB
 		err      error
+
+		dialect1, dialect2 difftable.Dialect
 	)
 
+	orderPolicy, err := difftable.ParseOrderPolicy(order)
+	if err != nil {
+		log.Fatalf("order: %s", err)
+	}
+
+	diffOpts := &difftable.DiffOptions{
+		OrderPolicy: orderPolicy,
+		HashJoin:    hashJoin,
+	}
+
+	sortOpts := difftable.SortOptions{
+		MemoryLimit: sortMem,
+		TempDir:     sortTmpDir,
+	}
+
 	renameMap1, err := makeRenameMap(rename1)
 	if err != nil {
 		log.Fatalf("rename1: %s", err)
@@ -127,69 +398,119 @@ func main() {
 		log.Fatalf("rename2: %s", err)
 	}
 
-	if csv1 != "" && url1 != "" {
-		log.Fatal("can't both a csv and db source defined")
-		return
+	paths := []string{csv1, csv2, fixed1, fixed2, avro1, avro2, jsonl1, jsonl2, fhir1, fhir2, xml1, xml2, parquet1, parquet2, arrow1, arrow2, sas1, sas2, dta1, dta2, sav1, sav2, xlsx1, xlsx2}
+	paths = append(paths, strings.Split(log1, ",")...)
+	paths = append(paths, strings.Split(log2, ",")...)
+
+	var stdin int
+	for _, p := range paths {
+		if p == "-" {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		log.Fatal("only one table can be read from stdin")
 	}
 
-	if csv2 != "" && url2 != "" {
-		log.Fatal("can't both a csv and db target defined")
-		return
+	sources1 := setOptions(map[string]string{
+		"csv1": csv1, "fixed1": fixed1, "avro1": avro1, "jsonl1": jsonl1,
+		"fhir1": fhir1, "xml1": xml1, "parquet1": parquet1, "arrow1": arrow1,
+		"sas1": sas1, "dta1": dta1, "sav1": sav1, "xlsx1": xlsx1,
+		"log1": log1, "db": url1,
+	})
+	if len(sources1) > 1 {
+		log.Fatalf("only one source can be defined for table 1, got -%s", strings.Join(sources1, " and -"))
 	}
 
+	sources2 := setOptions(map[string]string{
+		"csv2": csv2, "fixed2": fixed2, "avro2": avro2, "jsonl2": jsonl2,
+		"fhir2": fhir2, "xml2": xml2, "parquet2": parquet2, "arrow2": arrow2,
+		"sas2": sas2, "dta2": dta2, "sav2": sav2, "xlsx2": xlsx2,
+		"log2": log2, "db2": url2,
+	})
+	if len(sources2) > 1 {
+		log.Fatalf("only one source can be defined for table 2, got -%s", strings.Join(sources2, " and -"))
+	}
+
+	// The database of table 2 defaults to that of table 1 unless table 2
+	// is read from elsewhere.
+	if url2 == "" && len(sources2) == 0 {
+		url2 = url1
+	}
+
+	var fs fileSet
+	defer fs.Close()
+
 	if csv1 != "" {
-		f1, err := os.Open(csv1)
+		dialect, err := csvDialect(csv1delim, csv1quote, csv1escape, difftable.CSVDialect{
+			Comment: csv1comment,
+			Skip:    csv1skip,
+			Header:  csv1header,
+			Strict:  csv1strict,
+			Trim:    csv1trim,
+		})
 		if err != nil {
-			log.Printf("csv1 open: %s", err)
+			log.Printf("csv1: %s", err)
 			return
 		}
-		defer f1.Close()
-
-		cr1 := difftable.NewCSVReader(f1, rune(csv1delim[0]))
 
-		if csv1sort {
-			t1, err = difftable.UnsortedCSVTable(cr1, key1, renameMap1)
-			if err != nil {
-				log.Printf("csv1 table: %s", err)
-				return
-			}
-		} else {
-			t1, err = difftable.CSVTable(cr1, key1, renameMap1)
-			if err != nil {
-				log.Printf("csv1 table: %s", err)
-				return
-			}
+		t1, err = openTable(csv1, key1, csv1sort, sortOpts, fs.csv(dialect, renameMap1))
+		if err != nil {
+			log.Printf("csv1: %s", err)
+			return
 		}
 	}
 
 	if csv2 != "" {
-		f2, err := os.Open(csv2)
+		dialect, err := csvDialect(csv2delim, csv2quote, csv2escape, difftable.CSVDialect{
+			Comment: csv2comment,
+			Skip:    csv2skip,
+			Header:  csv2header,
+			Strict:  csv2strict,
+			Trim:    csv2trim,
+		})
+		if err != nil {
+			log.Printf("csv2: %s", err)
+			return
+		}
+
+		t2, err = openTable(csv2, key2, csv2sort, sortOpts, fs.csv(dialect, renameMap2))
 		if err != nil {
-			log.Printf("csv2 open: %s", err)
+			log.Printf("csv2: %s", err)
 			return
 		}
-		defer f2.Close()
+	}
 
-		cr2 := difftable.NewCSVReader(f2, rune(csv2delim[0]))
+	if fixed1 != "" {
+		layout, err := readLayout(fixed1layout)
+		if err != nil {
+			log.Printf("fixed1 layout: %s", err)
+			return
+		}
 
-		if csv2sort {
-			t2, err = difftable.UnsortedCSVTable(cr2, key2, renameMap2)
-			if err != nil {
-				log.Printf("csv2 table: %s", err)
-				return
-			}
-		} else {
-			t2, err = difftable.CSVTable(cr2, key2, renameMap2)
-			if err != nil {
-				log.Printf("csv2 table: %s", err)
-				return
-			}
+		t1, err = openTable(fixed1, key1, fixed1sort, sortOpts, fs.fixedWidth(layout, renameMap1))
+		if err != nil {
+			log.Printf("fixed1: %s", err)
+			return
+		}
+	}
+
+	if fixed2 != "" {
+		layout, err := readLayout(fixed2layout)
+		if err != nil {
+			log.Printf("fixed2 layout: %s", err)
+			return
+		}
+
+		t2, err = openTable(fixed2, key2, fixed2sort, sortOpts, fs.fixedWidth(layout, renameMap2))
+		if err != nil {
+			log.Printf("fixed2: %s", err)
+			return
 		}
 	}
 
 	if url1 != "" {
-		// TODO: remove hard-coded postgres dependency
-		db1, err = sql.Open("postgres", url1)
+		db1, dialect1, err = difftable.OpenDatabase(url1)
 		if err != nil {
 			log.Printf("db1 open: %s", err)
 			return
@@ -206,7 +527,7 @@ func main() {
 		if sql1 != "" {
 			rows1, err = db1.Query(sql1)
 		} else {
-			rows1, err = runQuery(db1, schema1, table1, key1)
+			rows1, err = difftable.QueryTable(db1, dialect1, schema1, table1, key1)
 		}
 		if err != nil {
 			db1.Close()
@@ -221,6 +542,14 @@ func main() {
 			log.Printf("db1 table: %s", err)
 			return
 		}
+
+		if sql1sort {
+			t1, err = difftable.Sort(t1, sortOpts)
+			if err != nil {
+				log.Printf("db1 sort: %s", err)
+				return
+			}
+		}
 	}
 
 	if url2 != "" {
@@ -229,7 +558,7 @@ func main() {
 			err   error
 		)
 
-		db2, err = sql.Open("postgres", url2)
+		db2, dialect2, err = difftable.OpenDatabase(url2)
 		if err != nil {
 			log.Printf("db2 open: %s", err)
 			return
@@ -239,7 +568,11 @@ func main() {
 		if sql2 != "" {
 			rows2, err = db2.Query(sql2)
 		} else {
-			rows2, err = runQuery(db2, schema2, table2, key2)
+			rows2, err = difftable.QueryTable(db2, dialect2, schema2, table2, key2)
+		}
+		if err != nil {
+			log.Printf("db2 query: %s", err)
+			return
 		}
 
 		defer rows2.Close()
@@ -249,66 +582,325 @@ func main() {
 			log.Printf("db2 table: %s", err)
 			return
 		}
+
+		if sql2sort {
+			t2, err = difftable.Sort(t2, sortOpts)
+			if err != nil {
+				log.Printf("db2 sort: %s", err)
+				return
+			}
+		}
 	}
 
 	if avro1 != "" {
-		f, err := os.Open(avro1)
+		t1, err = openTable(avro1, key1, avro1sort, sortOpts, fs.avro(renameMap1))
 		if err != nil {
-			log.Printf("avro1 table: %s", err)
+			log.Printf("avro1: %s", err)
 			return
 		}
-		defer f.Close()
+	}
 
-		rdr, err := goavro.NewOCFReader(f)
+	if avro2 != "" {
+		t2, err = openTable(avro2, key2, avro2sort, sortOpts, fs.avro(renameMap2))
+		if err != nil {
+			log.Printf("avro2: %s", err)
+			return
+		}
+	}
+
+	if jsonl1 != "" {
+		schema, err := readSchema(jsonl1schema)
 		if err != nil {
-			log.Printf("avro1 table: %s", err)
+			log.Printf("jsonl1 schema: %s", err)
 			return
 		}
 
-		t1, err = difftable.AvroTable(rdr, key1, renameMap1)
+		t1, err = openTable(jsonl1, key1, jsonl1sort, sortOpts, fs.jsonl(renameMap1, difftable.JSONLOptions{
+			SampleSize: jsonlSample,
+			Schema:     schema,
+		}))
 		if err != nil {
-			log.Printf("avro1 table: %s", err)
+			log.Printf("jsonl1: %s", err)
 			return
 		}
 	}
 
-	if avro2 != "" {
-		f, err := os.Open(avro2)
+	if jsonl2 != "" {
+		schema, err := readSchema(jsonl2schema)
+		if err != nil {
+			log.Printf("jsonl2 schema: %s", err)
+			return
+		}
+
+		t2, err = openTable(jsonl2, key2, jsonl2sort, sortOpts, fs.jsonl(renameMap2, difftable.JSONLOptions{
+			SampleSize: jsonlSample,
+			Schema:     schema,
+		}))
+		if err != nil {
+			log.Printf("jsonl2: %s", err)
+			return
+		}
+	}
+
+	if fhir1 != "" {
+		paths, err := readSchema(fhir1paths)
+		if err != nil {
+			log.Printf("fhir1 paths: %s", err)
+			return
+		}
+
+		t1, err = openTable(fhir1, key1, fhir1sort, sortOpts, fs.fhir(renameMap1, difftable.FHIROptions{
+			ResourceType: fhir1type,
+			Paths:        paths,
+			SampleSize:   jsonlSample,
+		}))
+		if err != nil {
+			log.Printf("fhir1: %s", err)
+			return
+		}
+	}
+
+	if fhir2 != "" {
+		paths, err := readSchema(fhir2paths)
+		if err != nil {
+			log.Printf("fhir2 paths: %s", err)
+			return
+		}
+
+		t2, err = openTable(fhir2, key2, fhir2sort, sortOpts, fs.fhir(renameMap2, difftable.FHIROptions{
+			ResourceType: fhir2type,
+			Paths:        paths,
+			SampleSize:   jsonlSample,
+		}))
+		if err != nil {
+			log.Printf("fhir2: %s", err)
+			return
+		}
+	}
+
+	if xml1 != "" {
+		mapping, err := readMapping(xml1mapping)
+		if err != nil {
+			log.Printf("xml1 mapping: %s", err)
+			return
+		}
+
+		t1, err = openTable(xml1, key1, xml1sort, sortOpts, fs.xml(mapping, renameMap1))
 		if err != nil {
-			log.Printf("avro2 table: %s", err)
+			log.Printf("xml1: %s", err)
 			return
 		}
-		defer f.Close()
+	}
+
+	if xml2 != "" {
+		mapping, err := readMapping(xml2mapping)
+		if err != nil {
+			log.Printf("xml2 mapping: %s", err)
+			return
+		}
+
+		t2, err = openTable(xml2, key2, xml2sort, sortOpts, fs.xml(mapping, renameMap2))
+		if err != nil {
+			log.Printf("xml2: %s", err)
+			return
+		}
+	}
+
+	if parquet1 != "" {
+		t1, err = openTable(parquet1, key1, parquet1sort, sortOpts, fs.parquet(renameMap1, sortTmpDir))
+		if err != nil {
+			log.Printf("parquet1: %s", err)
+			return
+		}
+	}
+
+	if parquet2 != "" {
+		t2, err = openTable(parquet2, key2, parquet2sort, sortOpts, fs.parquet(renameMap2, sortTmpDir))
+		if err != nil {
+			log.Printf("parquet2: %s", err)
+			return
+		}
+	}
+
+	if arrow1 != "" {
+		t1, err = openTable(arrow1, key1, arrow1sort, sortOpts, fs.arrow(renameMap1))
+		if err != nil {
+			log.Printf("arrow1: %s", err)
+			return
+		}
+	}
+
+	if arrow2 != "" {
+		t2, err = openTable(arrow2, key2, arrow2sort, sortOpts, fs.arrow(renameMap2))
+		if err != nil {
+			log.Printf("arrow2: %s", err)
+			return
+		}
+	}
+
+	if sas1 != "" {
+		t1, err = openTable(sas1, key1, sas1sort, sortOpts, fs.sas(renameMap1))
+		if err != nil {
+			log.Printf("sas1: %s", err)
+			return
+		}
+	}
+
+	if sas2 != "" {
+		t2, err = openTable(sas2, key2, sas2sort, sortOpts, fs.sas(renameMap2))
+		if err != nil {
+			log.Printf("sas2: %s", err)
+			return
+		}
+	}
+
+	if dta1 != "" {
+		t1, err = openTable(dta1, key1, dta1sort, sortOpts, fs.stata(renameMap1, difftable.StataOptions{
+			ValueLabels: dta1labels,
+		}, sortTmpDir))
+		if err != nil {
+			log.Printf("dta1: %s", err)
+			return
+		}
+	}
+
+	if dta2 != "" {
+		t2, err = openTable(dta2, key2, dta2sort, sortOpts, fs.stata(renameMap2, difftable.StataOptions{
+			ValueLabels: dta2labels,
+		}, sortTmpDir))
+		if err != nil {
+			log.Printf("dta2: %s", err)
+			return
+		}
+	}
+
+	if sav1 != "" {
+		t1, err = openTable(sav1, key1, sav1sort, sortOpts, fs.spss(renameMap1, difftable.SPSSOptions{
+			ValueLabels: sav1labels,
+		}))
+		if err != nil {
+			log.Printf("sav1: %s", err)
+			return
+		}
+	}
+
+	if sav2 != "" {
+		t2, err = openTable(sav2, key2, sav2sort, sortOpts, fs.spss(renameMap2, difftable.SPSSOptions{
+			ValueLabels: sav2labels,
+		}))
+		if err != nil {
+			log.Printf("sav2: %s", err)
+			return
+		}
+	}
+
+	if xlsx1 != "" {
+		t1, err = openTable(xlsx1, key1, xlsx1sort, sortOpts, fs.xlsx(renameMap1, difftable.XLSXOptions{
+			Sheet:     xlsx1sheet,
+			HeaderRow: xlsx1header,
+		}))
+		if err != nil {
+			log.Printf("xlsx1: %s", err)
+			return
+		}
+	}
+
+	if xlsx2 != "" {
+		t2, err = openTable(xlsx2, key2, xlsx2sort, sortOpts, fs.xlsx(renameMap2, difftable.XLSXOptions{
+			Sheet:     xlsx2sheet,
+			HeaderRow: xlsx2header,
+		}))
+		if err != nil {
+			log.Printf("xlsx2: %s", err)
+			return
+		}
+	}
+
+	if log1 != "" {
+		schema, err := readSchema(log1schema)
+		if err != nil {
+			log.Printf("log1 schema: %s", err)
+			return
+		}
+
+		t1, err = fs.eventLog(log1, key1, renameMap1, difftable.EventLogOptions{
+			Schema: schema,
+		})
+		if err != nil {
+			log.Printf("log1: %s", err)
+			return
+		}
+	}
 
-		rdr, err := goavro.NewOCFReader(f)
+	if log2 != "" {
+		schema, err := readSchema(log2schema)
 		if err != nil {
-			log.Printf("avro2 table: %s", err)
+			log.Printf("log2 schema: %s", err)
 			return
 		}
 
-		t2, err = difftable.AvroTable(rdr, key2, renameMap2)
+		t2, err = fs.eventLog(log2, key2, renameMap2, difftable.EventLogOptions{
+			Schema: schema,
+		})
 		if err != nil {
-			log.Printf("avro2 table: %s", err)
+			log.Printf("log2: %s", err)
 			return
 		}
 	}
 
 	enc := json.NewEncoder(os.Stdout)
 
+	arrowOpts := difftable.ArrowWriterOptions{
+		Stream: arrowStream,
+	}
+
+	// Snapshot the table as Arrow.
+	if snapshot && arrowOut != "" {
+		err := writeArrow(arrowOut, func(w io.Writer) error {
+			return difftable.WriteArrowSnapshot(w, t1, arrowOpts)
+		})
+		if err != nil {
+			log.Printf("snapshot: %s", err)
+		}
+		return
+	}
+
 	// Snapshot the table.
 	if snapshot {
 		err := difftable.Snapshot(t1, func(e *difftable.Event) error {
 			return enc.Encode(e)
 		})
 		if err != nil {
-			log.Print("snapshot: %s", err)
+			log.Printf("snapshot: %s", err)
 		}
 		return
 	}
 
+	// Diff and write row events as Arrow.
+	if events && arrowOut != "" {
+		err := writeArrow(arrowOut, func(w io.Writer) error {
+			aw, err := difftable.NewArrowEventWriter(w, t1, t2, arrowOpts)
+			if err != nil {
+				return err
+			}
+
+			if err := difftable.DiffEventsWithOptions(t1, t2, diffOpts, aw.Write); err != nil {
+				return err
+			}
+
+			return aw.Close()
+		})
+		if err != nil {
+			log.Printf("diff stream: %s", err)
+			logOrderHint(err, sql1, sql2)
+		}
+
+		return
+	}
+
 	// Diff and produce events.
 	if events {
-		err := difftable.DiffEvents(t1, t2, func(e *difftable.Event) error {
+		err := difftable.DiffEventsWithOptions(t1, t2, diffOpts, func(e *difftable.Event) error {
 			// Elide the full data from output.
 			if e.Type == difftable.EventRowChanged || e.Type == difftable.EventRowRemoved {
 				if !fulldata {
@@ -320,15 +912,17 @@ func main() {
 		})
 		if err != nil {
 			log.Printf("diff stream: %s", err)
+			logOrderHint(err, sql1, sql2)
 		}
 
 		return
 	}
 
 	// Diff and summarize.
-	diff, err := difftable.Diff(t1, t2, diffRows)
+	diff, err := difftable.DiffWithOptions(t1, t2, diffRows, diffOpts)
 	if err != nil {
 		log.Printf("diff: %s", err)
+		logOrderHint(err, sql1, sql2)
 		return
 	}
 
@@ -338,31 +932,137 @@ func main() {
 	}
 }
 
-func runQuery(db *sql.DB, schema, table string, key []string) (*sql.Rows, error) {
-	var qtable string
+// logOrderHint explains how to order SQL statements if the diff failed
+// because keys are out of order.
+func logOrderHint(err error, stmts ...string) {
+	if oe, ok := err.(*difftable.OrderError); !ok || oe.Duplicate {
+		return
+	}
 
-	if schema != "" {
-		qtable = fmt.Sprintf(
-			"%s.%s",
-			pq.QuoteIdentifier(schema),
-			pq.QuoteIdentifier(table),
-		)
-	} else {
-		qtable = pq.QuoteIdentifier(table)
+	for _, s := range stmts {
+		if s != "" {
+			log.Print(`hint: SQL statements must order text keys by their bytes, e.g. "order by name collate \"C\"" in Postgres`)
+			return
+		}
 	}
+}
 
-	orderBy := make([]string, len(key))
-	for i, c := range key {
-		orderBy[i] = pq.QuoteIdentifier(c)
+// writeArrow creates the file at the path, or uses stdout for -, and
+// writes Arrow IPC to it.
+func writeArrow(path string, write func(w io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
 	}
 
-	stmt := fmt.Sprintf(`
-		select *
-		from %s
-		order by %s
-	`, qtable, strings.Join(orderBy, ", "))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
 
-	return db.Query(stmt)
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// readSchema reads a JSON object mapping column names to types or, for FHIR
+// resources, paths.
+func readSchema(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var schema map[string]string
+	if err := json.NewDecoder(f).Decode(&schema); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// readLayout reads the layout of a fixed-width file.
+func readLayout(path string) (*difftable.FixedWidthLayout, error) {
+	if path == "" {
+		return nil, fmt.Errorf("layout required")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return difftable.ReadFixedWidthLayout(f)
+}
+
+// readMapping reads the mapping of an XML file.
+func readMapping(path string) (*difftable.XMLMapping, error) {
+	if path == "" {
+		return nil, fmt.Errorf("mapping required")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return difftable.ReadXMLMapping(f)
+}
+
+// csvDialect sets the delimiter, quote and escape characters of the dialect.
+func csvDialect(delim, quote, escape string, d difftable.CSVDialect) (difftable.CSVDialect, error) {
+	var err error
+
+	if d.Delimiter, err = parseRune("delimiter", delim); err != nil {
+		return d, err
+	}
+	if d.Quote, err = parseRune("quote", quote); err != nil {
+		return d, err
+	}
+	if d.Escape, err = parseRune("escape", escape); err != nil {
+		return d, err
+	}
+
+	return d, nil
+}
+
+// parseRune parses a single character or \t for a tab. An empty string is
+// parsed as zero for the default.
+func parseRune(name, s string) (rune, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case `\t`:
+		return '\t', nil
+	}
+
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("%s must be a single character, got `%s`", name, s)
+	}
+
+	r, _ := utf8.DecodeRuneInString(s)
+	return r, nil
+}
+
+// setOptions returns the sorted names of the options that are set.
+func setOptions(opts map[string]string) []string {
+	var names []string
+	for n, v := range opts {
+		if v != "" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
 }
 
 func makeRenameMap(renames string) (map[string]string, error) {
@@ -0,0 +1,308 @@
+package difftable
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Comparator compares two column values as returned by Row.Bytes. It returns
+// -1 if a sorts before b, 1 if a sorts after b and 0 if they are equal.
+type Comparator func(a, b []byte) int
+
+// ColumnComparer is an optional interface a Table can implement to control
+// how the values of a column are ordered. It takes precedence over the
+// comparator registered for the column type. A nil return value defers to
+// the registered comparator.
+type ColumnComparer interface {
+	Comparator(col string) Comparator
+}
+
+var (
+	comparatorsMu sync.RWMutex
+
+	// Comparators keyed by normalized type name. Text types are not
+	// registered since byte ordering, which matches the "C" collation, is
+	// the default.
+	comparators = map[string]Comparator{}
+)
+
+// Normalized names of integer types.
+var integerTypes = []string{
+	"int", "integer", "long", "short", "smallint", "bigint", "tinyint", "mediumint",
+	"int2", "int4", "int8", "int16", "int32", "int64",
+	"uint8", "uint16", "uint32", "uint64",
+	"serial", "smallserial", "bigserial", "serial2", "serial4", "serial8",
+}
+
+// isIntegerType returns true if the normalized type name is an integer
+// type.
+func isIntegerType(typ string) bool {
+	for _, t := range integerTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	for _, t := range integerTypes {
+		comparators[t] = CompareNumeric
+	}
+
+	for _, t := range []string{"numeric", "decimal", "number", "money", "smallmoney"} {
+		comparators[t] = CompareNumeric
+	}
+
+	for _, t := range []string{
+		"float", "double", "real", "float4", "float8", "double precision",
+	} {
+		comparators[t] = CompareFloat
+	}
+
+	for _, t := range []string{
+		"date", "datetime", "datetime2", "smalldatetime", "datetimeoffset",
+		"timestamp", "timestamptz", "timestamp with time zone",
+		"timestamp without time zone",
+	} {
+		comparators[t] = CompareTime
+	}
+}
+
+// normalizeType lowercases a type name and strips modifiers such as length
+// or precision and the unsigned attribute of MySQL integers, e.g.
+// "NUMERIC(10,2)" becomes "numeric" and "UNSIGNED BIGINT" becomes "bigint".
+func normalizeType(typ string) string {
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	if i := strings.IndexByte(typ, '('); i >= 0 {
+		typ = strings.TrimSpace(typ[:i])
+	}
+	if t, ok := unsignedType(typ); ok {
+		typ = t
+	}
+	return typ
+}
+
+// unsignedType returns the type of an unsigned type name, such as
+// "unsigned int" or "int unsigned", and whether it is unsigned.
+func unsignedType(typ string) (string, bool) {
+	if t := strings.TrimPrefix(typ, "unsigned "); t != typ {
+		return strings.TrimSpace(t), true
+	}
+	if t := strings.TrimSuffix(typ, " unsigned"); t != typ {
+		return strings.TrimSpace(t), true
+	}
+	return typ, false
+}
+
+// RegisterComparator registers the comparator used to order key columns of
+// the given type. Type names are case-insensitive and modifiers such as
+// "(10,2)" are ignored. Registering a nil comparator removes the type.
+func RegisterComparator(typ string, c Comparator) {
+	comparatorsMu.Lock()
+	defer comparatorsMu.Unlock()
+
+	typ = normalizeType(typ)
+	if c == nil {
+		delete(comparators, typ)
+		return
+	}
+	comparators[typ] = c
+}
+
+// LookupComparator returns the comparator registered for the type. The
+// second return value is false if no comparator is registered.
+func LookupComparator(typ string) (Comparator, bool) {
+	comparatorsMu.RLock()
+	defer comparatorsMu.RUnlock()
+
+	c, ok := comparators[normalizeType(typ)]
+	return c, ok
+}
+
+// keyComparators returns the comparators used to order the key columns of
+// the two tables. For each key column, a comparator supplied by a table
+// takes precedence, followed by the comparator registered for the column
+// type in table 1 and then table 2. If none apply, values are compared as
+// bytes.
+func keyComparators(t1, t2 Table, key1, key2 []string) []Comparator {
+	cmps := make([]Comparator, len(key1))
+
+	cc1, _ := t1.(ColumnComparer)
+	cc2, _ := t2.(ColumnComparer)
+
+	cols1 := t1.Cols()
+	cols2 := t2.Cols()
+
+	for i := range key1 {
+		var c Comparator
+
+		if cc1 != nil {
+			c = cc1.Comparator(key1[i])
+		}
+		if c == nil && cc2 != nil {
+			c = cc2.Comparator(key2[i])
+		}
+		if c == nil {
+			c, _ = LookupComparator(cols1[key1[i]])
+		}
+		if c == nil {
+			c, _ = LookupComparator(cols2[key2[i]])
+		}
+		if c == nil {
+			c = CompareBytes
+		}
+
+		cmps[i] = c
+	}
+
+	return cmps
+}
+
+// compareNulls orders nil values after non-nil values, matching the default
+// NULLS LAST behavior of ascending sorts in most databases. The second
+// return value is true if either value is nil.
+func compareNulls(a, b []byte) (int, bool) {
+	switch {
+	case a == nil && b == nil:
+		return 0, true
+	case a == nil:
+		return 1, true
+	case b == nil:
+		return -1, true
+	}
+	return 0, false
+}
+
+// CompareBytes compares values byte-wise. This matches the ordering of text
+// in the "C" collation. Nil values sort last like other comparators.
+func CompareBytes(a, b []byte) int {
+	if p, ok := compareNulls(a, b); ok {
+		return p
+	}
+	return bytes.Compare(a, b)
+}
+
+// CompareNumeric compares values as arbitrary precision decimal numbers.
+// Values that cannot be parsed are compared as bytes.
+func CompareNumeric(a, b []byte) int {
+	if p, ok := compareNulls(a, b); ok {
+		return p
+	}
+
+	sa := string(bytes.TrimSpace(a))
+	sb := string(bytes.TrimSpace(b))
+
+	// Fast path for integers.
+	if ia, err := strconv.ParseInt(sa, 10, 64); err == nil {
+		if ib, err := strconv.ParseInt(sb, 10, 64); err == nil {
+			switch {
+			case ia < ib:
+				return -1
+			case ia > ib:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	ra, ok := new(big.Rat).SetString(sa)
+	if !ok {
+		return bytes.Compare(a, b)
+	}
+
+	rb, ok := new(big.Rat).SetString(sb)
+	if !ok {
+		return bytes.Compare(a, b)
+	}
+
+	return ra.Cmp(rb)
+}
+
+// CompareFloat compares values as 64-bit floating point numbers. NaN sorts
+// after all other values. Values that cannot be parsed are compared as bytes.
+func CompareFloat(a, b []byte) int {
+	if p, ok := compareNulls(a, b); ok {
+		return p
+	}
+
+	fa, err := strconv.ParseFloat(string(bytes.TrimSpace(a)), 64)
+	if err != nil {
+		return bytes.Compare(a, b)
+	}
+
+	fb, err := strconv.ParseFloat(string(bytes.TrimSpace(b)), 64)
+	if err != nil {
+		return bytes.Compare(a, b)
+	}
+
+	nanA := math.IsNaN(fa)
+	nanB := math.IsNaN(fb)
+
+	switch {
+	case nanA && nanB:
+		return 0
+	case nanA:
+		return 1
+	case nanB:
+		return -1
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+
+	return 0
+}
+
+// Layouts tried in order when parsing dates and timestamps.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+func parseTime(b []byte) (time.Time, bool) {
+	s := string(bytes.TrimSpace(b))
+	for _, l := range timeLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// CompareTime compares values as dates or timestamps. Values that cannot be
+// parsed are compared as bytes.
+func CompareTime(a, b []byte) int {
+	if p, ok := compareNulls(a, b); ok {
+		return p
+	}
+
+	ta, ok := parseTime(a)
+	if !ok {
+		return bytes.Compare(a, b)
+	}
+
+	tb, ok := parseTime(b)
+	if !ok {
+		return bytes.Compare(a, b)
+	}
+
+	switch {
+	case ta.Before(tb):
+		return -1
+	case ta.After(tb):
+		return 1
+	}
+
+	return 0
+}
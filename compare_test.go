@@ -0,0 +1,112 @@
+package difftable
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestComparators(t *testing.T) {
+	tests := []struct {
+		name string
+		cmp  Comparator
+		a    []byte
+		b    []byte
+		exp  int
+	}{
+		{"bytes", CompareBytes, []byte("10"), []byte("2"), -1},
+		{"bytes null", CompareBytes, nil, []byte(""), 1},
+		{"numeric int", CompareNumeric, []byte("10"), []byte("2"), 1},
+		{"numeric negative", CompareNumeric, []byte("-10"), []byte("2"), -1},
+		{"numeric decimal", CompareNumeric, []byte("1.50"), []byte("1.5"), 0},
+		{"numeric big", CompareNumeric, []byte("99999999999999999999"), []byte("100000000000000000000"), -1},
+		{"numeric null", CompareNumeric, nil, []byte("1"), 1},
+		{"float", CompareFloat, []byte("1e3"), []byte("999.5"), 1},
+		{"float nan", CompareFloat, []byte("NaN"), []byte("1e300"), 1},
+		{"date", CompareTime, []byte("2018-01-02"), []byte("2018-01-10"), -1},
+		{"timestamp", CompareTime, []byte("2018-01-02T05:00:00Z"), []byte("2018-01-02 01:00:00-05:00"), -1},
+	}
+
+	for _, test := range tests {
+		if p := test.cmp(test.a, test.b); p != test.exp {
+			t.Errorf("%s: expected %d, got %d", test.name, test.exp, p)
+		}
+	}
+}
+
+func TestLookupComparator(t *testing.T) {
+	for _, typ := range []string{"int4", "INT8", "numeric(10,2)", "bigint", "long"} {
+		if _, ok := LookupComparator(typ); !ok {
+			t.Errorf("expected comparator for %s", typ)
+		}
+	}
+
+	if _, ok := LookupComparator("text"); ok {
+		t.Error("expected text to use the default comparator")
+	}
+}
+
+func TestKeyComparatorsDriverTypes(t *testing.T) {
+	tests := []struct {
+		typ  string
+		a, b string
+	}{
+		{"UNSIGNED INT", "9", "10"},
+		{"UNSIGNED BIGINT", "9", "18446744073709551615"},
+		{"UNSIGNED SMALLINT", "9", "10"},
+		{"int(10) unsigned", "9", "10"},
+		{"MONEY", "9.5000", "10.0000"},
+		{"SMALLMONEY", "-10.0000", "-9.5000"},
+		{"DATETIMEOFFSET", "2020-01-02T05:00:00+05:00", "2020-01-02T01:00:00Z"},
+		{"SMALLDATETIME", "2020-01-02T09:00:00Z", "2020-01-02T10:00:00Z"},
+	}
+
+	for _, test := range tests {
+		tb := &typedTable{&sliceTable{}, map[string]string{"id": test.typ}}
+
+		cmp := keyComparators(tb, tb, []string{"id"}, []string{"id"})[0]
+		if p := cmp([]byte(test.a), []byte(test.b)); p >= 0 {
+			t.Errorf("%s: expected %s to sort before %s", test.typ, test.a, test.b)
+		}
+	}
+}
+
+// typedTable overrides the column types reported by a table.
+type typedTable struct {
+	Table
+	cols map[string]string
+}
+
+func (t *typedTable) Cols() map[string]string {
+	return t.cols
+}
+
+func TestDiffEventsIntegerKey(t *testing.T) {
+	data1 := `id,name
+1,John
+2,Pam
+10,Sam
+`
+	data2 := `id,name
+1,John
+10,Sam
+`
+
+	key := []string{"id"}
+	cols := map[string]string{
+		"id":   "int4",
+		"name": "text",
+	}
+
+	t1, _ := CSVTable(NewCSVReader(bytes.NewBufferString(data1), ','), key, nil)
+	t2, _ := CSVTable(NewCSVReader(bytes.NewBufferString(data2), ','), key, nil)
+
+	diff, err := Diff(&typedTable{t1, cols}, &typedTable{t2, cols}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.RowsAdded != 0 || diff.RowsDeleted != 1 || diff.RowsChanged != 0 {
+		t.Errorf("expected 1 deleted row, got %d added, %d deleted, %d changed",
+			diff.RowsAdded, diff.RowsDeleted, diff.RowsChanged)
+	}
+}
@@ -0,0 +1,197 @@
+package difftable
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression formats detected by Decompress and OpenFile.
+const (
+	CompressionNone  = ""
+	CompressionGzip  = "gzip"
+	CompressionBzip2 = "bzip2"
+	CompressionZstd  = "zstd"
+	CompressionXZ    = "xz"
+)
+
+// Magic bytes at the start of compressed streams.
+var compressionMagic = []struct {
+	format string
+	magic  []byte
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}},
+	{CompressionBzip2, []byte("BZh")},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{CompressionXZ, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+// Number of bytes needed to detect any of the formats.
+const compressionMagicLen = 6
+
+// Compression formats keyed by file extension.
+var compressionExts = map[string]string{
+	".gz":   CompressionGzip,
+	".gzip": CompressionGzip,
+	".bz2":  CompressionBzip2,
+	".zst":  CompressionZstd,
+	".zstd": CompressionZstd,
+	".xz":   CompressionXZ,
+}
+
+// DetectCompression returns the compression format of a stream starting
+// with the bytes, or CompressionNone if it is not compressed.
+func DetectCompression(header []byte) string {
+	for _, m := range compressionMagic {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.format
+		}
+	}
+	return CompressionNone
+}
+
+// CompressionFromExt returns the compression format named by the extension
+// of the path, or CompressionNone if it is not a known extension.
+func CompressionFromExt(path string) string {
+	return compressionExts[strings.ToLower(filepath.Ext(path))]
+}
+
+// Decompress returns a reader of the decompressed stream if it starts with
+// the magic bytes of gzip, bzip2, zstd or xz. Otherwise the stream is
+// returned as is. Closing the returned reader does not close r.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	// A short stream is not an error; it just can't be compressed.
+	header, err := br.Peek(compressionMagicLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return NewDecompressor(br, DetectCompression(header))
+}
+
+// NewDecompressor returns a reader of the stream decompressed using the
+// format. The stream is returned as is for CompressionNone. Closing the
+// returned reader does not close r.
+func NewDecompressor(r io.Reader, format string) (io.ReadCloser, error) {
+	switch format {
+	case CompressionNone:
+		return ioutil.NopCloser(r), nil
+
+	case CompressionGzip:
+		return gzip.NewReader(r)
+
+	case CompressionBzip2:
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+
+	case CompressionZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+
+	case CompressionXZ:
+		x, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(x), nil
+	}
+
+	return nil, fmt.Errorf("unknown compression `%s`", format)
+}
+
+// OpenFile opens a file for reading, decompressing it if the extension
+// names a compression format or the content starts with its magic bytes.
+// The path - reads from stdin. Uncompressed regular files are returned as
+// the *os.File itself, while stdin and named pipes, which can't be read at
+// an offset, are detected by buffering the first bytes. Closing the
+// returned reader closes the file, but not stdin.
+func OpenFile(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return Decompress(os.Stdin)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = f
+	format := CompressionFromExt(path)
+
+	if format == CompressionNone {
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		header := make([]byte, compressionMagicLen)
+
+		if fi.Mode().IsRegular() {
+			n, err := f.ReadAt(header, 0)
+			if err != nil && err != io.EOF {
+				f.Close()
+				return nil, err
+			}
+
+			format = DetectCompression(header[:n])
+			if format == CompressionNone {
+				return f, nil
+			}
+		} else {
+			br := bufio.NewReader(f)
+
+			header, err = br.Peek(compressionMagicLen)
+			if err != nil && err != io.EOF {
+				f.Close()
+				return nil, err
+			}
+
+			format = DetectCompression(header)
+			r = br
+		}
+	}
+
+	if _, ok := r.(*bufio.Reader); !ok {
+		r = bufio.NewReader(r)
+	}
+
+	d, err := NewDecompressor(r, format)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return &fileReadCloser{
+		ReadCloser: d,
+		file:       f,
+	}, nil
+}
+
+// fileReadCloser closes the decompressor and the underlying file.
+type fileReadCloser struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (r *fileReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	if ferr := r.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
@@ -0,0 +1,184 @@
+package difftable
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const compressTestData = "id,name\n1,John\n"
+
+// bzip2 compressed compressTestData since the standard library has no
+// bzip2 writer.
+var compressTestBzip2 = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xd1, 0x26,
+	0xfb, 0xc4, 0x00, 0x00, 0x05, 0x5d, 0x00, 0x00, 0x10, 0x00, 0x04, 0x20,
+	0x00, 0x00, 0x10, 0x26, 0x63, 0xa0, 0x00, 0x22, 0x9a, 0x6d, 0x27, 0xa3,
+	0x21, 0x03, 0x40, 0xd0, 0xfa, 0x04, 0x50, 0xc4, 0xe9, 0x32, 0xf4, 0x5d,
+	0xc9, 0x14, 0xe1, 0x42, 0x43, 0x44, 0x9b, 0xef, 0x10,
+}
+
+// compressTestFiles returns the test data compressed in each format.
+func compressTestFiles(t *testing.T) map[string][]byte {
+	files := map[string][]byte{
+		CompressionNone:  []byte(compressTestData),
+		CompressionBzip2: compressTestBzip2,
+	}
+
+	var buf bytes.Buffer
+
+	gw := gzip.NewWriter(&buf)
+	io.WriteString(gw, compressTestData)
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files[CompressionGzip] = append([]byte(nil), buf.Bytes()...)
+
+	buf.Reset()
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(zw, compressTestData)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files[CompressionZstd] = append([]byte(nil), buf.Bytes()...)
+
+	buf.Reset()
+	xw, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(xw, compressTestData)
+	if err := xw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files[CompressionXZ] = append([]byte(nil), buf.Bytes()...)
+
+	return files
+}
+
+func TestDecompress(t *testing.T) {
+	for format, data := range compressTestFiles(t) {
+		if f := DetectCompression(data); f != format {
+			t.Errorf("expected format `%s`, got `%s`", format, f)
+		}
+
+		r, err := Decompress(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: %s", format, err)
+			continue
+		}
+
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Errorf("%s: %s", format, err)
+		} else if string(b) != compressTestData {
+			t.Errorf("%s: unexpected data %q", format, b)
+		}
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff-table-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := compressTestFiles(t)
+
+	// Formats detected by extension and, without one, by magic bytes.
+	paths := map[string][]byte{
+		"plain.csv":   files[CompressionNone],
+		"data.csv.gz": files[CompressionGzip],
+		"data.bz2":    files[CompressionBzip2],
+		"data.zst":    files[CompressionZstd],
+		"data.xz":     files[CompressionXZ],
+		"gzip.csv":    files[CompressionGzip],
+		"zstd.csv":    files[CompressionZstd],
+	}
+
+	for name, data := range paths {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := OpenFile(path)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		tb, err := CSVTable(NewCSVReader(f, ','), []string{"id"}, nil)
+		if err != nil {
+			f.Close()
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+
+		if ok, err := tb.Next(); !ok || err != nil {
+			t.Errorf("%s: expected a row, got %v", name, err)
+		} else if v := string(tb.Row().Bytes("name")); v != "John" {
+			t.Errorf("%s: expected John, got %s", name, v)
+		}
+
+		if err := f.Close(); err != nil {
+			t.Errorf("%s: close: %s", name, err)
+		}
+	}
+
+	// An extension that doesn't match the content is an error.
+	path := filepath.Join(dir, "bad.gz")
+	if err := ioutil.WriteFile(path, files[CompressionNone], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFile(path); err == nil {
+		t.Error("expected an error for an invalid gzip file")
+	}
+}
+
+func TestOpenFileStdin(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+
+	stdin := os.Stdin
+	os.Stdin = pr
+	defer func() {
+		os.Stdin = stdin
+	}()
+
+	data := compressTestFiles(t)[CompressionGzip]
+
+	go func() {
+		pw.Write(data)
+		pw.Close()
+	}()
+
+	f, err := OpenFile("-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != compressTestData {
+		t.Errorf("unexpected data %q", b)
+	}
+}
@@ -1,6 +1,7 @@
 package difftable
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/csv"
 	"fmt"
@@ -9,24 +10,40 @@ import (
 
 var bom = []byte{0xef, 0xbb, 0xbf}
 
-// uniReader wraps an io.Reader to replace carriage returns with newlines.
-// This is used with the csv.Reader so it can properly delimit lines.
+// uniReader wraps an io.Reader to replace carriage returns that are not
+// followed by a newline with newlines and to remove a byte order mark. This
+// is used with the csv.Reader so it can properly delimit lines ending with a
+// carriage return.
 type uniReader struct {
-	r io.Reader
+	r       io.Reader
+	br      *bufio.Reader
+	started bool
 }
 
 func (r *uniReader) Read(buf []byte) (int, error) {
-	n, err := r.r.Read(buf)
+	if !r.started {
+		r.started = true
 
-	// Detect and remove BOM.
-	if bytes.HasPrefix(buf, bom) {
-		copy(buf, buf[len(bom):])
-		n -= len(bom)
+		// Detect and remove BOM.
+		if p, _ := r.br.Peek(len(bom)); bytes.Equal(p, bom) {
+			r.br.Discard(len(bom))
+		}
 	}
 
-	// Replace carriage returns with newlines
-	for i, b := range buf {
-		if b == '\r' {
+	n, err := r.br.Read(buf)
+
+	// Replace lone carriage returns with newlines. A carriage return ending
+	// the buffer is checked against the next byte read.
+	for i, b := range buf[:n] {
+		if b != '\r' {
+			continue
+		}
+
+		if i+1 < n {
+			if buf[i+1] != '\n' {
+				buf[i] = '\n'
+			}
+		} else if p, _ := r.br.Peek(1); len(p) == 0 || p[0] != '\n' {
 			buf[i] = '\n'
 		}
 	}
@@ -41,8 +58,11 @@ func (r *uniReader) Close() error {
 	return nil
 }
 
+// NewCSVReader returns a reader of comma-separated values with the
+// delimiter. Quotes are read lazily and leading whitespace is trimmed. Use
+// NewCSVDialectReader for other dialects.
 func NewCSVReader(r io.Reader, d rune) *csv.Reader {
-	cr := csv.NewReader(&uniReader{r})
+	cr := csv.NewReader(&uniReader{r: r, br: bufio.NewReader(r)})
 	cr.Comma = d
 	cr.LazyQuotes = true
 	cr.TrimLeadingSpace = true
@@ -50,7 +70,15 @@ func NewCSVReader(r io.Reader, d rune) *csv.Reader {
 	return cr
 }
 
-func CSVTable(cr *csv.Reader, key []string, renames map[string]string) (Table, error) {
+// CSVRecordReader reads the records of a CSV file, such as a csv.Reader or
+// a CSVReader.
+type CSVRecordReader interface {
+	Read() ([]string, error)
+}
+
+// CSVTable returns a table of the CSV records. The first record has the
+// column names.
+func CSVTable(cr CSVRecordReader, key []string, renames map[string]string) (Table, error) {
 	cols, err := cr.Read()
 	if err != nil {
 		return nil, err
@@ -70,6 +98,9 @@ func CSVTable(cr *csv.Reader, key []string, renames map[string]string) (Table, e
 		if n, ok := renames[c]; ok {
 			c = n
 		}
+		if _, ok := colIdxs[c]; ok {
+			return nil, fmt.Errorf("duplicate column `%s`", c)
+		}
 		colIdxs[c] = i
 		colTypes[c] = "string"
 	}
@@ -83,8 +114,19 @@ func CSVTable(cr *csv.Reader, key []string, renames map[string]string) (Table, e
 	}, nil
 }
 
+// CSVTableWithDialect returns a table of the records of a CSV file in the
+// dialect.
+func CSVTableWithDialect(r io.Reader, key []string, renames map[string]string, d CSVDialect) (Table, error) {
+	cr, err := NewCSVDialectReader(r, d)
+	if err != nil {
+		return nil, err
+	}
+
+	return CSVTable(cr, key, renames)
+}
+
 type csvTable struct {
-	rows *csv.Reader
+	rows CSVRecordReader
 	key  []string
 
 	colLen   int
@@ -132,8 +174,6 @@ func (t *csvTable) Next() (bool, error) {
 }
 
 type csvRow struct {
-	// Unused by unsorted CSV table.
-	key     string
 	colIdxs map[string]int
 	row     []string
 }
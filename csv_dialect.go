@@ -0,0 +1,398 @@
+package difftable
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// CSVDialect describes the format of a CSV file. The zero value reads
+// comma-separated fields quoted by double quotes, which are escaped in
+// quoted fields by doubling them.
+type CSVDialect struct {
+	// Delimiter separates the fields of a record. Defaults to a comma.
+	Delimiter rune
+
+	// Quote encloses fields containing delimiters, quotes or line breaks.
+	// Defaults to a double quote.
+	Quote rune
+
+	// Escape is the character preceding a quote, or any other character,
+	// to read it literally, such as a backslash. Defaults to the quote, so
+	// quotes are escaped by doubling them. Doubled quotes are read in
+	// quoted fields regardless.
+	Escape rune
+
+	// Comment is the prefix of lines that are skipped, such as #.
+	Comment string
+
+	// Skip is the number of lines before the header, such as a banner.
+	Skip int
+
+	// Header is the number of the record with the column names, starting
+	// at 1 after the skipped lines. Records before it are skipped.
+	// Defaults to 1.
+	Header int
+
+	// Strict causes a quote in an unquoted field, text after the closing
+	// quote of a field and an unterminated quoted field to be errors.
+	// Otherwise, the quotes are read literally.
+	Strict bool
+
+	// Trim is the side whitespace is trimmed from unquoted fields and
+	// around quoted fields: both, left, right or none. Defaults to left.
+	Trim string
+}
+
+// withDefaults returns the dialect with the defaults set and validated.
+func (d CSVDialect) withDefaults() (CSVDialect, error) {
+	if d.Delimiter == 0 {
+		d.Delimiter = ','
+	}
+	if d.Quote == 0 {
+		d.Quote = '"'
+	}
+	if d.Escape == 0 {
+		d.Escape = d.Quote
+	}
+	if d.Header == 0 {
+		d.Header = 1
+	}
+	if d.Trim == "" {
+		d.Trim = "left"
+	}
+
+	for _, c := range []rune{d.Delimiter, d.Quote, d.Escape} {
+		if c == '\r' || c == '\n' || c == utf8.RuneError || !utf8.ValidRune(c) {
+			return d, fmt.Errorf("invalid dialect character %q", c)
+		}
+	}
+
+	if d.Delimiter == d.Quote || d.Delimiter == d.Escape {
+		return d, fmt.Errorf("delimiter %q is also the quote or escape", d.Delimiter)
+	}
+
+	if strings.ContainsAny(d.Comment, "\r\n") {
+		return d, fmt.Errorf("comment %q contains a line break", d.Comment)
+	}
+
+	if d.Skip < 0 {
+		return d, fmt.Errorf("negative skip %d", d.Skip)
+	}
+	if d.Header < 0 {
+		return d, fmt.Errorf("negative header %d", d.Header)
+	}
+
+	switch d.Trim {
+	case "both", "left", "right", "none":
+	default:
+		return d, fmt.Errorf("unknown trim `%s`", d.Trim)
+	}
+
+	return d, nil
+}
+
+// CSVReader reads the records of a CSV file in a dialect. Records end with
+// a line feed, a carriage return and line feed or a carriage return. Line
+// breaks in quoted fields are read as they are.
+type CSVReader struct {
+	br *bufio.Reader
+	d  CSVDialect
+
+	trimLeft  bool
+	trimRight bool
+
+	// Whether the lines before the header were skipped.
+	started bool
+
+	// Unparsed text of the current line including its line ending.
+	buf string
+	eof bool
+
+	// Number of line endings read.
+	lines int
+
+	field strings.Builder
+}
+
+// NewCSVDialectReader returns a reader of the records of a CSV file in the
+// dialect.
+func NewCSVDialectReader(r io.Reader, d CSVDialect) (*CSVReader, error) {
+	d, err := d.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CSVReader{
+		br:        bufio.NewReader(r),
+		d:         d,
+		trimLeft:  d.Trim == "left" || d.Trim == "both",
+		trimRight: d.Trim == "right" || d.Trim == "both",
+	}, nil
+}
+
+// Read returns the next record. Blank lines and comments are skipped and
+// io.EOF is returned once the records are exhausted.
+func (r *CSVReader) Read() ([]string, error) {
+	if !r.started {
+		r.started = true
+
+		if err := r.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.next()
+}
+
+// start skips the byte order mark, the lines before the header and the
+// records before the header.
+func (r *CSVReader) start() error {
+	if err := r.fill(); err != nil {
+		return err
+	}
+	r.buf = strings.TrimPrefix(r.buf, string(bom))
+
+	for i := 0; i < r.d.Skip; i++ {
+		if err := r.fill(); err != nil {
+			return err
+		}
+		if r.buf == "" {
+			return nil
+		}
+		r.skipLine()
+	}
+
+	for i := 1; i < r.d.Header; i++ {
+		if _, err := r.next(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fill reads the next line if the current one was parsed. The buffer is
+// left empty at the end of the input.
+func (r *CSVReader) fill() error {
+	if r.buf != "" || r.eof {
+		return nil
+	}
+
+	s, err := r.br.ReadString('\n')
+	if err == io.EOF {
+		r.eof = true
+	} else if err != nil {
+		return err
+	}
+
+	r.buf = s
+	return nil
+}
+
+func (r *CSVReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", r.lines+1, fmt.Sprintf(format, args...))
+}
+
+// eol consumes the line ending starting with c.
+func (r *CSVReader) eol(c rune) {
+	r.buf = r.buf[1:]
+	if c == '\r' && strings.HasPrefix(r.buf, "\n") {
+		r.buf = r.buf[1:]
+	}
+	r.lines++
+}
+
+// skipLine consumes the rest of the line.
+func (r *CSVReader) skipLine() {
+	i := strings.IndexAny(r.buf, "\r\n")
+	if i < 0 {
+		r.buf = ""
+		return
+	}
+
+	r.buf = r.buf[i:]
+	r.eol(rune(r.buf[0]))
+}
+
+// next returns the next record after any blank lines and comments.
+func (r *CSVReader) next() ([]string, error) {
+	for {
+		if err := r.fill(); err != nil {
+			return nil, err
+		}
+		if r.buf == "" {
+			return nil, io.EOF
+		}
+
+		if r.d.Comment != "" && strings.HasPrefix(r.buf, r.d.Comment) {
+			r.skipLine()
+			continue
+		}
+
+		if c := r.buf[0]; c == '\n' || c == '\r' {
+			r.eol(rune(c))
+			continue
+		}
+
+		var rec []string
+
+		for {
+			field, end, err := r.parseField()
+			if err != nil {
+				return nil, err
+			}
+
+			rec = append(rec, field)
+
+			if end {
+				return rec, nil
+			}
+		}
+	}
+}
+
+// parseField parses the next field and reports whether it ends the record.
+func (r *CSVReader) parseField() (string, bool, error) {
+	r.field.Reset()
+
+	if r.trimLeft {
+		r.buf = strings.TrimLeft(r.buf, " \t")
+	}
+
+	c, n := utf8.DecodeRuneInString(r.buf)
+	if n > 0 && c == r.d.Quote {
+		r.buf = r.buf[n:]
+		return r.parseQuoted()
+	}
+
+	return r.parseUnquoted()
+}
+
+func (r *CSVReader) parseUnquoted() (string, bool, error) {
+	for {
+		if err := r.fill(); err != nil {
+			return "", false, err
+		}
+
+		c, n := utf8.DecodeRuneInString(r.buf)
+
+		switch {
+		case n == 0:
+			return r.unquoted(), true, nil
+
+		case c == r.d.Delimiter:
+			r.buf = r.buf[n:]
+			return r.unquoted(), false, nil
+
+		case c == '\n' || c == '\r':
+			r.eol(c)
+			return r.unquoted(), true, nil
+
+		case c == r.d.Escape && r.d.Escape != r.d.Quote:
+			r.buf = r.buf[n:]
+			r.escaped()
+			continue
+
+		case c == r.d.Quote && r.d.Strict:
+			return "", false, r.errorf("bare quote in unquoted field")
+		}
+
+		r.field.WriteString(r.buf[:n])
+		r.buf = r.buf[n:]
+	}
+}
+
+// unquoted returns the unquoted field with trailing whitespace trimmed.
+func (r *CSVReader) unquoted() string {
+	s := r.field.String()
+	if r.trimRight {
+		s = strings.TrimRight(s, " \t")
+	}
+	return s
+}
+
+// escaped writes the character following an escape, or the escape itself
+// at the end of the input.
+func (r *CSVReader) escaped() {
+	c, n := utf8.DecodeRuneInString(r.buf)
+	if n == 0 {
+		r.field.WriteRune(r.d.Escape)
+		return
+	}
+
+	r.field.WriteString(r.buf[:n])
+	r.buf = r.buf[n:]
+
+	if c == '\n' {
+		r.lines++
+	}
+}
+
+func (r *CSVReader) parseQuoted() (string, bool, error) {
+	start := r.lines + 1
+
+	for {
+		if err := r.fill(); err != nil {
+			return "", false, err
+		}
+
+		if r.buf == "" {
+			if r.d.Strict {
+				return "", false, fmt.Errorf("line %d: unterminated quoted field", start)
+			}
+			return r.field.String(), true, nil
+		}
+
+		c, n := utf8.DecodeRuneInString(r.buf)
+		raw := r.buf[:n]
+		r.buf = r.buf[n:]
+
+		switch {
+		case c == r.d.Escape && r.d.Escape != r.d.Quote:
+			r.escaped()
+			continue
+
+		case c == r.d.Quote:
+			rest := r.buf
+			if r.trimRight {
+				rest = strings.TrimLeft(rest, " \t")
+			}
+
+			e, m := utf8.DecodeRuneInString(rest)
+
+			switch {
+			case m == 0:
+				r.buf = rest
+				return r.field.String(), true, nil
+
+			case e == r.d.Quote && len(rest) == len(r.buf):
+				r.field.WriteString(raw)
+				r.buf = r.buf[m:]
+				continue
+
+			case e == r.d.Delimiter:
+				r.buf = rest[m:]
+				return r.field.String(), false, nil
+
+			case e == '\n' || e == '\r':
+				r.buf = rest
+				r.eol(e)
+				return r.field.String(), true, nil
+
+			case r.d.Strict:
+				return "", false, r.errorf("unexpected %q after quoted field", e)
+			}
+
+		case c == '\n':
+			r.lines++
+		}
+
+		r.field.WriteString(raw)
+	}
+}
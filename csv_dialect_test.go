@@ -0,0 +1,167 @@
+package difftable
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func readCSVRecords(t *testing.T, in string, d CSVDialect) ([][]string, error) {
+	t.Helper()
+
+	cr, err := NewCSVDialectReader(bytes.NewBufferString(in), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var recs [][]string
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestCSVDialectReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		dialect  CSVDialect
+		expected [][]string
+	}{
+		{
+			name:     "defaults",
+			in:       "\xef\xbb\xbfid, name\n1, \"a, \"\"b\"\"\"\n\n2,\n",
+			expected: [][]string{{"id", "name"}, {"1", `a, "b"`}, {"2", ""}},
+		},
+		{
+			name:     "crlf",
+			in:       "id,name\r\n1,\"a\r\nb\rc\"\r\n2,d\r\n",
+			expected: [][]string{{"id", "name"}, {"1", "a\r\nb\rc"}, {"2", "d"}},
+		},
+		{
+			name:     "cr",
+			in:       "id,name\r1,a\r2,b",
+			expected: [][]string{{"id", "name"}, {"1", "a"}, {"2", "b"}},
+		},
+		{
+			name:     "tab",
+			in:       "id\tname\n1\ta,b\n",
+			dialect:  CSVDialect{Delimiter: '\t'},
+			expected: [][]string{{"id", "name"}, {"1", "a,b"}},
+		},
+		{
+			name:     "quote",
+			in:       "id;name\n1;'it''s; here'\n",
+			dialect:  CSVDialect{Delimiter: ';', Quote: '\''},
+			expected: [][]string{{"id", "name"}, {"1", "it's; here"}},
+		},
+		{
+			name:     "escape",
+			in:       "id,name\n1,\"a \\\"b\\\" \\\\\"\n2,c\\,d\n",
+			dialect:  CSVDialect{Escape: '\\'},
+			expected: [][]string{{"id", "name"}, {"1", `a "b" \`}, {"2", "c,d"}},
+		},
+		{
+			name:     "comment",
+			in:       "# exported\nid,name\n# none\n1,a\n",
+			dialect:  CSVDialect{Comment: "#"},
+			expected: [][]string{{"id", "name"}, {"1", "a"}},
+		},
+		{
+			name:     "skip and header",
+			in:       "Report \"2020\n\ntitle,,\nid,name\n1,a\n",
+			dialect:  CSVDialect{Skip: 1, Header: 2},
+			expected: [][]string{{"id", "name"}, {"1", "a"}},
+		},
+		{
+			name:     "trim",
+			in:       " id , name \n 1 , \"a \" \n",
+			dialect:  CSVDialect{Trim: "both"},
+			expected: [][]string{{"id", "name"}, {"1", "a "}},
+		},
+		{
+			name:     "no trim",
+			in:       "id, name\n1, a\n",
+			dialect:  CSVDialect{Trim: "none"},
+			expected: [][]string{{"id", " name"}, {"1", " a"}},
+		},
+		{
+			name:     "lazy quotes",
+			in:       "id,name\n1,a \"b\"\n2,\"c\"d\"\n3,\"e\n",
+			expected: [][]string{{"id", "name"}, {"1", `a "b"`}, {"2", `c"d`}, {"3", "e\n"}},
+		},
+	}
+
+	for _, test := range tests {
+		recs, err := readCSVRecords(t, test.in, test.dialect)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if s1, s2, ok := jsonEqual(test.expected, recs); !ok {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", test.name, s1, s2)
+		}
+	}
+}
+
+func TestCSVDialectReaderStrict(t *testing.T) {
+	tests := []string{
+		"id,name\n1,a \"b\"\n",
+		"id,name\n1,\"c\"d\n",
+		"id,name\n1,\"e\n",
+	}
+
+	for _, test := range tests {
+		if _, err := readCSVRecords(t, test, CSVDialect{Strict: true}); err == nil {
+			t.Errorf("expected an error for %q", test)
+		}
+	}
+}
+
+func TestCSVDialectInvalid(t *testing.T) {
+	tests := []CSVDialect{
+		{Delimiter: '"'},
+		{Delimiter: '\n'},
+		{Escape: ','},
+		{Comment: "\n"},
+		{Skip: -1},
+		{Header: -1},
+		{Trim: "all"},
+	}
+
+	for _, test := range tests {
+		if _, err := NewCSVDialectReader(bytes.NewBufferString(""), test); err == nil {
+			t.Errorf("expected an error for %+v", test)
+		}
+	}
+}
+
+func TestCSVTableWithDialect(t *testing.T) {
+	in := "# v1\r\nid|name\r\n1|'a|b'\r\n2|c\r\n"
+
+	tb, err := CSVTableWithDialect(bytes.NewBufferString(in), []string{"id"}, nil, CSVDialect{
+		Delimiter: '|',
+		Quote:     '\'',
+		Comment:   "#",
+		Strict:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []map[string]interface{}{
+		{"id": "1", "name": "a|b"},
+		{"id": "2", "name": "c"},
+	}
+
+	if s1, s2, ok := jsonEqual(expected, datasetRows(t, tb, false)); !ok {
+		t.Errorf("unexpected rows. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
@@ -3,6 +3,7 @@ package difftable
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"testing"
 )
 
@@ -73,8 +74,9 @@ var (
 
 	csvDiffEvents = []*Event{
 		{
-			Type:   EventColumnAdded,
-			Column: "city",
+			Type:    EventColumnAdded,
+			Column:  "city",
+			NewType: "string",
 		},
 		{
 			Type:   EventRowChanged,
@@ -350,3 +352,48 @@ func TestUnsortedCsvTableSnapshot(t *testing.T) {
 		t.Errorf("diff events don't match. expected:\n%sgot:\n%s", s1, s2)
 	}
 }
+
+func TestCsvTableDuplicateColumn(t *testing.T) {
+	tests := []struct {
+		csv     string
+		renames map[string]string
+	}{
+		{"id,name,name\n1,a,b\n", nil},
+		{"id,name,label\n1,a,b\n", map[string]string{"label": "name"}},
+	}
+
+	for _, test := range tests {
+		if _, err := CSVTable(NewCSVReader(bytes.NewBufferString(test.csv), ','), []string{"id"}, test.renames); err == nil {
+			t.Errorf("expected an error for %q", test.csv)
+		}
+	}
+}
+
+func TestCsvReaderLineEndings(t *testing.T) {
+	tests := map[string][][]string{
+		"\xef\xbb\xbfid,name\r\n1,\"a\r\nb\"\r\n2,c\r\n": {{"id", "name"}, {"1", "a\nb"}, {"2", "c"}},
+		"id,name\r1,a\r2,b\r":                            {{"id", "name"}, {"1", "a"}, {"2", "b"}},
+		"id,name\n1,a\n\n2,b":                            {{"id", "name"}, {"1", "a"}, {"2", "b"}},
+	}
+
+	for in, expected := range tests {
+		cr := NewCSVReader(bytes.NewBufferString(in), ',')
+		cr.ReuseRecord = false
+
+		var recs [][]string
+		for {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			recs = append(recs, rec)
+		}
+
+		if s1, s2, ok := jsonEqual(expected, recs); !ok {
+			t.Errorf("%q: expected:\n%s\ngot:\n%s", in, s1, s2)
+		}
+	}
+}
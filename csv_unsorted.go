@@ -1,141 +1,19 @@
 package difftable
 
-import (
-	"encoding/csv"
-	"fmt"
-	"io"
-	"sort"
-	"strings"
-)
-
-// csvRows implements sort.Interface.
-type csvRows []*csvRow
-
-func (r csvRows) Swap(i, j int) {
-	r[i], r[j] = r[j], r[i]
-}
-
-func (r csvRows) Len() int {
-	return len(r)
-}
-
-func (r csvRows) Less(i, j int) bool {
-	return strings.Compare(r[i].key, r[j].key) == -1
-}
-
-func copySlice(s []string) []string {
-	c := make([]string, len(s))
-	copy(c, s)
-	return c
+// UnsortedCSVTable returns a table of the CSV rows ordered by the key. Rows
+// are sorted using the default sort options.
+func UnsortedCSVTable(cr CSVRecordReader, key []string, renames map[string]string) (Table, error) {
+	return UnsortedCSVTableWithOptions(cr, key, renames, SortOptions{})
 }
 
-func UnsortedCSVTable(cr *csv.Reader, key []string, renames map[string]string) (Table, error) {
-	cols, err := cr.Read()
+// UnsortedCSVTableWithOptions returns a table of the CSV rows ordered by the
+// key. Rows are sorted in memory up to the memory limit in the options and
+// the remaining rows are sorted in runs spilled to disk and merged.
+func UnsortedCSVTableWithOptions(cr CSVRecordReader, key []string, renames map[string]string, opts SortOptions) (Table, error) {
+	t, err := CSVTable(cr, key, renames)
 	if err != nil {
 		return nil, err
 	}
 
-	for i, k := range key {
-		if n, ok := renames[k]; ok {
-			key[i] = n
-		}
-	}
-
-	// Create map of column name to index in the array.
-	colIdxs := make(map[string]int, len(cols))
-	colTypes := make(map[string]string, len(cols))
-
-	for i, c := range cols {
-		if n, ok := renames[c]; ok {
-			c = n
-		}
-		colIdxs[c] = i
-		colTypes[c] = "string"
-	}
-
-	keyLen := len(key)
-	keyIdx := make([]int, keyLen)
-	for i, k := range key {
-		keyIdx[i] = colIdxs[k]
-	}
-
-	makeKey := func(r []string) string {
-		k := make([]string, keyLen)
-		for i, x := range keyIdx {
-			k[i] = r[x]
-		}
-		return strings.Join(k, "|")
-	}
-
-	var rows csvRows
-	for {
-		r, err := cr.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
-
-		rows = append(rows, &csvRow{
-			colIdxs: colIdxs,
-			key:     makeKey(r),
-			row:     copySlice(r),
-		})
-	}
-
-	sort.Sort(rows)
-
-	return &unsortedCsvTable{
-		rows:     rows,
-		len:      len(rows),
-		key:      key,
-		colLen:   len(cols),
-		colIdxs:  colIdxs,
-		colTypes: colTypes,
-	}, nil
-}
-
-type unsortedCsvTable struct {
-	rows csvRows
-	len  int
-	idx  int
-
-	key []string
-
-	colLen   int
-	colIdxs  map[string]int
-	colTypes map[string]string
-
-	row *csvRow
-}
-
-func (t *unsortedCsvTable) Key() []string {
-	return t.key
-}
-
-func (t *unsortedCsvTable) Cols() map[string]string {
-	return t.colTypes
-}
-
-func (t *unsortedCsvTable) Row() Row {
-	return t.row
-}
-
-func (t *unsortedCsvTable) Next() (bool, error) {
-	// No more.
-	if t.idx == t.len {
-		return false, nil
-	}
-
-	row := t.rows[t.idx]
-
-	if len(row.row) != t.colLen {
-		return false, fmt.Errorf("expected %d columns, got %d", t.colLen, len(row.row))
-	}
-
-	t.row = row
-	t.idx++
-
-	return true, nil
+	return Sort(t, opts)
 }
@@ -0,0 +1,96 @@
+package difftable
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Helpers shared by the readers of SAS, Stata and SPSS datasets.
+
+// textDecoder returns a function decoding text in the named encoding, such
+// as windows-1252 or latin1. Text in other encodings, including UTF-8, is
+// decoded as UTF-8 if valid and Windows-1252 otherwise since that is what
+// most older datasets use.
+func textDecoder(encoding string) func([]byte) string {
+	var cm *charmap.Charmap
+
+	switch strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(encoding)) {
+	case "latin1", "iso88591", "28591":
+		cm = charmap.ISO8859_1
+	case "wlatin1", "windows1252", "cp1252", "1252":
+		cm = charmap.Windows1252
+	}
+
+	return func(b []byte) string {
+		m := cm
+		if m == nil {
+			if utf8.Valid(b) {
+				return string(b)
+			}
+			m = charmap.Windows1252
+		}
+
+		s, err := m.NewDecoder().Bytes(b)
+		if err != nil {
+			return string(b)
+		}
+		return string(s)
+	}
+}
+
+// formatClock formats a time of day or duration as HH:MM:SS with
+// fractional seconds. Hours may exceed 24 and negative durations are
+// prefixed with a minus sign.
+func formatClock(d time.Duration) string {
+	var sign string
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	s := d / time.Second
+	d -= s * time.Second
+
+	t := fmt.Sprintf("%s%02d:%02d:%02d", sign, h, m, s)
+	if d > 0 {
+		t += strings.TrimRight(fmt.Sprintf(".%06d", d/time.Microsecond), "0")
+	}
+
+	return t
+}
+
+// datasetBytes encodes a value of a dataset column like other text sources.
+// Dates are formatted as YYYY-MM-DD, datetimes as YYYY-MM-DD HH:MM:SS and
+// durations as HH:MM:SS.
+func datasetBytes(kind string, v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(x)
+	case int64:
+		return []byte(strconv.FormatInt(x, 10))
+	case float32:
+		return []byte(strconv.FormatFloat(float64(x), 'f', -1, 32))
+	case float64:
+		return []byte(strconv.FormatFloat(x, 'f', -1, 64))
+	case time.Duration:
+		return []byte(formatClock(x))
+	case time.Time:
+		if kind == "date" {
+			return []byte(x.Format("2006-01-02"))
+		}
+		return []byte(x.Format("2006-01-02 15:04:05.999999"))
+	}
+
+	return []byte(fmt.Sprint(v))
+}
@@ -0,0 +1,92 @@
+package difftable
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// datasetRows reads the rows of the table as the bytes of each column or,
+// if labels is true, the values.
+func datasetRows(t *testing.T, tb Table, labels bool) []map[string]interface{} {
+	var rows []map[string]interface{}
+	for {
+		ok, err := tb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+
+		r := tb.Row()
+		row := make(map[string]interface{})
+		for c := range tb.Cols() {
+			if labels {
+				row[c] = r.Value(c)
+			} else if b := r.Bytes(c); b != nil {
+				row[c] = string(b)
+			} else {
+				row[c] = nil
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// testFixtures reads each file in testdata matching the pattern and
+// compares its rows, in order, to those of the CSV file of the same name,
+// where nulls are empty. The fixtures are written by the software of
+// the format, see testdata/README.md, and missing ones are an error.
+func testFixtures(t *testing.T, pattern string, open func(f *os.File) (Table, error)) {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join("testdata", pattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no fixtures match testdata/%s, see testdata/README.md", pattern)
+	}
+
+	for _, p := range paths {
+		t.Run(filepath.Base(p), func(t *testing.T) {
+			f, err := os.Open(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			tb, err := open(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cf, err := os.Open(strings.TrimSuffix(p, filepath.Ext(p)) + ".csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cf.Close()
+
+			ct, err := CSVTable(NewCSVReader(cf, ','), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rows := datasetRows(t, tb, false)
+			for _, r := range rows {
+				for c, v := range r {
+					if v == nil {
+						r[c] = ""
+					}
+				}
+			}
+
+			if s1, s2, ok := jsonEqual(datasetRows(t, ct, false), rows); !ok {
+				t.Errorf("unexpected rows. expected:\n%s\ngot:\n%s", s1, s2)
+			}
+		})
+	}
+}
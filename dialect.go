@@ -0,0 +1,394 @@
+package difftable
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Dialect describes how to connect to and query a kind of database.
+type Dialect interface {
+	// Driver returns the name of the database/sql driver.
+	Driver() string
+
+	// DSN returns the data source name passed to the driver for the
+	// connection URL.
+	DSN(u *url.URL) (string, error)
+
+	// QuoteIdentifier quotes a table, schema or column name.
+	QuoteIdentifier(name string) string
+
+	// OrderBy returns the ORDER BY expressions for a quoted column of the
+	// type. Text columns are ordered by their bytes and nulls are ordered
+	// last so rows are returned in the order keys are compared in, see
+	// CompareBytes.
+	OrderBy(col, typ string) string
+}
+
+var (
+	dialectsMu sync.RWMutex
+
+	// Dialects keyed by URL scheme.
+	dialects = map[string]Dialect{
+		"postgres":   postgresDialect{},
+		"postgresql": postgresDialect{},
+		"mysql":      mysqlDialect{},
+		"sqlite":     sqliteDialect{},
+		"sqlite3":    sqliteDialect{},
+		"sqlserver":  sqlserverDialect{},
+		"mssql":      sqlserverDialect{},
+	}
+)
+
+// RegisterDialect registers the dialect used for connection URLs with the
+// scheme. Registering a nil dialect removes the scheme.
+func RegisterDialect(scheme string, d Dialect) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if d == nil {
+		delete(dialects, scheme)
+		return
+	}
+	dialects[scheme] = d
+}
+
+// LookupDialect returns the dialect registered for the URL scheme. The
+// second return value is false if no dialect is registered.
+func LookupDialect(scheme string) (Dialect, bool) {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+
+	d, ok := dialects[strings.ToLower(scheme)]
+	return d, ok
+}
+
+// ParseDatabaseURL returns the dialect and driver data source name for a
+// connection URL such as postgres://localhost/db, mysql://user@host/db,
+// sqlite:///path/to/file.db or sqlserver://host?database=db. Strings
+// without a scheme are passed to the postgres driver as is for backwards
+// compatibility.
+func ParseDatabaseURL(rawurl string) (Dialect, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme == "" {
+		return postgresDialect{}, rawurl, nil
+	}
+
+	d, ok := LookupDialect(u.Scheme)
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported database scheme `%s`", u.Scheme)
+	}
+
+	dsn, err := d.DSN(u)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return d, dsn, nil
+}
+
+// OpenDatabase opens a connection to the database at the URL using the
+// driver of its dialect. The driver must be imported by the program.
+func OpenDatabase(rawurl string) (*sql.DB, Dialect, error) {
+	d, dsn, err := ParseDatabaseURL(rawurl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := sql.Open(d.Driver(), dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return db, d, nil
+}
+
+// QueryTable queries the rows of a table ordered by the key. The column
+// types are queried first so text key columns can be ordered by their bytes.
+func QueryTable(db *sql.DB, d Dialect, schema, table string, key []string) (*sql.Rows, error) {
+	qtable := d.QuoteIdentifier(table)
+	if schema != "" {
+		qtable = d.QuoteIdentifier(schema) + "." + qtable
+	}
+
+	types, err := queryColumnTypes(db, qtable)
+	if err != nil {
+		return nil, err
+	}
+
+	var text bool
+
+	orderBy := make([]string, len(key))
+	for i, c := range key {
+		typ, ok := types[c]
+		if !ok {
+			return nil, fmt.Errorf("key column `%s` not in table %s", c, qtable)
+		}
+		orderBy[i] = d.OrderBy(d.QuoteIdentifier(c), typ)
+		text = text || isTextType(typ)
+	}
+
+	if text {
+		if err := CheckCollation(db, d); err != nil {
+			return nil, err
+		}
+	}
+
+	stmt := fmt.Sprintf(`
+		select *
+		from %s
+		order by %s
+	`, qtable, strings.Join(orderBy, ", "))
+
+	return db.Query(stmt)
+}
+
+// collationProbes are strings whose order differs between byte-wise and
+// locale-aware collations, which ignore case, spaces or punctuation.
+var collationProbes = []string{"b", "B", "a", "A", "a b", "ab", "a-c", "_a", "Z", "1"}
+
+// CollationError is returned by CheckCollation if the database orders text
+// differently from CompareBytes.
+type CollationError struct {
+	// Expected is the byte-wise order of the probe strings.
+	Expected []string
+
+	// Got is the order returned by the database.
+	Got []string
+}
+
+func (e *CollationError) Error() string {
+	return fmt.Sprintf("database orders text keys as %q rather than by bytes as %q", e.Got, e.Expected)
+}
+
+// CheckCollation checks the database orders text with the expression
+// returned by the dialect's OrderBy the same as CompareBytes, so text keys
+// are returned in the order they are compared in. A *CollationError is
+// returned if the order differs.
+func CheckCollation(db *sql.DB, d Dialect) error {
+	selects := make([]string, len(collationProbes))
+	for i, p := range collationProbes {
+		selects[i] = fmt.Sprintf("select '%s' as v", p)
+	}
+
+	stmt := fmt.Sprintf(`
+		select v
+		from (%s) p
+		order by %s
+	`, strings.Join(selects, " union all "), d.OrderBy("v", "text"))
+
+	rows, err := db.Query(stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return err
+		}
+		got = append(got, v)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	expected := make([]string, len(collationProbes))
+	copy(expected, collationProbes)
+	sort.Strings(expected)
+
+	for i := range expected {
+		if i >= len(got) || got[i] != expected[i] {
+			return &CollationError{
+				Expected: expected,
+				Got:      got,
+			}
+		}
+	}
+
+	return nil
+}
+
+// queryColumnTypes returns the database types of the columns of a table
+// without reading any rows.
+func queryColumnTypes(db *sql.DB, qtable string) (map[string]string, error) {
+	rows, err := db.Query(fmt.Sprintf("select * from %s where 1 = 0", qtable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cts, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	types := make(map[string]string, len(cts))
+	for _, ct := range cts {
+		types[ct.Name()] = ct.DatabaseTypeName()
+	}
+
+	return types, rows.Close()
+}
+
+// isTextType returns true if the database type holds character strings.
+func isTextType(typ string) bool {
+	switch normalizeType(typ) {
+	case "text", "varchar", "char", "character", "character varying", "bpchar",
+		"name", "citext", "string", "clob",
+		"tinytext", "mediumtext", "longtext",
+		"nchar", "nvarchar", "ntext", "varchar2", "nvarchar2":
+		return true
+	}
+	return false
+}
+
+// quoteIdentifier quotes a name with the character, doubling any
+// occurrences of the closing character.
+func quoteIdentifier(name string, open, close byte) string {
+	// Names cannot contain a NUL character.
+	if i := strings.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	c := string(close)
+	return string(open) + strings.Replace(name, c, c+c, -1) + c
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Driver() string {
+	return "postgres"
+}
+
+func (postgresDialect) DSN(u *url.URL) (string, error) {
+	return u.String(), nil
+}
+
+func (postgresDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, '"', '"')
+}
+
+// OrderBy uses the "C" collation for text keys. Case-insensitive citext
+// values are cast to text since the type ignores the collation. Nulls are
+// ordered last, which is the default.
+func (postgresDialect) OrderBy(col, typ string) string {
+	switch {
+	case normalizeType(typ) == "citext":
+		return col + `::text collate "C" nulls last`
+	case isTextType(typ):
+		return col + ` collate "C" nulls last`
+	}
+	return col + " nulls last"
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Driver() string {
+	return "mysql"
+}
+
+// DSN converts the URL to the user:pass@tcp(host:port)/db?params format
+// of the MySQL driver.
+func (mysqlDialect) DSN(u *url.URL) (string, error) {
+	var dsn string
+
+	if u.User != nil {
+		dsn = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			dsn += ":" + p
+		}
+		dsn += "@"
+	}
+
+	if u.Host != "" {
+		dsn += "tcp(" + u.Host + ")"
+	}
+
+	dsn += "/" + strings.TrimPrefix(u.Path, "/")
+
+	if u.RawQuery != "" {
+		dsn += "?" + u.RawQuery
+	}
+
+	return dsn, nil
+}
+
+func (mysqlDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, '`', '`')
+}
+
+// OrderBy orders nulls last, since MySQL orders them first.
+func (mysqlDialect) OrderBy(col, typ string) string {
+	if isTextType(typ) {
+		return col + " is null, cast(" + col + " as binary)"
+	}
+	return col + " is null, " + col
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Driver() string {
+	return "sqlite"
+}
+
+// DSN returns the path of the database file. Both sqlite:///abs/path.db
+// and sqlite:rel/path.db are supported.
+func (sqliteDialect) DSN(u *url.URL) (string, error) {
+	path := u.Opaque
+	if path == "" {
+		path = u.Host + u.Path
+	}
+
+	if path == "" {
+		return "", fmt.Errorf("sqlite url has no path")
+	}
+
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	return path, nil
+}
+
+func (sqliteDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, '"', '"')
+}
+
+// OrderBy orders nulls last, since SQLite orders them first.
+func (sqliteDialect) OrderBy(col, typ string) string {
+	if isTextType(typ) {
+		return col + " is null, " + col + " collate binary"
+	}
+	return col + " is null, " + col
+}
+
+type sqlserverDialect struct{}
+
+func (sqlserverDialect) Driver() string {
+	return "sqlserver"
+}
+
+func (sqlserverDialect) DSN(u *url.URL) (string, error) {
+	v := *u
+	v.Scheme = "sqlserver"
+	return v.String(), nil
+}
+
+func (sqlserverDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, '[', ']')
+}
+
+// OrderBy orders nulls last, since SQL Server orders them first.
+func (sqlserverDialect) OrderBy(col, typ string) string {
+	nulls := "case when " + col + " is null then 1 else 0 end, "
+	if isTextType(typ) {
+		return nulls + col + " collate Latin1_General_BIN2"
+	}
+	return nulls + col
+}
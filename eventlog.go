@@ -0,0 +1,340 @@
+package difftable
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// EventLogOptions are options for replaying an event log.
+type EventLogOptions struct {
+	// Schema maps column names to types. Types of columns not in the schema
+	// are taken from the column events or, failing that, discovered from
+	// the values like JSONLTable.
+	Schema map[string]string
+}
+
+// EventLogTable returns a table of the state produced by replaying event
+// logs such as a snapshot followed by the events of later diffs.
+func EventLogTable(logs []io.Reader, key []string, renames map[string]string) (Table, error) {
+	return EventLogTableWithOptions(logs, key, renames, EventLogOptions{})
+}
+
+// EventLogTableWithOptions returns a table of the state produced by
+// replaying the event logs in order. Each log is a stream of JSON-encoded
+// events as written by Snapshot or DiffEvents.
+//
+// The row-stored and row-added events set the row for their key and
+// row-removed events delete it. A row-changed event replaces the row with
+// its data or, if the data was omitted, applies the new values of its
+// changes. Column events add, remove, rename or change the type of
+// columns. An event for a row that does not exist, or an added row that
+// already does, means the logs are incomplete or out of order and is an
+// error.
+//
+// The state is held in memory and the rows are yielded in key order. Values
+// are returned and compared as they would be by JSONLTable.
+func EventLogTableWithOptions(logs []io.Reader, key []string, renames map[string]string, opts EventLogOptions) (Table, error) {
+	for i, k := range key {
+		if n, ok := renames[k]; ok {
+			key[i] = n
+		}
+	}
+
+	r := &eventReplayer{
+		key:     key,
+		renames: renames,
+		cols:    make(map[string]string),
+		rows:    make(map[string]map[string]interface{}),
+	}
+
+	for i, l := range logs {
+		if err := r.replay(l); err != nil {
+			return nil, fmt.Errorf("log %d: %s", i+1, err)
+		}
+	}
+
+	for c, ty := range opts.Schema {
+		if n, ok := renames[c]; ok {
+			c = n
+		}
+		r.cols[c] = ty
+	}
+
+	return r.table()
+}
+
+// eventReplayer applies events to the rows of a table.
+type eventReplayer struct {
+	key     []string
+	renames map[string]string
+
+	cols map[string]string
+	rows map[string]map[string]interface{}
+
+	buf []byte
+}
+
+// rename returns the column name after applying the renames.
+func (r *eventReplayer) rename(c string) string {
+	if n, ok := r.renames[c]; ok {
+		return n
+	}
+	return c
+}
+
+// renameMap returns a copy of the map with renamed keys.
+func (r *eventReplayer) renameMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[r.rename(k)] = v
+	}
+
+	return c
+}
+
+// rowKey encodes the key values of the event.
+func (r *eventReplayer) rowKey(k map[string]interface{}) (string, error) {
+	vals := make([][]byte, len(r.key))
+
+	for i, c := range r.key {
+		v, ok := k[c]
+		if !ok {
+			return "", fmt.Errorf("key does not have column `%s`", c)
+		}
+		vals[i] = jsonBytes(v)
+	}
+
+	r.buf = encodeKey(r.buf[:0], vals)
+
+	return string(r.buf), nil
+}
+
+// replay applies the events in the log.
+func (r *eventReplayer) replay(l io.Reader) error {
+	dec := json.NewDecoder(l)
+	dec.UseNumber()
+
+	for n := 1; ; n++ {
+		var e Event
+
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("event %d: %s", n, err)
+		}
+
+		if err := r.apply(&e); err != nil {
+			return fmt.Errorf("event %d: %s", n, err)
+		}
+	}
+}
+
+// apply applies an event.
+func (r *eventReplayer) apply(e *Event) error {
+	switch e.Type {
+	case EventColumnAdded, EventColumnChanged:
+		r.cols[r.rename(e.Column)] = e.NewType
+		return nil
+
+	case EventColumnRemoved:
+		c := r.rename(e.Column)
+		delete(r.cols, c)
+		for _, row := range r.rows {
+			delete(row, c)
+		}
+		return nil
+
+	case EventColumnRenamed:
+		c := r.rename(e.Column)
+		o := r.rename(e.OldColumn)
+		delete(r.cols, o)
+		r.cols[c] = e.NewType
+		for _, row := range r.rows {
+			if v, ok := row[o]; ok {
+				delete(row, o)
+				row[c] = v
+			}
+		}
+		return nil
+
+	// Other schema events don't change the columns or rows.
+	case EventNullability, EventDefaultChanged, EventSymbolsChanged,
+		EventFieldAdded, EventFieldChanged, EventFieldRemoved, EventFieldRenamed:
+		return nil
+	}
+
+	k, err := r.rowKey(r.renameMap(e.Key))
+	if err != nil {
+		return err
+	}
+
+	row, exists := r.rows[k]
+
+	switch e.Type {
+	case EventRowStored, EventRowAdded:
+		if e.Data == nil {
+			return fmt.Errorf("%s event without data", e.Type)
+		}
+		if exists && e.Type == EventRowAdded {
+			return fmt.Errorf("row %v already exists", e.Key)
+		}
+
+		row = r.renameMap(e.Data)
+		r.rows[k] = row
+
+	case EventRowChanged:
+		if !exists {
+			return fmt.Errorf("changed row %v does not exist", e.Key)
+		}
+
+		if e.Data != nil {
+			row = r.renameMap(e.Data)
+			r.rows[k] = row
+		} else {
+			// Changes of columns that were removed, which are reported with
+			// a null new value, are skipped.
+			for c, ch := range e.Changes {
+				c = r.rename(c)
+				if _, ok := r.cols[c]; !ok {
+					continue
+				}
+				row[c] = ch.New
+			}
+		}
+
+	case EventRowRemoved:
+		if !exists {
+			return fmt.Errorf("removed row %v does not exist", e.Key)
+		}
+		delete(r.rows, k)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown event type `%s`", e.Type)
+	}
+
+	// Columns first seen in row data.
+	for c := range row {
+		if _, ok := r.cols[c]; !ok {
+			r.cols[c] = ""
+		}
+	}
+
+	return nil
+}
+
+// table returns the rows ordered by key.
+func (r *eventReplayer) table() (*eventLogTable, error) {
+	// Discover the types of columns without one.
+	for c, ty := range r.cols {
+		if ty != "" {
+			continue
+		}
+		for _, row := range r.rows {
+			ty = mergeJSONType(ty, jsonType(row[c]))
+		}
+		if ty == "" {
+			ty = "null"
+		}
+		r.cols[c] = ty
+	}
+
+	for _, k := range r.key {
+		if _, ok := r.cols[k]; !ok && len(r.rows) > 0 {
+			return nil, fmt.Errorf("rows do not have key column `%s`", k)
+		}
+	}
+
+	t := &eventLogTable{
+		key:  r.key,
+		cols: r.cols,
+		rows: make([]map[string]interface{}, 0, len(r.rows)),
+	}
+
+	keys := make([][][]byte, 0, len(r.rows))
+
+	for _, row := range r.rows {
+		k := make([][]byte, len(r.key))
+		for i, c := range r.key {
+			k[i] = jsonBytes(row[c])
+		}
+		keys = append(keys, k)
+		t.rows = append(t.rows, row)
+	}
+
+	cmps := keyComparators(t, t, t.key, t.key)
+
+	sort.Sort(&keySorter{
+		keys: keys,
+		rows: t.rows,
+		cmps: cmps,
+	})
+
+	return t, nil
+}
+
+// keySorter sorts rows by their key values.
+type keySorter struct {
+	keys [][][]byte
+	rows []map[string]interface{}
+	cmps []Comparator
+}
+
+func (s *keySorter) Len() int {
+	return len(s.rows)
+}
+
+func (s *keySorter) Less(i, j int) bool {
+	return compareRows(s.keys[i], s.keys[j], s.cmps) < 0
+}
+
+func (s *keySorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.rows[i], s.rows[j] = s.rows[j], s.rows[i]
+}
+
+type eventLogTable struct {
+	key  []string
+	cols map[string]string
+	rows []map[string]interface{}
+	idx  int
+
+	record map[string]interface{}
+}
+
+func (t *eventLogTable) Key() []string {
+	return t.key
+}
+
+func (t *eventLogTable) Cols() map[string]string {
+	return t.cols
+}
+
+func (t *eventLogTable) Row() Row {
+	return &jsonlRow{
+		cols:   t.cols,
+		record: t.record,
+	}
+}
+
+func (t *eventLogTable) Next() (bool, error) {
+	t.record = nil
+
+	if t.idx == len(t.rows) {
+		t.rows = nil
+		return false, nil
+	}
+
+	t.record = t.rows[t.idx]
+	t.rows[t.idx] = nil
+	t.idx++
+
+	return true, nil
+}
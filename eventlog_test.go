@@ -0,0 +1,167 @@
+package difftable
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+// eventLog encodes the events as a log.
+func eventLog(t *testing.T, events func(h func(e *Event) error) error) io.Reader {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	if err := events(func(e *Event) error {
+		return enc.Encode(e)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func TestEventLogTable(t *testing.T) {
+	key := []string{"id"}
+
+	csv := func(s string) Table {
+		tb, err := CSVTable(NewCSVReader(bytes.NewBufferString(s), ','), key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tb
+	}
+
+	snapshot := eventLog(t, func(h func(e *Event) error) error {
+		return Snapshot(csv(csvTable1), h)
+	})
+
+	events := eventLog(t, func(h func(e *Event) error) error {
+		return DiffEvents(csv(csvTable1), csv(csvTable2), h)
+	})
+
+	tb, err := EventLogTable([]io.Reader{snapshot, events}, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCols := map[string]string{
+		"id":     "string",
+		"name":   "string",
+		"gender": "string",
+		"color":  "string",
+		"city":   "string",
+	}
+	if s1, s2, ok := jsonEqual(expectedCols, tb.Cols()); !ok {
+		t.Errorf("unexpected columns. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	diff, err := Diff(tb, csv(csvTable2), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.RowsAdded != 0 || diff.RowsDeleted != 0 || diff.RowsChanged != 0 {
+		s, _ := json.Marshal(diff)
+		t.Errorf("expected replayed state to match the new table, got:\n%s", s)
+	}
+}
+
+func TestEventLogTableChanges(t *testing.T) {
+	log := `{"type":"row-stored","key":{"id":2},"data":{"id":2,"name":"Pam"}}
+{"type":"row-stored","key":{"id":10},"data":{"id":10,"name":"Bob"}}
+{"type":"row-changed","key":{"id":2},"changes":{"name":{"old":"Pam","new":"Pamela"}}}
+{"type":"row-added","key":{"id":1},"data":{"id":1,"name":"John"}}
+`
+
+	tb, err := EventLogTable([]io.Reader{bytes.NewBufferString(log)}, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ty := tb.Cols()["id"]; ty != "integer" {
+		t.Errorf("expected integer id, got %s", ty)
+	}
+
+	var names []string
+	for {
+		ok, err := tb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		names = append(names, string(tb.Row().Bytes("name")))
+	}
+
+	if s1, s2, ok := jsonEqual([]string{"John", "Pamela", "Bob"}, names); !ok {
+		t.Errorf("unexpected rows. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestEventLogTableRenamedColumn(t *testing.T) {
+	log := `{"type":"row-stored","key":{"id":1},"data":{"id":1,"name":"John"}}
+{"type":"column-renamed","column":"full_name","old_column":"name","old_type":"string","new_type":"string"}
+{"type":"nullability-changed","column":"full_name","old_nullable":false,"new_nullable":true}
+{"type":"row-changed","key":{"id":1},"changes":{"full_name":{"old":"John","new":"Johnny"}}}
+`
+
+	tb, err := EventLogTable([]io.Reader{bytes.NewBufferString(log)}, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCols := map[string]string{
+		"id":        "integer",
+		"full_name": "string",
+	}
+	if s1, s2, ok := jsonEqual(expectedCols, tb.Cols()); !ok {
+		t.Errorf("unexpected columns. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	if ok, err := tb.Next(); !ok || err != nil {
+		t.Fatalf("expected a row, got %v", err)
+	}
+
+	if v := tb.Row().Value("full_name"); v != "Johnny" {
+		t.Errorf("expected the renamed column to change, got %v", v)
+	}
+}
+
+func TestEventLogTableRemovedColumn(t *testing.T) {
+	log := `{"type":"row-stored","key":{"id":1},"data":{"id":1,"name":"Ann","age":30}}
+{"type":"column-removed","column":"age","old_type":"integer"}
+{"type":"row-changed","key":{"id":1},"changes":{"name":{"old":"Ann","new":"Anna"},"age":{"old":30,"new":null}}}
+`
+
+	tb, err := EventLogTable([]io.Reader{bytes.NewBufferString(log)}, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCols := map[string]string{
+		"id":   "integer",
+		"name": "string",
+	}
+	if s1, s2, ok := jsonEqual(expectedCols, tb.Cols()); !ok {
+		t.Errorf("unexpected columns. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	if ok, err := tb.Next(); !ok || err != nil {
+		t.Fatalf("expected a row, got %v", err)
+	}
+
+	if v := tb.Row().Value("name"); v != "Anna" {
+		t.Errorf("expected the name to change, got %v", v)
+	}
+}
+
+func TestEventLogTableMissingRow(t *testing.T) {
+	log := `{"type":"row-removed","key":{"id":"1"},"data":{"id":"1"}}
+`
+
+	if _, err := EventLogTable([]io.Reader{bytes.NewBufferString(log)}, []string{"id"}, nil); err == nil {
+		t.Error("expected an error for removing a missing row")
+	}
+}
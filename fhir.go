@@ -0,0 +1,466 @@
+package difftable
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// FHIROptions are options for reading FHIR resources.
+type FHIROptions struct {
+	// ResourceType is the type of the resources, such as Patient. Resources
+	// of other types are skipped. Defaults to the type of the first
+	// resource.
+	ResourceType string
+
+	// Paths maps column names to paths of the values in each resource, see
+	// FHIRTableWithOptions. If empty, the columns are the top-level
+	// elements of the resources.
+	Paths map[string]string
+
+	// SampleSize is the number of resources read to discover the columns
+	// and their types. Defaults to DefaultJSONLSampleSize.
+	SampleSize int
+}
+
+// fhirStep is a step of a path, either a child element or a function
+// applied to the values of the previous step.
+type fhirStep struct {
+	name string
+	fn   string
+
+	// Index of the value selected or -1.
+	index int
+
+	// Condition of where, comparing the values at the path to the literal.
+	cond []fhirStep
+	op   string
+	lit  string
+
+	// Separator of join.
+	sep string
+}
+
+// fhirParser parses a path.
+type fhirParser struct {
+	s string
+	i int
+}
+
+func (p *fhirParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, args...), p.i+1)
+}
+
+func (p *fhirParser) space() {
+	for p.i < len(p.s) && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+// consume consumes the string if it is next.
+func (p *fhirParser) consume(s string) bool {
+	p.space()
+	if strings.HasPrefix(p.s[p.i:], s) {
+		p.i += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *fhirParser) ident() string {
+	p.space()
+	j := p.i
+	for p.i < len(p.s) {
+		c := rune(p.s[p.i])
+		if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			break
+		}
+		p.i++
+	}
+	return p.s[j:p.i]
+}
+
+// literal parses a string, number or boolean and returns it encoded like
+// values are for comparison.
+func (p *fhirParser) literal() (string, error) {
+	p.space()
+
+	if p.consume("'") {
+		var b strings.Builder
+		for p.i < len(p.s) {
+			c := p.s[p.i]
+			p.i++
+			switch {
+			case c == '\'':
+				return b.String(), nil
+			case c == '\\' && p.i < len(p.s):
+				b.WriteByte(p.s[p.i])
+				p.i++
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", p.errorf("unterminated string")
+	}
+
+	j := p.i
+	for p.i < len(p.s) && strings.IndexByte("+-.0123456789eE", p.s[p.i]) >= 0 {
+		p.i++
+	}
+	if n := p.s[j:p.i]; n != "" {
+		if _, err := strconv.ParseFloat(n, 64); err != nil {
+			return "", p.errorf("invalid number `%s`", n)
+		}
+		return canonicalNumber(n), nil
+	}
+
+	switch w := p.ident(); w {
+	case "true", "false":
+		return w, nil
+	}
+
+	return "", p.errorf("expected a literal")
+}
+
+// path parses steps separated by dots.
+func (p *fhirParser) path() ([]fhirStep, error) {
+	var steps []fhirStep
+
+	for {
+		name := p.ident()
+		if name == "" {
+			return nil, p.errorf("expected an element or function")
+		}
+
+		step := fhirStep{
+			name:  name,
+			index: -1,
+		}
+
+		if p.consume("(") {
+			step.name = ""
+			step.fn = name
+
+			switch name {
+			case "first", "last", "exists", "count":
+			case "where":
+				cond, err := p.path()
+				if err != nil {
+					return nil, err
+				}
+				step.cond = cond
+
+				switch {
+				case p.consume("!="):
+					step.op = "!="
+				case p.consume("="):
+					step.op = "="
+				default:
+					return nil, p.errorf("expected = or !=")
+				}
+
+				if step.lit, err = p.literal(); err != nil {
+					return nil, err
+				}
+			case "join":
+				p.space()
+				if p.i < len(p.s) && p.s[p.i] != ')' {
+					sep, err := p.literal()
+					if err != nil {
+						return nil, err
+					}
+					step.sep = sep
+				}
+			default:
+				return nil, fmt.Errorf("unknown function `%s`", name)
+			}
+
+			if !p.consume(")") {
+				return nil, p.errorf("expected )")
+			}
+		}
+
+		if p.consume("[") {
+			j := p.i
+			for p.i < len(p.s) && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+				p.i++
+			}
+
+			n, err := strconv.Atoi(p.s[j:p.i])
+			if err != nil {
+				return nil, p.errorf("expected an index")
+			}
+			step.index = n
+
+			if !p.consume("]") {
+				return nil, p.errorf("expected ]")
+			}
+		}
+
+		steps = append(steps, step)
+
+		if !p.consume(".") {
+			return steps, nil
+		}
+	}
+}
+
+// parseFHIRPath parses a path such as name.where(use = 'official').family
+// or identifier[0].value. A leading resource type, such as Patient in
+// Patient.birthDate, is ignored.
+func parseFHIRPath(s string) ([]fhirStep, error) {
+	p := &fhirParser{s: s}
+
+	steps, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+
+	p.space()
+	if p.i < len(p.s) {
+		return nil, p.errorf("unexpected `%s`", p.s[p.i:])
+	}
+
+	// Elements start with a lowercase letter and resource types do not.
+	if len(steps) > 1 && steps[0].fn == "" && unicode.IsUpper(rune(steps[0].name[0])) {
+		steps = steps[1:]
+	}
+
+	return steps, nil
+}
+
+// fhirChoiceTypes are the data types of choice elements, such as value[x],
+// as they are suffixed to the element name, in the order they are tried.
+var fhirChoiceTypes = []string{
+	"Base64Binary", "Boolean", "Canonical", "Code", "Date", "DateTime",
+	"Decimal", "Id", "Instant", "Integer", "Integer64", "Markdown", "Oid",
+	"PositiveInt", "String", "Time", "UnsignedInt", "Uri", "Url", "Uuid",
+	"Address", "Age", "Annotation", "Attachment", "CodeableConcept",
+	"CodeableReference", "Coding", "ContactPoint", "Count", "Distance",
+	"Duration", "HumanName", "Identifier", "Money", "Period", "Quantity",
+	"Range", "Ratio", "RatioRange", "Reference", "SampledData", "Signature",
+	"Timing", "ContactDetail", "Contributor", "DataRequirement",
+	"Expression", "ParameterDefinition", "RelatedArtifact",
+	"TriggerDefinition", "UsageContext", "Availability",
+	"ExtendedContactDetail", "Dosage", "Meta",
+}
+
+// fhirChildren returns the values of the element of each object. Arrays
+// are flattened. Choice elements, such as value[x], are found by their
+// name without the type, so value matches valueQuantity but not valueSet.
+func fhirChildren(items []interface{}, name string) []interface{} {
+	var out []interface{}
+
+	for _, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		v, ok := obj[name]
+		if !ok {
+			for _, t := range fhirChoiceTypes {
+				if x, ok := obj[name+t]; ok {
+					v = x
+					break
+				}
+			}
+		}
+
+		switch x := v.(type) {
+		case nil:
+		case []interface{}:
+			for _, e := range x {
+				if e != nil {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, x)
+		}
+	}
+
+	return out
+}
+
+// evalFHIRPath returns the values at the path of the items.
+func evalFHIRPath(steps []fhirStep, items []interface{}) []interface{} {
+	for _, s := range steps {
+		switch s.fn {
+		case "":
+			items = fhirChildren(items, s.name)
+
+		case "first":
+			if len(items) > 1 {
+				items = items[:1]
+			}
+
+		case "last":
+			if len(items) > 1 {
+				items = items[len(items)-1:]
+			}
+
+		case "exists":
+			items = []interface{}{len(items) > 0}
+
+		case "count":
+			items = []interface{}{json.Number(strconv.Itoa(len(items)))}
+
+		case "where":
+			var out []interface{}
+			for _, item := range items {
+				var match bool
+				for _, v := range evalFHIRPath(s.cond, []interface{}{item}) {
+					if string(jsonBytes(v)) == s.lit {
+						match = true
+						break
+					}
+				}
+				if match == (s.op == "=") {
+					out = append(out, item)
+				}
+			}
+			items = out
+
+		case "join":
+			if len(items) == 0 {
+				break
+			}
+			strs := make([]string, len(items))
+			for i, v := range items {
+				strs[i] = string(jsonBytes(v))
+			}
+			items = []interface{}{strings.Join(strs, s.sep)}
+		}
+
+		if s.index >= 0 {
+			if s.index < len(items) {
+				items = items[s.index : s.index+1]
+			} else {
+				items = nil
+			}
+		}
+	}
+
+	return items
+}
+
+// fhirDecoder decodes resources of one type and flattens them to records.
+type fhirDecoder struct {
+	dec   *json.Decoder
+	typ   string
+	paths map[string][]fhirStep
+
+	// Number of resources read.
+	offset int64
+}
+
+// read decodes the next resource of the type. A nil record is returned
+// when the input is exhausted.
+func (d *fhirDecoder) read() (map[string]interface{}, error) {
+	for {
+		var v interface{}
+
+		if err := d.dec.Decode(&v); err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("resource %d: %s", d.offset+1, err)
+		}
+
+		d.offset++
+
+		res, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("resource %d: expected an object", d.offset)
+		}
+
+		typ, _ := res["resourceType"].(string)
+		if typ == "" {
+			return nil, fmt.Errorf("resource %d: missing resourceType", d.offset)
+		}
+
+		if d.typ == "" {
+			d.typ = typ
+		}
+		if typ != d.typ {
+			continue
+		}
+
+		if d.paths == nil {
+			delete(res, "resourceType")
+			return res, nil
+		}
+
+		rec := make(map[string]interface{}, len(d.paths))
+
+		for c, p := range d.paths {
+			switch vals := evalFHIRPath(p, []interface{}{res}); len(vals) {
+			case 0:
+				rec[c] = nil
+			case 1:
+				rec[c] = vals[0]
+			default:
+				rec[c] = vals
+			}
+		}
+
+		return rec, nil
+	}
+}
+
+// FHIRTable returns a table of the resources of a FHIR Bulk Data NDJSON
+// file using the default options.
+func FHIRTable(r io.Reader, key []string, renames map[string]string) (Table, error) {
+	return FHIRTableWithOptions(r, key, renames, FHIROptions{})
+}
+
+// FHIRTableWithOptions returns a table of the resources of one type in a
+// FHIR Bulk Data NDJSON file, which has one resource per line. The key
+// defaults to id.
+//
+// Each path selects the values of a column using a subset of FHIRPath.
+// Elements are separated by dots, such as name.family, and [n] selects the
+// nth value. The functions first(), last(), exists(), count(),
+// join(separator) and where(path = literal) are supported, for example
+// identifier.where(system = 'urn:mrn').value. A column without values is
+// null, a column with one value has that value and a column with more has
+// an array. The id column is always included.
+//
+// Column types are discovered like JSON Lines tables.
+func FHIRTableWithOptions(r io.Reader, key []string, renames map[string]string, opts FHIROptions) (Table, error) {
+	if len(key) == 0 {
+		key = []string{"id"}
+	}
+
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	d := &fhirDecoder{
+		dec: dec,
+		typ: opts.ResourceType,
+	}
+
+	if len(opts.Paths) > 0 {
+		d.paths = make(map[string][]fhirStep, len(opts.Paths)+1)
+
+		for c, p := range opts.Paths {
+			steps, err := parseFHIRPath(p)
+			if err != nil {
+				return nil, fmt.Errorf("column `%s`: %s", c, err)
+			}
+			d.paths[c] = steps
+		}
+
+		if _, ok := d.paths["id"]; !ok {
+			d.paths["id"] = []fhirStep{{name: "id", index: -1}}
+		}
+	}
+
+	return newJSONLTable(d.read, key, renames, JSONLOptions{
+		SampleSize: opts.SampleSize,
+	})
+}
@@ -0,0 +1,197 @@
+package difftable
+
+import (
+	"bytes"
+	"testing"
+)
+
+const fhirPatients1 = `{"resourceType":"Patient","id":"p1","name":[{"use":"official","family":"Smith","given":["Ann","Marie"]}],"birthDate":"1980-01-02","identifier":[{"system":"urn:mrn","value":"100"},{"system":"urn:ssn","value":"999"}]}
+{"resourceType":"Observation","id":"o1","valueQuantity":{"value":5.0}}
+{"resourceType":"Patient","id":"p2","name":[{"use":"nickname","given":["Bo"]},{"use":"official","family":"Jones","given":["Robert"]}],"birthDate":"1975-05-06"}
+`
+
+const fhirPatients2 = `{"resourceType":"Patient","id":"p1","name":[{"use":"official","family":"Smith-Lee","given":["Ann","Marie"]}],"birthDate":"1980-01-02","identifier":[{"system":"urn:mrn","value":"100"},{"system":"urn:ssn","value":"998"}]}
+{"resourceType":"Patient","id":"p2","name":[{"use":"official","family":"Jones","given":["Robert"]},{"use":"nickname","given":["Bo"]}],"birthDate":"1975-05-06"}
+`
+
+var fhirPatientPaths = map[string]string{
+	"family": "Patient.name.where(use = 'official').family",
+	"given":  "name.where(use = 'official').given.join(' ')",
+	"mrn":    "identifier.where(system = 'urn:mrn').value",
+	"ids":    "identifier.value",
+	"born":   "birthDate",
+	"names":  "name.count()",
+}
+
+func TestFHIRTable(t *testing.T) {
+	tb, err := FHIRTableWithOptions(bytes.NewBufferString(fhirPatients1), nil, nil, FHIROptions{
+		Paths: fhirPatientPaths,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k := tb.Key(); len(k) != 1 || k[0] != "id" {
+		t.Errorf("expected key id, got %v", k)
+	}
+
+	expected := map[string]string{
+		"id":     "string",
+		"family": "string",
+		"given":  "string",
+		"mrn":    "string",
+		"ids":    "array",
+		"born":   "string",
+		"names":  "integer",
+	}
+
+	if s1, s2, ok := jsonEqual(expected, tb.Cols()); !ok {
+		t.Errorf("column types don't match. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	expectedRows := []map[string]interface{}{
+		{"id": "p1", "family": "Smith", "given": "Ann Marie", "mrn": "100", "ids": `["100","999"]`, "born": "1980-01-02", "names": "1"},
+		{"id": "p2", "family": "Jones", "given": "Robert", "mrn": nil, "ids": nil, "born": "1975-05-06", "names": "2"},
+	}
+
+	if s1, s2, ok := jsonEqual(expectedRows, datasetRows(t, tb, false)); !ok {
+		t.Errorf("unexpected rows. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestFHIRTableElements(t *testing.T) {
+	tb, err := FHIRTableWithOptions(bytes.NewBufferString(fhirPatients1), nil, nil, FHIROptions{
+		ResourceType: "Observation",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"id":            "string",
+		"valueQuantity": "object",
+	}
+
+	if s1, s2, ok := jsonEqual(expected, tb.Cols()); !ok {
+		t.Errorf("column types don't match. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	expectedRows := []map[string]interface{}{
+		{"id": "o1", "valueQuantity": `{"value":5}`},
+	}
+
+	if s1, s2, ok := jsonEqual(expectedRows, datasetRows(t, tb, false)); !ok {
+		t.Errorf("unexpected rows. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestFHIRTableDiff(t *testing.T) {
+	opts := FHIROptions{
+		ResourceType: "Patient",
+		Paths:        fhirPatientPaths,
+	}
+
+	t1, err := FHIRTableWithOptions(bytes.NewBufferString(fhirPatients1), nil, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := FHIRTableWithOptions(bytes.NewBufferString(fhirPatients2), nil, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []*Event
+	err = DiffEvents(t1, t2, func(e *Event) error {
+		e.Time = 0
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reordering the names of p2 doesn't change the official name.
+	expected := []*Event{
+		{
+			Type:   EventRowChanged,
+			Offset: 1,
+			Key:    map[string]interface{}{"id": "p1"},
+			Data: map[string]interface{}{
+				"id":     "p1",
+				"family": "Smith-Lee",
+				"given":  "Ann Marie",
+				"mrn":    "100",
+				"ids":    []interface{}{"100", "998"},
+				"born":   "1980-01-02",
+				"names":  1,
+			},
+			Changes: map[string]*ValueChange{
+				"family": {Old: "Smith", New: "Smith-Lee"},
+				"ids":    {Old: []interface{}{"100", "999"}, New: []interface{}{"100", "998"}},
+			},
+		},
+	}
+
+	if s1, s2, ok := jsonEqualEvents(expected, events); !ok {
+		t.Errorf("unexpected events. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestParseFHIRPath(t *testing.T) {
+	tests := []string{
+		"",
+		"name.",
+		"name.where(use)",
+		"name.where(use = official)",
+		"name.where(use = 'official'",
+		"name[x]",
+		"name.lower()",
+		"name family",
+	}
+
+	for _, test := range tests {
+		if _, err := parseFHIRPath(test); err == nil {
+			t.Errorf("expected an error for %q", test)
+		}
+	}
+
+	res := map[string]interface{}{
+		"resourceType": "Observation",
+		"valueQuantity": map[string]interface{}{
+			"value": "5",
+		},
+		"component": []interface{}{
+			map[string]interface{}{"code": "a", "valueString": "x"},
+			map[string]interface{}{"code": "b", "valueString": "y"},
+		},
+		"note": []interface{}{
+			map[string]interface{}{"textSet": "a", "textString": "b"},
+			map[string]interface{}{"textSet": "c"},
+		},
+	}
+
+	paths := map[string]string{
+		"Observation.value.value":            "5",
+		"component.value.last()":             "y",
+		"component[0].value":                 "x",
+		"component.value[1]":                 "y",
+		"component.exists()":                 "true",
+		"component.code.join(',')":           "a,b",
+		"component.where(code != 'a').value": "y",
+		"note.text":                          "b",
+	}
+
+	for p, expected := range paths {
+		steps, err := parseFHIRPath(p)
+		if err != nil {
+			t.Errorf("%s: %s", p, err)
+			continue
+		}
+
+		v := evalFHIRPath(steps, []interface{}{res})
+		if len(v) != 1 || string(jsonBytes(v[0])) != expected {
+			t.Errorf("%s: expected %s, got %v", p, expected, v)
+		}
+	}
+}
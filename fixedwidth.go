@@ -0,0 +1,482 @@
+package difftable
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FixedWidthColumn describes a column of a fixed-width file.
+type FixedWidthColumn struct {
+	Name string `json:"name"`
+
+	// Start is the position of the first character of the column in a
+	// line, starting at 1.
+	Start int `json:"start"`
+
+	// Length is the number of characters of the column.
+	Length int `json:"length"`
+
+	// Type is one of string, integer, number, decimal, boolean, date, time
+	// or datetime. Other integer types, such as int64, are read as integers
+	// and float types as numbers. Defaults to string.
+	Type string `json:"type,omitempty"`
+
+	// Format is the Go time layout of date, time and datetime values, such
+	// as 20060102. Defaults to the layouts of CompareTime.
+	Format string `json:"format,omitempty"`
+
+	// Trim is the side padding is trimmed from: both, left, right or none.
+	// Defaults to both.
+	Trim string `json:"trim,omitempty"`
+
+	// Pad is the set of padding characters. Defaults to a space.
+	Pad string `json:"pad,omitempty"`
+}
+
+// FixedWidthLayout describes the columns of a fixed-width file.
+type FixedWidthLayout struct {
+	Columns []*FixedWidthColumn `json:"columns"`
+
+	// Skip is the number of lines before the first record, such as a header
+	// or banner.
+	Skip int `json:"skip,omitempty"`
+}
+
+// ReadFixedWidthLayout reads a layout encoded as JSON, for example:
+//
+//	{
+//	  "skip": 1,
+//	  "columns": [
+//	    {"name": "id", "start": 1, "length": 6, "type": "integer"},
+//	    {"name": "name", "start": 7, "length": 20, "trim": "right"},
+//	    {"name": "born", "start": 27, "length": 8, "type": "date", "format": "20060102"}
+//	  ]
+//	}
+func ReadFixedWidthLayout(r io.Reader) (*FixedWidthLayout, error) {
+	var l FixedWidthLayout
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&l); err != nil {
+		return nil, err
+	}
+
+	if err := l.validate(); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+func (l *FixedWidthLayout) validate() error {
+	if len(l.Columns) == 0 {
+		return fmt.Errorf("layout has no columns")
+	}
+
+	if l.Skip < 0 {
+		return fmt.Errorf("negative skip %d", l.Skip)
+	}
+
+	seen := make(map[string]bool, len(l.Columns))
+
+	for _, c := range l.Columns {
+		if c.Name == "" {
+			return fmt.Errorf("column at %d has no name", c.Start)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("duplicate column `%s`", c.Name)
+		}
+		seen[c.Name] = true
+
+		if c.Start < 1 {
+			return fmt.Errorf("column `%s`: start must be at least 1", c.Name)
+		}
+		if c.Length < 1 {
+			return fmt.Errorf("column `%s`: length must be at least 1", c.Name)
+		}
+
+		switch c.Trim {
+		case "", "both", "left", "right", "none":
+		default:
+			return fmt.Errorf("column `%s`: unknown trim `%s`", c.Name, c.Trim)
+		}
+
+		if _, ok := fixedWidthKinds[fixedWidthKind(c.Type)]; !ok {
+			return fmt.Errorf("column `%s`: unsupported type `%s`", c.Name, c.Type)
+		}
+	}
+
+	return nil
+}
+
+// Kinds of values read from fixed-width columns.
+var fixedWidthKinds = map[string]bool{
+	"string":   true,
+	"integer":  true,
+	"number":   true,
+	"boolean":  true,
+	"date":     true,
+	"time":     true,
+	"datetime": true,
+}
+
+// fixedWidthKind returns the kind of value of the column type.
+func fixedWidthKind(typ string) string {
+	switch t := normalizeType(typ); t {
+	case "", "string", "text", "char", "varchar":
+		return "string"
+	case "number", "decimal", "numeric", "float", "double", "real", "float4", "float8":
+		return "number"
+	case "boolean", "bool":
+		return "boolean"
+	case "date", "time":
+		return t
+	case "datetime", "timestamp":
+		return "datetime"
+	default:
+		if isIntegerType(t) {
+			return "integer"
+		}
+		return t
+	}
+}
+
+// fixedWidthField is a column of the layout with its renamed name.
+type fixedWidthField struct {
+	*FixedWidthColumn
+
+	name string
+	kind string
+}
+
+// trim removes the padding of a value.
+func (f *fixedWidthField) trim(s string) string {
+	pad := f.Pad
+	if pad == "" {
+		pad = " "
+	}
+
+	switch f.Trim {
+	case "none":
+		return s
+	case "left":
+		return strings.TrimLeft(s, pad)
+	case "right":
+		return strings.TrimRight(s, pad)
+	}
+
+	return strings.Trim(s, pad)
+}
+
+// parse returns the encoded and native value of the text of the column.
+// Blank values of columns other than strings are null.
+func (f *fixedWidthField) parse(raw string) ([]byte, interface{}, error) {
+	s := f.trim(raw)
+
+	if f.kind == "string" {
+		return []byte(s), s, nil
+	}
+
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil, nil
+	}
+
+	// A number padded with zeros is zero.
+	if s == "" && (f.kind == "integer" || f.kind == "number") {
+		s = "0"
+	}
+
+	switch f.kind {
+	case "integer":
+		i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid integer `%s`", s)
+		}
+		return []byte(strconv.FormatInt(i, 10)), i, nil
+
+	case "number":
+		s = strings.TrimSpace(s)
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, nil, fmt.Errorf("invalid number `%s`", s)
+		}
+		n := canonicalNumber(s)
+		return []byte(n), json.Number(n), nil
+
+	case "boolean":
+		var b bool
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "1", "t", "true", "y", "yes":
+			b = true
+		case "0", "f", "false", "n", "no":
+		default:
+			return nil, nil, fmt.Errorf("invalid boolean `%s`", s)
+		}
+		return []byte(strconv.FormatBool(b)), b, nil
+	}
+
+	t, err := f.time(s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch f.kind {
+	case "date":
+		return []byte(t.Format("2006-01-02")), t, nil
+	case "time":
+		return []byte(t.Format("15:04:05.999999999")), t, nil
+	}
+
+	return []byte(t.Format("2006-01-02 15:04:05.999999999")), t, nil
+}
+
+func (f *fixedWidthField) time(s string) (time.Time, error) {
+	if f.Format != "" {
+		t, err := time.Parse(f.Format, strings.TrimSpace(s))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s `%s`", f.kind, s)
+		}
+		return t, nil
+	}
+
+	if f.kind == "time" {
+		if t, err := time.Parse("15:04:05.999999999", strings.TrimSpace(s)); err == nil {
+			return t, nil
+		}
+	}
+
+	t, ok := parseTime([]byte(s))
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid %s `%s`", f.kind, s)
+	}
+
+	return t, nil
+}
+
+// FixedWidthTable returns a table of the lines of a fixed-width file split
+// into columns by the layout. Positions are counted in characters of UTF-8
+// text, columns past the end of a short line are blank and blank lines are
+// skipped. Values of typed columns are validated as lines are read and
+// encoded like other text sources, so integers are stripped of leading zeros
+// and dates formatted as YYYY-MM-DD.
+func FixedWidthTable(r io.Reader, layout *FixedWidthLayout, key []string, renames map[string]string) (Table, error) {
+	if err := layout.validate(); err != nil {
+		return nil, err
+	}
+
+	for i, k := range key {
+		if n, ok := renames[k]; ok {
+			key[i] = n
+		}
+	}
+
+	t := &fixedWidthTable{
+		br:      bufio.NewReader(r),
+		key:     key,
+		cols:    make(map[string]string, len(layout.Columns)),
+		colIdxs: make(map[string]int, len(layout.Columns)),
+	}
+
+	for i, c := range layout.Columns {
+		name := c.Name
+		if n, ok := renames[name]; ok {
+			name = n
+		}
+
+		typ := c.Type
+		if typ == "" {
+			typ = "string"
+		}
+
+		t.fields = append(t.fields, &fixedWidthField{
+			FixedWidthColumn: c,
+			name:             name,
+			kind:             fixedWidthKind(c.Type),
+		})
+
+		t.cols[name] = typ
+		t.colIdxs[name] = i
+		t.colInfo = append(t.colInfo, &Column{
+			Name:      name,
+			Type:      typ,
+			Length:    int64(c.Length),
+			HasLength: true,
+		})
+	}
+
+	for i := 0; i < layout.Skip; i++ {
+		_, ok, err := t.line()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+	}
+
+	return t, nil
+}
+
+type fixedWidthTable struct {
+	br *bufio.Reader
+
+	key     []string
+	cols    map[string]string
+	colInfo []*Column
+	colIdxs map[string]int
+	fields  []*fixedWidthField
+
+	// Line number of the last line read.
+	lineNum int
+
+	bytes  [][]byte
+	values []interface{}
+}
+
+func (t *fixedWidthTable) Key() []string {
+	return t.key
+}
+
+func (t *fixedWidthTable) Cols() map[string]string {
+	return t.cols
+}
+
+// Columns returns the columns in layout order with their lengths.
+func (t *fixedWidthTable) Columns() []*Column {
+	return t.colInfo
+}
+
+func (t *fixedWidthTable) Row() Row {
+	return &fixedWidthRow{
+		colIdxs: t.colIdxs,
+		bytes:   t.bytes,
+		values:  t.values,
+	}
+}
+
+// line returns the next line without the line ending or, for the first
+// line, a byte order mark.
+func (t *fixedWidthTable) line() (string, bool, error) {
+	s, err := t.br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+
+	if err == io.EOF && s == "" {
+		return "", false, nil
+	}
+
+	t.lineNum++
+
+	if t.lineNum == 1 {
+		s = strings.TrimPrefix(s, string(bom))
+	}
+
+	s = strings.TrimSuffix(s, "\n")
+	s = strings.TrimSuffix(s, "\r")
+
+	return s, true, nil
+}
+
+func (t *fixedWidthTable) Next() (bool, error) {
+	t.bytes = nil
+	t.values = nil
+
+	var (
+		s   string
+		ok  bool
+		err error
+	)
+
+	// Skip blank lines, such as a trailing one.
+	for {
+		s, ok, err = t.line()
+		if err != nil || !ok {
+			return false, err
+		}
+		if s != "" {
+			break
+		}
+	}
+
+	// Index runes only if the line is not ASCII.
+	var runes []rune
+	n := len(s)
+
+	if !isASCII(s) {
+		if !utf8.ValidString(s) {
+			return false, fmt.Errorf("line %d: invalid UTF-8", t.lineNum)
+		}
+		runes = []rune(s)
+		n = len(runes)
+	}
+
+	t.bytes = make([][]byte, len(t.fields))
+	t.values = make([]interface{}, len(t.fields))
+
+	for i, f := range t.fields {
+		start := f.Start - 1
+		end := start + f.Length
+
+		if start > n {
+			start = n
+		}
+		if end > n {
+			end = n
+		}
+
+		var v string
+		if runes != nil {
+			v = string(runes[start:end])
+		} else {
+			v = s[start:end]
+		}
+
+		t.bytes[i], t.values[i], err = f.parse(v)
+		if err != nil {
+			return false, fmt.Errorf("line %d: column `%s`: %s", t.lineNum, f.name, err)
+		}
+	}
+
+	return true, nil
+}
+
+// isASCII returns true if the string only contains ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+type fixedWidthRow struct {
+	colIdxs map[string]int
+	bytes   [][]byte
+	values  []interface{}
+}
+
+func (r *fixedWidthRow) Bytes(col string) []byte {
+	i, ok := r.colIdxs[col]
+	if !ok {
+		return nil
+	}
+
+	return r.bytes[i]
+}
+
+func (r *fixedWidthRow) Value(col string) interface{} {
+	i, ok := r.colIdxs[col]
+	if !ok {
+		return nil
+	}
+
+	return r.values[i]
+}
@@ -0,0 +1,142 @@
+package difftable
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+const fixedWidthLayout = `{
+  "skip": 1,
+  "columns": [
+    {"name": "id", "start": 1, "length": 5, "type": "integer"},
+    {"name": "name", "start": 6, "length": 10, "trim": "right"},
+    {"name": "born", "start": 16, "length": 8, "type": "date", "format": "20060102"},
+    {"name": "amount", "start": 24, "length": 7, "type": "decimal", "pad": "0 ", "trim": "left"},
+    {"name": "active", "start": 31, "length": 1, "type": "boolean"}
+  ]
+}`
+
+func TestFixedWidthTable(t *testing.T) {
+	layout, err := ReadFixedWidthLayout(bytes.NewBufferString(fixedWidthLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := "ID   NAME      BORN    AMOUNT A\r\n" +
+		"00001 José     201801020001.50Y\r\n" +
+		"00002          201801030000000N\r\n" +
+		"\r\n" +
+		"00010Sam\r\n"
+
+	tb, err := FixedWidthTable(bytes.NewBufferString(data), layout, []string{"id"}, map[string]string{"name": "full_name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"id":        "integer",
+		"full_name": "string",
+		"born":      "date",
+		"amount":    "decimal",
+		"active":    "boolean",
+	}
+
+	if s1, s2, ok := jsonEqual(expected, tb.Cols()); !ok {
+		t.Errorf("column types don't match. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	var rows []map[string]interface{}
+	for {
+		ok, err := tb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+
+		r := tb.Row()
+		row := make(map[string]interface{})
+		for c := range expected {
+			if b := r.Bytes(c); b != nil {
+				row[c] = string(b)
+			} else {
+				row[c] = nil
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	expectedRows := []map[string]interface{}{
+		{"id": "1", "full_name": " José", "born": "2018-01-02", "amount": "1.5", "active": "true"},
+		{"id": "2", "full_name": "", "born": "2018-01-03", "amount": "0", "active": "false"},
+		{"id": "10", "full_name": "Sam", "born": nil, "amount": nil, "active": nil},
+	}
+
+	if s1, s2, ok := jsonEqual(expectedRows, rows); !ok {
+		t.Errorf("unexpected rows. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestFixedWidthTableValues(t *testing.T) {
+	layout, err := ReadFixedWidthLayout(bytes.NewBufferString(fixedWidthLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout.Skip = 0
+
+	tb, err := FixedWidthTable(bytes.NewBufferString("00001Pam       20180102  12.25Y\n"), layout, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := tb.Next(); !ok || err != nil {
+		t.Fatalf("expected a row, got %v", err)
+	}
+
+	r := tb.Row()
+
+	if v := r.Value("id"); v != int64(1) {
+		t.Errorf("expected id 1, got %#v", v)
+	}
+	if v := r.Value("active"); v != true {
+		t.Errorf("expected active, got %#v", v)
+	}
+	if v, ok := r.Value("born").(time.Time); !ok || !v.Equal(time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected born 2018-01-02, got %#v", r.Value("born"))
+	}
+}
+
+func TestFixedWidthTableInvalid(t *testing.T) {
+	layout, err := ReadFixedWidthLayout(bytes.NewBufferString(fixedWidthLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tb, err := FixedWidthTable(bytes.NewBufferString("header\n0000xPam\n"), layout, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tb.Next(); err == nil || err.Error() != "line 2: column `id`: invalid integer `0000x`" {
+		t.Errorf("expected an invalid integer error, got %v", err)
+	}
+}
+
+func TestReadFixedWidthLayout(t *testing.T) {
+	tests := []string{
+		`{"columns": []}`,
+		`{"columns": [{"name": "id", "start": 0, "length": 1}]}`,
+		`{"columns": [{"name": "id", "start": 1, "length": 1}, {"name": "id", "start": 2, "length": 1}]}`,
+		`{"columns": [{"name": "id", "start": 1, "length": 1, "type": "blob"}]}`,
+		`{"columns": [{"name": "id", "start": 1, "length": 1, "trim": "middle"}]}`,
+		`{"columns": [{"name": "id", "start": 1, "width": 1}]}`,
+	}
+
+	for _, test := range tests {
+		if _, err := ReadFixedWidthLayout(bytes.NewBufferString(test)); err == nil {
+			t.Errorf("expected an error for %s", test)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package difftable
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+)
+
+// HashDiffEvents diffs two tables that are not ordered by key. A hash index
+// of the rows in table 1 is built in memory, keyed on the key values, and
+// the rows of table 2 are streamed against it. Keys match if their values
+// as returned by Row.Bytes are equal.
+//
+// The column-level events are emitted first followed by row-added and
+// row-changed events in the order the rows are read from table 2 and then
+// the row-removed events in the order the rows were read from table 1.
+// Offsets are counted in the same order.
+//
+// A key that is repeated in either table is handled according to the
+// OrderPolicy in the options. If ignored or only warned about, the first
+// row with the key in table 1 is used and later ones are dropped, while
+// every row with the key in table 2 is compared to it, so each is reported
+// as changed, or as added if table 1 has no row with the key.
+func HashDiffEvents(t1, t2 Table, opts *DiffOptions, h func(e *Event) error) error {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+
+	return hashDiffEvents(t1, t2, opts, h)
+}
+
+// encodeKey encodes the key values as a string suitable for a map key.
+// Values are length-prefixed so the encoding is unambiguous and nil values
+// are distinct from empty ones.
+func encodeKey(buf []byte, k [][]byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+
+	for _, v := range k {
+		if v == nil {
+			buf = append(buf, 0)
+			continue
+		}
+
+		buf = append(buf, 1)
+		buf = append(buf, n[:binary.PutUvarint(n[:], uint64(len(v)))]...)
+		buf = append(buf, v...)
+	}
+
+	return buf
+}
+
+// hashEntry is a row of table 1 in the hash index.
+type hashEntry struct {
+	rec     *sortRecord
+	matched bool
+}
+
+func hashDiffEvents(t1, t2 Table, opts *DiffOptions, h func(e *Event) error) error {
+	ts := time.Now().Unix()
+
+	cd, err := diffColumns(t1, t2, ts, h)
+	if err != nil {
+		return err
+	}
+
+	key1 := cd.key1
+	key2 := cd.key2
+	cols1 := cd.cols1
+	cols2 := cd.cols2
+
+	keyLen := len(key1)
+
+	warn := opts.warn()
+
+	// Handles a repeated key according to the policy.
+	duplicate := func(table int, offset int64, r Row, key []string) error {
+		if opts.OrderPolicy == OrderIgnore {
+			return nil
+		}
+
+		err := &OrderError{
+			Table:     table,
+			Offset:    offset,
+			Key:       newKeyMap(r, key),
+			Duplicate: true,
+		}
+
+		if opts.OrderPolicy == OrderWarn {
+			warn(err)
+			return nil
+		}
+
+		return err
+	}
+
+	// Fixed column order for the materialized rows.
+	names := make([]string, 0, len(cols1))
+	for c := range cols1 {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+
+	colIdxs := make(map[string]int, len(names))
+	for i, c := range names {
+		colIdxs[c] = i
+	}
+
+	var (
+		k       = make([][]byte, keyLen)
+		buf     []byte
+		offset  int64
+		entries []*hashEntry
+		index   = make(map[string]*hashEntry)
+	)
+
+	// Build the index of table 1.
+	for {
+		ok, err := t1.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		offset++
+
+		r1 := t1.Row()
+		for i, c := range key1 {
+			k[i] = r1.Bytes(c)
+		}
+
+		buf = encodeKey(buf[:0], k)
+
+		if _, ok := index[string(buf)]; ok {
+			if err := duplicate(1, offset, r1, key1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		e := &hashEntry{
+			rec: materializeRow(r1, names),
+		}
+
+		index[string(buf)] = e
+		entries = append(entries, e)
+	}
+
+	// Keys of table 2 seen to detect duplicates.
+	var seen map[string]struct{}
+	if opts.OrderPolicy != OrderIgnore {
+		seen = make(map[string]struct{})
+	}
+
+	offset = 0
+
+	// Stream table 2 against the index.
+	for {
+		ok, err := t2.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		offset++
+
+		r2 := t2.Row()
+		for i, c := range key2 {
+			k[i] = r2.Bytes(c)
+		}
+
+		buf = encodeKey(buf[:0], k)
+
+		if seen != nil {
+			if _, ok := seen[string(buf)]; ok {
+				if err := duplicate(2, offset, r2, key2); err != nil {
+					return err
+				}
+			} else {
+				seen[string(buf)] = struct{}{}
+			}
+		}
+
+		e, ok := index[string(buf)]
+
+		// Row seen in new table, but not old table, thus it has been added.
+		if !ok {
+			if err := h(&Event{
+				Type:   EventRowAdded,
+				Time:   ts,
+				Offset: offset,
+				Key:    newKeyMap(r2, key2),
+				Data:   newValueMap(r2, cols2),
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		e.matched = true
+
+		r1 := newSortRow(colIdxs, e.rec)
+
+		changes := cd.rowChanges(r1, r2)
+
+		if len(changes) > 0 {
+			if err := h(&Event{
+				Type:    EventRowChanged,
+				Time:    ts,
+				Offset:  offset,
+				Key:     newKeyMap(r1, key1),
+				Data:    newValueMap(r2, cols2),
+				Changes: changes,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Rows seen in old table, but not new table, thus they have been deleted.
+	for _, e := range entries {
+		if e.matched {
+			continue
+		}
+
+		offset++
+
+		r1 := newSortRow(colIdxs, e.rec)
+
+		if err := h(&Event{
+			Type:   EventRowRemoved,
+			Time:   ts,
+			Offset: offset,
+			Key:    newKeyMap(r1, key1),
+			Data:   newValueMap(r1, cols1),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
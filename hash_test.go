@@ -0,0 +1,206 @@
+package difftable
+
+import (
+	"bytes"
+	"testing"
+)
+
+var hashDiffEventsExpected = []*Event{
+	{
+		Type:    EventColumnAdded,
+		Column:  "city",
+		NewType: "string",
+	},
+	{
+		Type:   EventRowAdded,
+		Offset: 1,
+		Key: map[string]interface{}{
+			"id": "4",
+		},
+		Data: map[string]interface{}{
+			"city":   "Allentown",
+			"color":  "Black",
+			"gender": "Male",
+			"id":     "4",
+			"name":   "Neal",
+		},
+	},
+	{
+		Type:   EventRowChanged,
+		Offset: 2,
+		Key: map[string]interface{}{
+			"id": "1",
+		},
+		Changes: map[string]*ValueChange{
+			"city": &ValueChange{
+				Old: nil,
+				New: "Trenton",
+			},
+			"color": &ValueChange{
+				Old: "Blue",
+				New: "Teal",
+			},
+		},
+	},
+	{
+		Type:   EventRowChanged,
+		Offset: 3,
+		Key: map[string]interface{}{
+			"id": "3",
+		},
+		Changes: map[string]*ValueChange{
+			"city": &ValueChange{
+				Old: nil,
+				New: "Philadelphia",
+			},
+		},
+	},
+	{
+		Type:   EventRowRemoved,
+		Offset: 4,
+		Key: map[string]interface{}{
+			"id": "2",
+		},
+	},
+}
+
+func TestHashDiffEvents(t *testing.T) {
+	r1 := bytes.NewBufferString(unsortedCsvTable1)
+	c1 := NewCSVReader(r1, ',')
+
+	r2 := bytes.NewBufferString(unsortedCsvTable2)
+	c2 := NewCSVReader(r2, ',')
+
+	key := []string{"id"}
+
+	t1, err := CSVTable(c1, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := CSVTable(c2, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []*Event
+	err = HashDiffEvents(t1, t2, nil, func(e *Event) error {
+		if e.Type == EventRowChanged || e.Type == EventRowRemoved {
+			e.Data = nil
+		}
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s1, s2, ok := jsonEqualEvents(hashDiffEventsExpected, events); !ok {
+		t.Errorf("diff events don't match. expected:\n%sgot:\n%s", s1, s2)
+	}
+}
+
+func TestHashDiff(t *testing.T) {
+	r1 := bytes.NewBufferString(unsortedCsvTable1)
+	c1 := NewCSVReader(r1, ',')
+
+	r2 := bytes.NewBufferString(unsortedCsvTable2)
+	c2 := NewCSVReader(r2, ',')
+
+	key := []string{"id"}
+
+	t1, err := CSVTable(c1, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := CSVTable(c2, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := DiffWithOptions(t1, t2, true, &DiffOptions{HashJoin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s1, s2, ok := jsonEqual(csvTableDiff, diff); !ok {
+		t.Errorf("diff doesn't match. expected:\n%sgot:\n%s", s1, s2)
+	}
+}
+
+func TestHashDiffEventsDuplicate(t *testing.T) {
+	data := `id,name
+1,John
+1,Pam
+`
+
+	key := []string{"id"}
+
+	t1, err := CSVTable(NewCSVReader(bytes.NewBufferString(csvTable1), ','), key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := CSVTable(NewCSVReader(bytes.NewBufferString(data), ','), key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = HashDiffEvents(t1, t2, nil, func(e *Event) error {
+		return nil
+	})
+
+	if oerr, ok := err.(*OrderError); !ok || !oerr.Duplicate || oerr.Table != 2 || oerr.Offset != 2 {
+		t.Errorf("expected duplicate key error, got %v", err)
+	}
+}
+
+func TestHashDiffEventsDuplicateWarn(t *testing.T) {
+	data := `id,name,gender,color
+1,John,Male,Blue
+1,Pam,Female,Red
+`
+
+	key := []string{"id"}
+
+	t1, err := CSVTable(NewCSVReader(bytes.NewBufferString(csvTable1), ','), key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := CSVTable(NewCSVReader(bytes.NewBufferString(data), ','), key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings int
+
+	opts := &DiffOptions{
+		OrderPolicy: OrderWarn,
+		Warn: func(err error) {
+			warnings++
+		},
+	}
+
+	var changed []string
+	err = HashDiffEvents(t1, t2, opts, func(e *Event) error {
+		if e.Type == EventRowChanged {
+			changed = append(changed, e.Data["name"].(string))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if warnings != 1 {
+		t.Errorf("expected 1 warning, got %d", warnings)
+	}
+
+	// The repeated row is compared to the row in table 1 rather than
+	// dropped.
+	if len(changed) != 1 || changed[0] != "Pam" {
+		t.Errorf("expected the repeated row to be changed, got %v", changed)
+	}
+}
@@ -0,0 +1,419 @@
+package difftable
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultJSONLSampleSize is the default number of records read to discover
+// the columns of a JSON Lines table.
+const DefaultJSONLSampleSize = 100
+
+func init() {
+	gob.Register(json.Number(""))
+}
+
+// JSONLOptions are options for reading a JSON Lines table.
+type JSONLOptions struct {
+	// SampleSize is the number of records read to discover the columns and
+	// their types. Fields that only appear after the sample are ignored.
+	// Defaults to DefaultJSONLSampleSize.
+	SampleSize int
+
+	// Schema maps column names to types. If set, the columns are not
+	// discovered and fields not in the schema are ignored.
+	Schema map[string]string
+}
+
+// JSONLTable returns a table of newline-delimited JSON objects. The columns
+// are discovered from the first records using the default options.
+func JSONLTable(r io.Reader, key []string, renames map[string]string) (Table, error) {
+	return JSONLTableWithOptions(r, key, renames, JSONLOptions{})
+}
+
+// JSONLTableWithOptions returns a table of newline-delimited JSON objects.
+//
+// Column types are one of string, integer, number, boolean, object or array.
+// A column whose values have different types is typed json and a column
+// whose sampled values are all null is typed null.
+func JSONLTableWithOptions(r io.Reader, key []string, renames map[string]string, opts JSONLOptions) (Table, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	d := &jsonlDecoder{dec: dec}
+
+	return newJSONLTable(d.read, key, renames, opts)
+}
+
+// newJSONLTable returns a table of the records returned by read, which
+// returns a nil record when the input is exhausted.
+func newJSONLTable(read func() (map[string]interface{}, error), key []string, renames map[string]string, opts JSONLOptions) (Table, error) {
+	if opts.SampleSize <= 0 {
+		opts.SampleSize = DefaultJSONLSampleSize
+	}
+
+	for i, k := range key {
+		if n, ok := renames[k]; ok {
+			key[i] = n
+		}
+	}
+
+	t := &jsonlTable{
+		next:    read,
+		key:     key,
+		renames: renames,
+	}
+
+	var cols map[string]string
+
+	if opts.Schema != nil {
+		cols = make(map[string]string, len(opts.Schema))
+		for c, ty := range opts.Schema {
+			if n, ok := renames[c]; ok {
+				c = n
+			}
+			cols[c] = ty
+		}
+	} else {
+		cols = make(map[string]string)
+
+		for len(t.sample) < opts.SampleSize {
+			rec, err := t.read()
+			if err != nil {
+				return nil, err
+			}
+			if rec == nil {
+				break
+			}
+
+			t.sample = append(t.sample, rec)
+
+			for c, v := range rec {
+				cols[c] = mergeJSONType(cols[c], jsonType(v))
+			}
+		}
+	}
+
+	t.cols = cols
+
+	return t, nil
+}
+
+// jsonType returns the type name of a decoded JSON value.
+func jsonType(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := strconv.ParseInt(string(x), 10, 64); err == nil {
+			return "integer"
+		}
+		return "number"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return "json"
+}
+
+// mergeJSONType returns the type of a column given the type of another
+// value in it.
+func mergeJSONType(t1, t2 string) string {
+	switch {
+	case t1 == "" || t1 == "null":
+		return t2
+	case t2 == "null" || t1 == t2:
+		return t1
+	case t1 == "integer" && t2 == "number", t1 == "number" && t2 == "integer":
+		return "number"
+	}
+	return "json"
+}
+
+// jsonBytes encodes a decoded JSON value for comparison. Strings are
+// returned as is and numbers in plain decimal notation so they compare the
+// same as other text sources. Objects and arrays are encoded as JSON with
+// sorted keys. Null values are nil.
+func jsonBytes(v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(x)
+	case json.Number:
+		return []byte(canonicalNumber(string(x)))
+	case bool:
+		if x {
+			return []byte("true")
+		}
+		return []byte("false")
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(canonicalJSON(v)); err != nil {
+		return []byte(fmt.Sprint(v))
+	}
+
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
+
+// canonicalJSON returns the value with numbers in canonical form.
+func canonicalJSON(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		return json.Number(canonicalNumber(string(x)))
+
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[k] = canonicalJSON(e)
+		}
+		return m
+
+	case []interface{}:
+		a := make([]interface{}, len(x))
+		for i, e := range x {
+			a[i] = canonicalJSON(e)
+		}
+		return a
+	}
+
+	return v
+}
+
+// maxNumberExponent bounds the exponents expanded by canonicalNumber.
+const maxNumberExponent = 1000
+
+// canonicalNumber rewrites a JSON number literal in plain decimal notation
+// without redundant zeros so equal numbers have the same representation,
+// e.g. 1.50 and 15e-1 both become 1.5. Literals with very large exponents
+// are returned as is.
+func canonicalNumber(s string) string {
+	var neg bool
+	n := s
+
+	if strings.HasPrefix(n, "-") {
+		neg = true
+		n = n[1:]
+	}
+
+	exp := 0
+	if i := strings.IndexAny(n, "eE"); i >= 0 {
+		e, err := strconv.Atoi(n[i+1:])
+		if err != nil || e > maxNumberExponent || e < -maxNumberExponent {
+			return s
+		}
+		exp = e
+		n = n[:i]
+	}
+
+	// All digits with the position of the decimal point.
+	point := len(n)
+	if i := strings.IndexByte(n, '.'); i >= 0 {
+		point = i
+		n = n[:i] + n[i+1:]
+	}
+	point += exp
+
+	// Pad with zeros so the point falls within the digits.
+	if point < 0 {
+		n = strings.Repeat("0", -point) + n
+		point = 0
+	}
+	if point > len(n) {
+		n += strings.Repeat("0", point-len(n))
+	}
+
+	ip := strings.TrimLeft(n[:point], "0")
+	fp := strings.TrimRight(n[point:], "0")
+
+	if ip == "" {
+		ip = "0"
+	}
+
+	out := ip
+	if fp != "" {
+		out += "." + fp
+	}
+
+	if neg && out != "0" {
+		out = "-" + out
+	}
+
+	return out
+}
+
+// jsonValue converts numbers in a decoded JSON value to int64 if they are
+// integers and float64 otherwise.
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(x), 10, 64); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(string(x), 64); err == nil {
+			return f
+		}
+		return x
+
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[k] = jsonValue(e)
+		}
+		return m
+
+	case []interface{}:
+		a := make([]interface{}, len(x))
+		for i, e := range x {
+			a[i] = jsonValue(e)
+		}
+		return a
+	}
+
+	return v
+}
+
+// jsonlDecoder decodes newline-delimited JSON objects.
+type jsonlDecoder struct {
+	dec *json.Decoder
+
+	// Number of records read.
+	offset int64
+}
+
+// read decodes the next record. A nil record is returned when the input is
+// exhausted.
+func (d *jsonlDecoder) read() (map[string]interface{}, error) {
+	var v interface{}
+
+	if err := d.dec.Decode(&v); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("record %d: %s", d.offset+1, err)
+	}
+
+	d.offset++
+
+	rec, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("record %d: expected an object", d.offset)
+	}
+
+	return rec, nil
+}
+
+type jsonlTable struct {
+	next    func() (map[string]interface{}, error)
+	key     []string
+	cols    map[string]string
+	renames map[string]string
+
+	// Records read while discovering the columns.
+	sample []map[string]interface{}
+
+	record map[string]interface{}
+}
+
+// read returns the next record applying the renames. A nil record is
+// returned when the input is exhausted.
+func (t *jsonlTable) read() (map[string]interface{}, error) {
+	rec, err := t.next()
+	if err != nil || rec == nil {
+		return nil, err
+	}
+
+	if len(t.renames) == 0 {
+		return rec, nil
+	}
+
+	renamed := make(map[string]interface{}, len(rec))
+	for c, x := range rec {
+		if n, ok := t.renames[c]; ok {
+			c = n
+		}
+		renamed[c] = x
+	}
+
+	return renamed, nil
+}
+
+func (t *jsonlTable) Key() []string {
+	return t.key
+}
+
+func (t *jsonlTable) Cols() map[string]string {
+	return t.cols
+}
+
+func (t *jsonlTable) Row() Row {
+	return &jsonlRow{
+		cols:   t.cols,
+		record: t.record,
+	}
+}
+
+func (t *jsonlTable) Next() (bool, error) {
+	t.record = nil
+
+	if len(t.sample) > 0 {
+		t.record = t.sample[0]
+		t.sample[0] = nil
+		t.sample = t.sample[1:]
+		return true, nil
+	}
+
+	rec, err := t.read()
+	if err != nil || rec == nil {
+		return false, err
+	}
+
+	t.record = rec
+
+	return true, nil
+}
+
+type jsonlRow struct {
+	cols   map[string]string
+	record map[string]interface{}
+}
+
+func (r *jsonlRow) Bytes(col string) []byte {
+	if _, ok := r.cols[col]; !ok {
+		return nil
+	}
+
+	return jsonBytes(r.record[col])
+}
+
+// CanonicalBytes returns the canonical encoding of the value so a null and
+// an empty string or a number and a string of it are different when
+// compared with another JSON source.
+func (r *jsonlRow) CanonicalBytes(col string) []byte {
+	if _, ok := r.cols[col]; !ok {
+		return nil
+	}
+
+	return CanonicalBytes(r.record[col])
+}
+
+func (r *jsonlRow) Value(col string) interface{} {
+	if _, ok := r.cols[col]; !ok {
+		return nil
+	}
+
+	return jsonValue(r.record[col])
+}
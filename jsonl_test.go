@@ -0,0 +1,152 @@
+package difftable
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	jsonlTable1 = `{"id": 1, "name": "John", "score": 1.5, "tags": {"a": 1, "b": 2}}
+{"id": 2, "name": "Pam", "score": 2, "tags": null}
+{"id": 10, "name": "Sam", "score": 3, "tags": {"a": 1}}
+`
+
+	jsonlTable2 = `{"id": 1, "name": "John", "score": 1.50, "tags": {"b": 2, "a": 1}}
+{"id": 10, "name": "Sam", "score": 3, "tags": {"a": 2}, "active": true}
+`
+)
+
+func TestJSONLTableCols(t *testing.T) {
+	tb, err := JSONLTable(bytes.NewBufferString(jsonlTable2), []string{"id"}, map[string]string{"name": "full_name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"id":        "integer",
+		"full_name": "string",
+		"score":     "number",
+		"tags":      "object",
+		"active":    "boolean",
+	}
+
+	if s1, s2, ok := jsonEqual(expected, tb.Cols()); !ok {
+		t.Errorf("columns don't match. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	ok, err := tb.Next()
+	if !ok || err != nil {
+		t.Fatalf("expected a row, got %v", err)
+	}
+
+	r := tb.Row()
+	if v := r.Value("id"); v != int64(1) {
+		t.Errorf("expected id 1, got %#v", v)
+	}
+	if v := r.Value("full_name"); v != "John" {
+		t.Errorf("expected name John, got %#v", v)
+	}
+	if b := string(r.Bytes("tags")); b != `{"a":1,"b":2}` {
+		t.Errorf("expected canonical tags, got %s", b)
+	}
+	if b := r.Bytes("active"); b != nil {
+		t.Errorf("expected nil active, got %s", b)
+	}
+}
+
+func TestJSONLTableDiff(t *testing.T) {
+	key := []string{"id"}
+
+	t1, err := JSONLTable(bytes.NewBufferString(jsonlTable1), key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := JSONLTableWithOptions(bytes.NewBufferString(jsonlTable2), key, nil, JSONLOptions{
+		Schema: map[string]string{
+			"id":    "integer",
+			"name":  "string",
+			"score": "number",
+			"tags":  "object",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := Diff(t1, t2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.RowsDeleted != 1 || diff.RowsAdded != 0 || diff.RowsChanged != 1 {
+		t.Fatalf("expected 1 deleted and 1 changed row, got %d deleted, %d added, %d changed",
+			diff.RowsDeleted, diff.RowsAdded, diff.RowsChanged)
+	}
+
+	if c := diff.RowDiffs[0].Changes; len(c) != 1 || c["tags"] == nil {
+		t.Errorf("expected tags change for id 10, got %v", c)
+	}
+}
+
+func TestJSONLTableTypeChanges(t *testing.T) {
+	key := []string{"id"}
+
+	t1, err := JSONLTable(bytes.NewBufferString(`{"id": 1, "name": null, "score": 1}
+{"id": 2, "name": "Pam", "score": 2.0}
+`), key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := JSONLTable(bytes.NewBufferString(`{"id": 1, "name": "", "score": 1}
+{"id": 2, "name": "Pam", "score": "2"}
+`), key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := Diff(t1, t2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff.RowsChanged != 2 || diff.RowsAdded != 0 || diff.RowsDeleted != 0 {
+		t.Fatalf("expected 2 changed rows, got %d changed, %d added and %d deleted", diff.RowsChanged, diff.RowsAdded, diff.RowsDeleted)
+	}
+
+	expected := []map[string]*ValueChange{
+		{"name": {Old: nil, New: ""}},
+		{"score": {Old: 2.0, New: "2"}},
+	}
+
+	var changes []map[string]*ValueChange
+	for _, d := range diff.RowDiffs {
+		changes = append(changes, d.Changes)
+	}
+
+	if s1, s2, ok := jsonEqual(expected, changes); !ok {
+		t.Errorf("unexpected changes. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestCanonicalNumber(t *testing.T) {
+	tests := map[string]string{
+		"0":       "0",
+		"-0":      "0",
+		"10":      "10",
+		"1.50":    "1.5",
+		"15e-1":   "1.5",
+		"1.5E2":   "150",
+		"-0.0012": "-0.0012",
+		"1.2e-3":  "0.0012",
+		"007":     "7",
+		"1e2000":  "1e2000",
+	}
+
+	for in, exp := range tests {
+		if out := canonicalNumber(in); out != exp {
+			t.Errorf("%s: expected %s, got %s", in, exp, out)
+		}
+	}
+}
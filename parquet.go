@@ -0,0 +1,123 @@
+package difftable
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/apache/arrow-go/v18/parquet/schema"
+)
+
+// ParquetBatchSize is the number of rows read at a time from a Parquet file.
+const ParquetBatchSize = 64 * 1024
+
+// ParquetTable returns a table of the rows in a Parquet file. Rows are read
+// in batches so only a portion of a row group is held in memory at a time.
+//
+// Column types are derived from the Parquet logical types, such as string,
+// date, timestamp, timestamptz, time, decimal(10,2), int16 or uuid, falling
+// back to the physical type, such as int32, int64, double or bytes. Nested
+// columns are typed list, map or struct.
+func ParquetTable(rdr *file.Reader, key []string, renames map[string]string) (Table, error) {
+	fr, err := pqarrow.NewFileReader(rdr, pqarrow.ArrowReadProperties{
+		BatchSize: ParquetBatchSize,
+	}, memory.DefaultAllocator)
+	if err != nil {
+		return nil, err
+	}
+
+	rr, err := fr.GetRecordReader(context.Background(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	root := rdr.MetaData().Schema.Root()
+	fields := rr.Schema().Fields()
+
+	types := make([]string, len(fields))
+	for i, f := range fields {
+		idx := root.FieldIndexByName(f.Name)
+		if idx < 0 {
+			return nil, fmt.Errorf("parquet column `%s` not found in schema", f.Name)
+		}
+		types[i] = parquetType(root.Field(idx))
+	}
+
+	return newRecordTable(rr, key, renames, types), nil
+}
+
+// parquetType returns the column type name of a schema node.
+func parquetType(n schema.Node) string {
+	switch t := n.LogicalType().(type) {
+	case schema.StringLogicalType:
+		return "string"
+	case schema.EnumLogicalType:
+		return "enum"
+	case schema.JSONLogicalType:
+		return "json"
+	case schema.BSONLogicalType:
+		return "bson"
+	case schema.UUIDLogicalType:
+		return "uuid"
+	case schema.DateLogicalType:
+		return "date"
+	case schema.TimeLogicalType:
+		return "time"
+	case schema.TimestampLogicalType:
+		if t.IsAdjustedToUTC() {
+			return "timestamptz"
+		}
+		return "timestamp"
+	case schema.DecimalLogicalType:
+		return fmt.Sprintf("decimal(%d,%d)", t.Precision(), t.Scale())
+	case schema.IntLogicalType:
+		if t.IsSigned() {
+			return fmt.Sprintf("int%d", t.BitWidth())
+		}
+		return fmt.Sprintf("uint%d", t.BitWidth())
+	case schema.Float16LogicalType:
+		return "float16"
+	case schema.ListLogicalType:
+		return "list"
+	case schema.MapLogicalType:
+		return "map"
+	case schema.IntervalLogicalType:
+		return "interval"
+	}
+
+	p, ok := n.(*schema.PrimitiveNode)
+	if !ok {
+		return "struct"
+	}
+
+	// Legacy converted types without an equivalent logical type.
+	if n.ConvertedType() == schema.ConvertedTypes.Decimal {
+		d := p.DecimalMetadata()
+		return fmt.Sprintf("decimal(%d,%d)", d.Precision, d.Scale)
+	}
+
+	switch p.PhysicalType() {
+	case parquet.Types.Boolean:
+		return "boolean"
+	case parquet.Types.Int32:
+		return "int32"
+	case parquet.Types.Int64:
+		return "int64"
+	case parquet.Types.Int96:
+		return "timestamp"
+	case parquet.Types.Float:
+		return "float"
+	case parquet.Types.Double:
+		return "double"
+	case parquet.Types.ByteArray:
+		return "bytes"
+	case parquet.Types.FixedLenByteArray:
+		return fmt.Sprintf("fixed(%d)", p.TypeLength())
+	}
+
+	return strings.ToLower(p.PhysicalType().String())
+}
@@ -0,0 +1,156 @@
+package difftable
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/decimal128"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+type parquetTestRow struct {
+	id     int64
+	name   string
+	born   int32
+	amount int64
+}
+
+// writeParquet writes the rows to an in-memory Parquet file with small row
+// groups.
+func writeParquet(t *testing.T, rows []parquetTestRow) *file.Reader {
+	sc := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "name", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "born", Type: arrow.FixedWidthTypes.Date32},
+		{Name: "amount", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}},
+	}, nil)
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, sc)
+	defer b.Release()
+
+	for _, r := range rows {
+		b.Field(0).(*array.Int64Builder).Append(r.id)
+		if r.name == "" {
+			b.Field(1).AppendNull()
+		} else {
+			b.Field(1).(*array.StringBuilder).Append(r.name)
+		}
+		b.Field(2).(*array.Date32Builder).Append(arrow.Date32(r.born))
+		b.Field(3).(*array.Decimal128Builder).Append(decimal128.FromI64(r.amount))
+	}
+
+	rec := b.NewRecordBatch()
+	defer rec.Release()
+
+	var buf bytes.Buffer
+
+	fw, err := pqarrow.NewFileWriter(sc, &buf, parquet.NewWriterProperties(parquet.WithMaxRowGroupLength(2)), pqarrow.DefaultWriterProps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Write(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rdr, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rdr
+}
+
+func TestParquetTable(t *testing.T) {
+	rdr := writeParquet(t, []parquetTestRow{
+		{1, "John", 17532, 1050},
+		{2, "", 17533, -25},
+		{10, "Sam", 17534, 0},
+	})
+	defer rdr.Close()
+
+	tb, err := ParquetTable(rdr, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"id":     "int64",
+		"name":   "string",
+		"born":   "date",
+		"amount": "decimal(10,2)",
+	}
+
+	if s1, s2, ok := jsonEqual(expected, tb.Cols()); !ok {
+		t.Errorf("column types don't match. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	var rows []map[string]interface{}
+	for {
+		ok, err := tb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+
+		r := tb.Row()
+		if r.Bytes("name") == nil && r.Value("name") != nil {
+			t.Errorf("expected nil name")
+		}
+		rows = append(rows, map[string]interface{}{
+			"id":     string(r.Bytes("id")),
+			"born":   string(r.Bytes("born")),
+			"amount": string(r.Bytes("amount")),
+		})
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows across row groups, got %d", len(rows))
+	}
+
+	if r := rows[0]; r["id"] != "1" || r["born"] != "2018-01-01" || r["amount"] != "10.50" {
+		t.Errorf("unexpected row %v", r)
+	}
+
+	if r := rows[1]; r["amount"] != "-0.25" {
+		t.Errorf("unexpected row %v", r)
+	}
+}
+
+func TestParquetTableValues(t *testing.T) {
+	rdr := writeParquet(t, []parquetTestRow{
+		{1, "John", 17532, 1050},
+	})
+	defer rdr.Close()
+
+	tb, err := ParquetTable(rdr, []string{"id"}, map[string]string{"name": "full_name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := tb.Next(); !ok || err != nil {
+		t.Fatalf("expected a row, got %v", err)
+	}
+
+	r := tb.Row()
+
+	if v := r.Value("id"); v != int64(1) {
+		t.Errorf("expected id 1, got %#v", v)
+	}
+	if v := r.Value("full_name"); v != "John" {
+		t.Errorf("expected name John, got %#v", v)
+	}
+	if v, ok := r.Value("born").(time.Time); !ok || !v.Equal(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected born 2018-01-01, got %#v", r.Value("born"))
+	}
+}
@@ -0,0 +1,361 @@
+package difftable
+
+import (
+	"container/heap"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PartFiles returns the paths of the part files of a partitioned table. If
+// the pattern is a directory, the files in it are returned, ignoring hidden
+// files and files starting with an underscore such as _SUCCESS markers. If
+// it contains glob characters, the files matching it are returned. Otherwise
+// the pattern is returned as the only path. Paths are sorted by name.
+func PartFiles(pattern string) ([]string, error) {
+	if pattern == "-" {
+		return []string{pattern}, nil
+	}
+
+	if fi, err := os.Stat(pattern); err == nil && fi.IsDir() {
+		infos, err := ioutil.ReadDir(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		var paths []string
+		for _, fi := range infos {
+			n := fi.Name()
+			if fi.IsDir() || strings.HasPrefix(n, ".") || strings.HasPrefix(n, "_") {
+				continue
+			}
+			paths = append(paths, filepath.Join(pattern, n))
+		}
+
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("directory `%s` has no files", pattern)
+		}
+
+		return paths, nil
+	}
+
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, p := range matches {
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			paths = append(paths, p)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files match `%s`", pattern)
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// checkParts checks the parts of a table have the same key and columns.
+func checkParts(parts []Table) error {
+	if len(parts) == 0 {
+		return fmt.Errorf("table has no parts")
+	}
+
+	key := parts[0].Key()
+	cols := parts[0].Cols()
+
+	for i, p := range parts[1:] {
+		n := i + 2
+
+		if k := p.Key(); strings.Join(k, ",") != strings.Join(key, ",") {
+			return fmt.Errorf("part %d: key %v does not match %v of part 1", n, k, key)
+		}
+
+		pcols := p.Cols()
+
+		for c, typ := range cols {
+			ptyp, ok := pcols[c]
+			if !ok {
+				return fmt.Errorf("part %d: missing column `%s`", n, c)
+			}
+			if ptyp != typ {
+				return fmt.Errorf("part %d: column `%s` has type `%s` rather than `%s`", n, c, ptyp, typ)
+			}
+		}
+
+		for c := range pcols {
+			if _, ok := cols[c]; !ok {
+				return fmt.Errorf("part %d: unexpected column `%s`", n, c)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ConcatTables returns a table yielding the rows of each part in turn. The
+// parts must have the same key and columns. The rows are only in key order
+// if the parts are ordered and don't overlap, otherwise use Sort.
+func ConcatTables(parts []Table) (Table, error) {
+	if err := checkParts(parts); err != nil {
+		return nil, err
+	}
+
+	return &concatTable{
+		parts: parts,
+	}, nil
+}
+
+type concatTable struct {
+	parts []Table
+	idx   int
+}
+
+func (t *concatTable) Key() []string {
+	return t.parts[0].Key()
+}
+
+func (t *concatTable) Cols() map[string]string {
+	return t.parts[0].Cols()
+}
+
+// Comparator defers to the first part if it defines its own ordering.
+func (t *concatTable) Comparator(col string) Comparator {
+	if cc, ok := t.parts[0].(ColumnComparer); ok {
+		return cc.Comparator(col)
+	}
+	return nil
+}
+
+// Schema defers to the first part if it describes its schema.
+func (t *concatTable) Schema() interface{} {
+	if sd, ok := t.parts[0].(SchemaDiffer); ok {
+		return sd.Schema()
+	}
+	return nil
+}
+
+// DiffSchema defers to the first part if it describes its schema.
+func (t *concatTable) DiffSchema(old interface{}) (*SchemaDiff, error) {
+	if sd, ok := t.parts[0].(SchemaDiffer); ok {
+		return sd.DiffSchema(old)
+	}
+	return nil, nil
+}
+
+func (t *concatTable) Row() Row {
+	if t.idx == len(t.parts) {
+		return nil
+	}
+	return t.parts[t.idx].Row()
+}
+
+func (t *concatTable) Next() (bool, error) {
+	for t.idx < len(t.parts) {
+		ok, err := t.parts[t.idx].Next()
+		if err != nil {
+			return false, fmt.Errorf("part %d: %s", t.idx+1, err)
+		}
+		if ok {
+			return true, nil
+		}
+		t.idx++
+	}
+
+	return false, nil
+}
+
+// MergeTables returns a table yielding the rows of the parts in key order
+// by merging them. Each part must be ordered by the key and have the same
+// key and columns. Rows with the same key are yielded in part order.
+func MergeTables(parts []Table) (Table, error) {
+	if err := checkParts(parts); err != nil {
+		return nil, err
+	}
+
+	key := parts[0].Key()
+
+	h := &partHeap{
+		cmps: keyComparators(parts[0], parts[0], key, key),
+	}
+
+	for i, p := range parts {
+		h.parts = append(h.parts, &mergePart{
+			table: p,
+			idx:   i,
+		})
+	}
+
+	return &mergeTable{
+		parts: parts,
+		key:   key,
+		heap:  h,
+	}, nil
+}
+
+// mergePart is a part with its current row and key.
+type mergePart struct {
+	table Table
+	idx   int
+	row   Row
+	key   [][]byte
+}
+
+// next advances the part to its next row.
+func (p *mergePart) next(key []string) (bool, error) {
+	ok, err := p.table.Next()
+	if err != nil {
+		return false, fmt.Errorf("part %d: %s", p.idx+1, err)
+	}
+	if !ok {
+		p.row = nil
+		return false, nil
+	}
+
+	p.row = p.table.Row()
+	p.key = p.key[:0]
+	for _, k := range key {
+		p.key = append(p.key, p.row.Bytes(k))
+	}
+
+	return true, nil
+}
+
+// partHeap implements heap.Interface ordering parts by their current key.
+// Ties are broken by part index.
+type partHeap struct {
+	cmps  []Comparator
+	parts []*mergePart
+}
+
+func (h *partHeap) Len() int {
+	return len(h.parts)
+}
+
+func (h *partHeap) Less(i, j int) bool {
+	p := compareRows(h.parts[i].key, h.parts[j].key, h.cmps)
+	if p == 0 {
+		return h.parts[i].idx < h.parts[j].idx
+	}
+	return p < 0
+}
+
+func (h *partHeap) Swap(i, j int) {
+	h.parts[i], h.parts[j] = h.parts[j], h.parts[i]
+}
+
+func (h *partHeap) Push(x interface{}) {
+	h.parts = append(h.parts, x.(*mergePart))
+}
+
+func (h *partHeap) Pop() interface{} {
+	n := len(h.parts)
+	p := h.parts[n-1]
+	h.parts = h.parts[:n-1]
+	return p
+}
+
+type mergeTable struct {
+	parts []Table
+	key   []string
+	heap  *partHeap
+
+	// The part the current row is from.
+	cur     *mergePart
+	started bool
+}
+
+func (t *mergeTable) Key() []string {
+	return t.key
+}
+
+func (t *mergeTable) Cols() map[string]string {
+	return t.parts[0].Cols()
+}
+
+// Comparator defers to the first part if it defines its own ordering.
+func (t *mergeTable) Comparator(col string) Comparator {
+	if cc, ok := t.parts[0].(ColumnComparer); ok {
+		return cc.Comparator(col)
+	}
+	return nil
+}
+
+// Schema defers to the first part if it describes its schema.
+func (t *mergeTable) Schema() interface{} {
+	if sd, ok := t.parts[0].(SchemaDiffer); ok {
+		return sd.Schema()
+	}
+	return nil
+}
+
+// DiffSchema defers to the first part if it describes its schema.
+func (t *mergeTable) DiffSchema(old interface{}) (*SchemaDiff, error) {
+	if sd, ok := t.parts[0].(SchemaDiffer); ok {
+		return sd.DiffSchema(old)
+	}
+	return nil, nil
+}
+
+func (t *mergeTable) Row() Row {
+	if t.cur == nil {
+		return nil
+	}
+	return t.cur.row
+}
+
+func (t *mergeTable) Next() (bool, error) {
+	h := t.heap
+
+	if !t.started {
+		t.started = true
+
+		// Read the first row of each part.
+		parts := h.parts
+		h.parts = nil
+
+		for _, p := range parts {
+			ok, err := p.next(t.key)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				h.parts = append(h.parts, p)
+			}
+		}
+
+		heap.Init(h)
+	} else if t.cur != nil {
+		// Advance the part of the previous row, which is at the top.
+		ok, err := t.cur.next(t.key)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	if h.Len() == 0 {
+		t.cur = nil
+		return false, nil
+	}
+
+	t.cur = h.parts[0]
+
+	return true, nil
+}
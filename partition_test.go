@@ -0,0 +1,133 @@
+package difftable
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// csvParts returns a CSV table for each of the part contents.
+func csvParts(t *testing.T, parts ...string) []Table {
+	tables := make([]Table, len(parts))
+
+	for i, p := range parts {
+		tb, err := CSVTable(NewCSVReader(bytes.NewBufferString(p), ','), []string{"id"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tables[i] = tb
+	}
+
+	return tables
+}
+
+// tableIDs reads the id column of all rows in the table.
+func tableIDs(t *testing.T, tb Table) []string {
+	var ids []string
+
+	for {
+		ok, err := tb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		ids = append(ids, string(tb.Row().Bytes("id")))
+	}
+
+	return ids
+}
+
+func TestMergeTables(t *testing.T) {
+	parts := csvParts(t,
+		"id,name\n1,John\n4,Sue\n",
+		"id,name\n",
+		"id,name\n2,Pam\n3,Sam\n5,Bob\n",
+	)
+
+	tb, err := MergeTables(parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s1, s2, ok := jsonEqual([]string{"1", "2", "3", "4", "5"}, tableIDs(t, tb)); !ok {
+		t.Errorf("expected merged ids. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestConcatTablesSort(t *testing.T) {
+	parts := csvParts(t,
+		"id,name\n4,Sue\n1,John\n",
+		"id,name\n10,Bob\n2,Pam\n",
+	)
+
+	tb, err := ConcatTables(parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tb, err = Sort(tb, SortOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s1, s2, ok := jsonEqual([]string{"1", "10", "2", "4"}, tableIDs(t, tb)); !ok {
+		t.Errorf("expected sorted ids. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestMergeTablesSchema(t *testing.T) {
+	parts := csvParts(t,
+		"id,name\n1,John\n",
+		"id,full_name\n2,Pam\n",
+	)
+
+	if _, err := MergeTables(parts); err == nil {
+		t.Error("expected an error for parts with different columns")
+	}
+}
+
+func TestPartFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff-table-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, n := range []string{"part-0002.csv", "part-0001.csv", "_SUCCESS", ".part-0001.csv.crc"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, n), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "part-0001.csv"),
+		filepath.Join(dir, "part-0002.csv"),
+	}
+
+	paths, err := PartFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1, s2, ok := jsonEqual(expected, paths); !ok {
+		t.Errorf("unexpected directory files. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	paths, err = PartFiles(filepath.Join(dir, "part-*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1, s2, ok := jsonEqual(expected, paths); !ok {
+		t.Errorf("unexpected glob files. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	if _, err := PartFiles(filepath.Join(dir, "*.avro")); err == nil {
+		t.Error("expected an error for a glob without matches")
+	}
+}
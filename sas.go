@@ -0,0 +1,1012 @@
+package difftable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Magic number at the start of a SAS7BDAT file.
+var sasMagic = []byte{
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0xc2, 0xea, 0x81, 0x60,
+	0xb3, 0x14, 0x11, 0xcf, 0xbd, 0x92, 0x08, 0x00,
+	0x09, 0xc7, 0x31, 0x8c, 0x18, 0x1f, 0x10, 0x11,
+}
+
+// Dates are days and datetimes seconds since the SAS epoch.
+var sasEpoch = time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Page types.
+const (
+	sasPageMeta  = 0x0000
+	sasPageData  = 0x0100
+	sasPageMix   = 0x0200
+	sasPageAMD   = 0x0400
+	sasPageMeta2 = 0x4000
+)
+
+// Subheader signatures as the low 32 bits of the signature read in the byte
+// order of the file, which are the same for 32 and 64-bit files.
+const (
+	sasRowSize         = 0xf7f7f7f7
+	sasColumnSize      = 0xf6f6f6f6
+	sasSubheaderCounts = 0xfffffc00
+	sasColumnText      = 0xfffffffd
+	sasColumnName      = 0xffffffff
+	sasColumnAttrs     = 0xfffffffc
+	sasFormatAndLabel  = 0xfffffbfe
+	sasColumnList      = 0xfffffffe
+)
+
+// Subheader compression flags.
+const (
+	sasSubheaderTruncated  = 1
+	sasSubheaderCompressed = 4
+)
+
+// Compression literals found in the first column text subheader.
+const (
+	sasRLE = "SASYZCRL"
+	sasRDC = "SASYZCR2"
+)
+
+// Names of the encodings of text by the code in the header. Other
+// encodings are decoded as UTF-8 or Windows-1252.
+var sasEncodings = map[byte]string{
+	20: "utf-8",
+	29: "latin1",
+	62: "wlatin1",
+}
+
+// Formats of numeric columns holding dates, datetimes and times.
+var sasFormatKinds = make(map[string]string)
+
+func init() {
+	for _, f := range []string{
+		"DATE", "DAY", "DDMMYY", "DOWNAME", "JULDAY", "JULIAN", "MMDDYY", "MMYY",
+		"MMYYC", "MMYYD", "MMYYP", "MMYYS", "MMYYN", "MONNAME", "MONTH", "MONYY",
+		"QTR", "QTRR", "NENGO", "WEEKDATE", "WEEKDATX", "WEEKDAY", "WEEKV",
+		"WORDDATE", "WORDDATX", "YEAR", "YYMM", "YYMMC", "YYMMD", "YYMMP", "YYMMS",
+		"YYMMN", "YYMON", "YYMMDD", "YYQ", "YYQC", "YYQD", "YYQP", "YYQS", "YYQN",
+		"YYQR", "YYQRC", "YYQRD", "YYQRP", "YYQRS", "YYQRN", "YYMMDDP", "YYMMDDC",
+		"E8601DA", "YYMMDDN", "MMDDYYC", "MMDDYYS", "MMDDYYD", "YYMMDDS",
+		"B8601DA", "DDMMYYN", "YYMMDDD", "DDMMYYB", "DDMMYYP", "MMDDYYP",
+		"YYMMDDB", "MMDDYYN", "DDMMYYC", "DDMMYYD", "DDMMYYS", "MINGUO",
+	} {
+		sasFormatKinds[f] = "date"
+	}
+
+	for _, f := range []string{
+		"DATETIME", "DTWKDATX", "B8601DN", "B8601DT", "B8601DX", "B8601DZ",
+		"B8601LX", "E8601DN", "E8601DT", "E8601DX", "E8601DZ", "E8601LX",
+		"DATEAMPM", "DTDATE", "DTMONYY", "DTYEAR", "TOD", "MDYAMPM",
+	} {
+		sasFormatKinds[f] = "datetime"
+	}
+
+	for _, f := range []string{
+		"TIME", "TIMEAMPM", "HHMM", "HOUR", "MMSS", "E8601TM", "B8601TM",
+	} {
+		sasFormatKinds[f] = "time"
+	}
+}
+
+// sasFormatKind returns the kind of value of a numeric column with the
+// format, ignoring any width or decimals.
+func sasFormatKind(format string) string {
+	f := strings.ToUpper(strings.TrimRight(format, "0123456789."))
+	if k, ok := sasFormatKinds[f]; ok {
+		return k
+	}
+	return "number"
+}
+
+// sasDataRow is the location of a row stored as a subheader.
+type sasDataRow struct {
+	off, n     int
+	compressed bool
+}
+
+type sasColumn struct {
+	name   string
+	format string
+
+	// Kind is one of string, number, date, datetime or time.
+	kind string
+
+	// Offset and length of the value in a row.
+	offset int
+	length int
+}
+
+// sasReader reads the rows of a SAS7BDAT file page by page. The layout
+// follows the reverse engineered description of the format used by other
+// open source readers.
+type sasReader struct {
+	r      io.Reader
+	bo     binary.ByteOrder
+	decode func([]byte) string
+
+	// Lengths of integers and subheader pointers and the offset of the
+	// page header, which differ in 64-bit files.
+	intLen    int
+	ptrLen    int
+	pageBits  int
+	pageLen   int
+	compress  string
+	rowLength int
+	rowCount  int
+	mixRows   int
+	colCount  int
+
+	// Metadata read from the subheaders.
+	texts   [][]byte
+	names   []string
+	offsets []int
+	lengths []int
+	numeric []bool
+	formats []string
+
+	cols []*sasColumn
+
+	// Current page.
+	page      []byte
+	pageType  int
+	blocks    int
+	pointers  int
+	rowOnPage int
+	rowInFile int
+
+	// Rows stored as subheaders of meta pages.
+	dataRows []sasDataRow
+
+	// Buffer of a decompressed row.
+	buf []byte
+}
+
+func newSASReader(r io.Reader) (*sasReader, error) {
+	sr := &sasReader{r: r}
+
+	// The fields needed are in the first 288 bytes of the header.
+	h := make([]byte, 288)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, fmt.Errorf("header: %s", err)
+	}
+
+	if !bytes.Equal(h[:len(sasMagic)], sasMagic) {
+		return nil, errors.New("not a SAS7BDAT file")
+	}
+
+	var align1 int
+	if h[35] == '3' {
+		align1 = 4
+	}
+
+	sr.intLen = 4
+	sr.ptrLen = 12
+	sr.pageBits = 16
+
+	if h[32] == '3' {
+		sr.intLen = 8
+		sr.ptrLen = 24
+		sr.pageBits = 32
+	}
+
+	if h[37] == 0x01 {
+		sr.bo = binary.LittleEndian
+	} else {
+		sr.bo = binary.BigEndian
+	}
+
+	sr.decode = textDecoder(sasEncodings[h[70]])
+
+	headerLen := int(sr.bo.Uint32(h[196+align1:]))
+	sr.pageLen = int(sr.bo.Uint32(h[200+align1:]))
+
+	if headerLen < len(h) || sr.pageLen <= sr.pageBits+8 {
+		return nil, fmt.Errorf("invalid header length %d or page length %d", headerLen, sr.pageLen)
+	}
+
+	if _, err := io.CopyN(io.Discard, r, int64(headerLen-len(h))); err != nil {
+		return nil, fmt.Errorf("header: %s", err)
+	}
+
+	sr.page = make([]byte, sr.pageLen)
+
+	if err := sr.readMetadata(); err != nil {
+		return nil, err
+	}
+
+	return sr, nil
+}
+
+func (r *sasReader) uint(b []byte, off, n int) int {
+	switch n {
+	case 1:
+		return int(b[off])
+	case 2:
+		return int(r.bo.Uint16(b[off:]))
+	case 4:
+		return int(r.bo.Uint32(b[off:]))
+	}
+	return int(r.bo.Uint64(b[off:]))
+}
+
+// readPage reads the next page. False is returned at the end of the file.
+func (r *sasReader) readPage() (bool, error) {
+	r.rowOnPage = 0
+	r.dataRows = nil
+
+	if _, err := io.ReadFull(r.r, r.page); err != nil {
+		if err == io.EOF {
+			r.pageType = -1
+			return false, nil
+		}
+		return false, fmt.Errorf("page: %s", err)
+	}
+
+	r.pageType = r.uint(r.page, r.pageBits, 2) & 0xff00
+	r.blocks = r.uint(r.page, r.pageBits+2, 2)
+	r.pointers = r.uint(r.page, r.pageBits+4, 2)
+
+	if r.pageBits+8+r.pointers*r.ptrLen > r.pageLen {
+		return false, fmt.Errorf("page has too many subheaders: %d", r.pointers)
+	}
+
+	return true, nil
+}
+
+func (r *sasReader) isMeta() bool {
+	return r.pageType == sasPageMeta || r.pageType == sasPageMeta2
+}
+
+// readMetadata reads pages until the first page with rows.
+func (r *sasReader) readMetadata() error {
+	for {
+		ok, err := r.readPage()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		if r.isMeta() || r.pageType == sasPageAMD || r.pageType == sasPageMix {
+			if err := r.readSubheaders(true); err != nil {
+				return err
+			}
+		}
+
+		if r.pageType == sasPageData || r.pageType == sasPageMix || len(r.dataRows) > 0 {
+			break
+		}
+	}
+
+	return r.columns()
+}
+
+// readSubheaders reads the subheaders of the current page. Rows stored as
+// subheaders are collected and metadata is read unless the metadata is
+// already complete.
+func (r *sasReader) readSubheaders(metadata bool) error {
+	for i := 0; i < r.pointers; i++ {
+		p := r.pageBits + 8 + i*r.ptrLen
+
+		off := r.uint(r.page, p, r.intLen)
+		n := r.uint(r.page, p+r.intLen, r.intLen)
+		comp := r.page[p+2*r.intLen]
+		typ := r.page[p+2*r.intLen+1]
+
+		if n == 0 || comp == sasSubheaderTruncated {
+			continue
+		}
+
+		if off < 0 || n < 0 || off+n > r.pageLen {
+			return fmt.Errorf("subheader %d out of bounds", i)
+		}
+
+		if comp == sasSubheaderCompressed {
+			r.dataRows = append(r.dataRows, sasDataRow{off, n, true})
+			continue
+		}
+
+		if n < r.intLen {
+			return fmt.Errorf("subheader %d too short", i)
+		}
+
+		sig := uint32(r.uint(r.page, off, r.intLen))
+		sh := r.page[off : off+n]
+
+		if !metadata {
+			switch sig {
+			case sasRowSize, sasColumnSize, sasSubheaderCounts, sasColumnText,
+				sasColumnName, sasColumnAttrs, sasFormatAndLabel, sasColumnList:
+				continue
+			}
+		}
+
+		var err error
+
+		switch sig {
+		case sasRowSize:
+			err = r.rowSize(sh)
+		case sasColumnSize:
+			err = r.columnSize(sh)
+		case sasColumnText:
+			err = r.columnText(sh)
+		case sasColumnName:
+			err = r.columnNames(sh)
+		case sasColumnAttrs:
+			err = r.columnAttrs(sh)
+		case sasFormatAndLabel:
+			err = r.formatAndLabel(sh)
+		case sasSubheaderCounts, sasColumnList:
+		default:
+			// Uncompressed rows of compressed files.
+			if r.compress != "" && typ == 1 {
+				r.dataRows = append(r.dataRows, sasDataRow{off, n, false})
+				continue
+			}
+			err = fmt.Errorf("unknown subheader signature %x", sig)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sasNeed returns an error if the subheader is shorter than n bytes.
+func sasNeed(sh []byte, n int, name string) error {
+	if len(sh) < n {
+		return fmt.Errorf("%s subheader too short", name)
+	}
+	return nil
+}
+
+func (r *sasReader) rowSize(sh []byte) error {
+	if err := sasNeed(sh, 16*r.intLen, "row size"); err != nil {
+		return err
+	}
+
+	r.rowLength = r.uint(sh, 5*r.intLen, r.intLen)
+	r.rowCount = r.uint(sh, 6*r.intLen, r.intLen)
+	r.mixRows = r.uint(sh, 15*r.intLen, r.intLen)
+
+	return nil
+}
+
+func (r *sasReader) columnSize(sh []byte) error {
+	if err := sasNeed(sh, 2*r.intLen, "column size"); err != nil {
+		return err
+	}
+
+	r.colCount = r.uint(sh, r.intLen, r.intLen)
+
+	return nil
+}
+
+func (r *sasReader) columnText(sh []byte) error {
+	if err := sasNeed(sh, r.intLen+2, "column text"); err != nil {
+		return err
+	}
+
+	n := r.uint(sh, r.intLen, 2)
+	if r.intLen+n > len(sh) {
+		n = len(sh) - r.intLen
+	}
+
+	// Offsets of text are relative to the end of the signature.
+	text := append([]byte(nil), sh[r.intLen:r.intLen+n]...)
+	r.texts = append(r.texts, text)
+
+	if len(r.texts) == 1 {
+		switch {
+		case bytes.Contains(text, []byte(sasRLE)):
+			r.compress = sasRLE
+		case bytes.Contains(text, []byte(sasRDC)):
+			r.compress = sasRDC
+		}
+	}
+
+	return nil
+}
+
+// text returns the text at the offset of a column text subheader.
+func (r *sasReader) text(idx, off, n int) string {
+	if len(r.texts) == 0 {
+		return ""
+	}
+	if idx >= len(r.texts) {
+		idx = len(r.texts) - 1
+	}
+
+	t := r.texts[idx]
+	if off+n > len(t) {
+		return ""
+	}
+
+	return r.decode(bytes.TrimRight(t[off:off+n], "\x00 "))
+}
+
+func (r *sasReader) columnNames(sh []byte) error {
+	count := (len(sh) - 2*r.intLen - 12) / 8
+
+	for i := 0; i < count; i++ {
+		p := r.intLen + 8*(i+1)
+
+		r.names = append(r.names, r.text(
+			r.uint(sh, p, 2),
+			r.uint(sh, p+2, 2),
+			r.uint(sh, p+4, 2),
+		))
+	}
+
+	return nil
+}
+
+func (r *sasReader) columnAttrs(sh []byte) error {
+	size := r.intLen + 8
+	count := (len(sh) - 2*r.intLen - 12) / size
+
+	for i := 0; i < count; i++ {
+		r.offsets = append(r.offsets, r.uint(sh, r.intLen+8+i*size, r.intLen))
+		r.lengths = append(r.lengths, r.uint(sh, 2*r.intLen+8+i*size, 4))
+		r.numeric = append(r.numeric, sh[2*r.intLen+14+i*size] == 1)
+	}
+
+	return nil
+}
+
+// formatAndLabel reads the format of a column. Labels are ignored.
+func (r *sasReader) formatAndLabel(sh []byte) error {
+	p := 3 * r.intLen
+	if err := sasNeed(sh, p+34, "format and label"); err != nil {
+		return err
+	}
+
+	r.formats = append(r.formats, r.text(
+		r.uint(sh, p+22, 2),
+		r.uint(sh, p+24, 2),
+		r.uint(sh, p+26, 2),
+	))
+
+	return nil
+}
+
+// columns combines the metadata of the columns.
+func (r *sasReader) columns() error {
+	n := r.colCount
+	if n == 0 {
+		n = len(r.names)
+	}
+
+	if len(r.names) < n || len(r.offsets) < n {
+		return fmt.Errorf("expected %d columns, got %d names and %d attributes", n, len(r.names), len(r.offsets))
+	}
+
+	if r.rowLength <= 0 {
+		return errors.New("missing row size")
+	}
+
+	for i := 0; i < n; i++ {
+		c := &sasColumn{
+			name:   r.names[i],
+			offset: r.offsets[i],
+			length: r.lengths[i],
+			kind:   "string",
+		}
+
+		if i < len(r.formats) {
+			c.format = r.formats[i]
+		}
+
+		if r.numeric[i] {
+			if c.length < 1 || c.length > 8 {
+				return fmt.Errorf("column `%s`: invalid numeric length %d", c.name, c.length)
+			}
+			c.kind = sasFormatKind(c.format)
+		}
+
+		if c.offset+c.length > r.rowLength {
+			return fmt.Errorf("column `%s` exceeds the row length", c.name)
+		}
+
+		r.cols = append(r.cols, c)
+	}
+
+	return nil
+}
+
+// next returns the next row. The row is valid until next is called again.
+func (r *sasReader) next() ([]byte, error) {
+	for r.rowInFile < r.rowCount {
+		var (
+			off, n     int
+			compressed bool
+		)
+
+		switch {
+		case r.pageType < 0:
+			return nil, fmt.Errorf("expected %d rows, got %d", r.rowCount, r.rowInFile)
+
+		case r.isMeta():
+			if r.rowOnPage >= len(r.dataRows) {
+				if err := r.nextPage(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			d := r.dataRows[r.rowOnPage]
+			off, n, compressed = d.off, d.n, d.compressed
+
+		case r.pageType == sasPageMix:
+			rows := r.mixRows
+			if r.rowCount < rows {
+				rows = r.rowCount
+			}
+			if r.rowOnPage >= rows {
+				if err := r.nextPage(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			// Rows follow the subheader pointers aligned to 8 bytes.
+			off = r.pageBits + 8 + r.pointers*r.ptrLen
+			off += off % 8
+			off += r.rowOnPage * r.rowLength
+			n = r.rowLength
+
+		case r.pageType == sasPageData:
+			if r.rowOnPage >= r.blocks {
+				if err := r.nextPage(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			off = r.pageBits + 8 + r.rowOnPage*r.rowLength
+			n = r.rowLength
+
+		default:
+			if err := r.nextPage(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		r.rowOnPage++
+		r.rowInFile++
+
+		if off+n > r.pageLen {
+			return nil, fmt.Errorf("row %d out of bounds", r.rowInFile)
+		}
+
+		return r.row(r.page[off:off+n], compressed)
+	}
+
+	return nil, nil
+}
+
+// nextPage reads the next page and the rows stored as its subheaders.
+func (r *sasReader) nextPage() error {
+	ok, err := r.readPage()
+	if err != nil || !ok {
+		return err
+	}
+
+	if r.isMeta() {
+		return r.readSubheaders(false)
+	}
+
+	return nil
+}
+
+// row decompresses a row if necessary.
+func (r *sasReader) row(b []byte, compressed bool) ([]byte, error) {
+	if !compressed {
+		if len(b) < r.rowLength {
+			return nil, fmt.Errorf("row %d is too short", r.rowInFile)
+		}
+		return b[:r.rowLength], nil
+	}
+
+	if r.compress == "" {
+		return nil, fmt.Errorf("row %d is compressed with an unknown method", r.rowInFile)
+	}
+
+	if r.buf == nil {
+		r.buf = make([]byte, r.rowLength)
+	}
+
+	var err error
+
+	if r.compress == sasRLE {
+		err = sasRLEDecompress(r.buf, b)
+	} else {
+		err = sasRDCDecompress(r.buf, b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("row %d: %s", r.rowInFile, err)
+	}
+
+	return r.buf, nil
+}
+
+var errSASCompression = errors.New("corrupt compressed row")
+
+// sasRLEDecompress decompresses a row compressed with run-length encoding
+// into the buffer, which must be the length of the row.
+func sasRLEDecompress(out, in []byte) error {
+	var ipos, rpos int
+
+	// fill writes n bytes of the value.
+	fill := func(n int, b byte) error {
+		if rpos+n > len(out) {
+			return errSASCompression
+		}
+		for i := 0; i < n; i++ {
+			out[rpos+i] = b
+		}
+		rpos += n
+		return nil
+	}
+
+	// copyIn copies n bytes of the input.
+	copyIn := func(n int) error {
+		if rpos+n > len(out) || ipos+n > len(in) {
+			return errSASCompression
+		}
+		copy(out[rpos:], in[ipos:ipos+n])
+		rpos += n
+		ipos += n
+		return nil
+	}
+
+	// arg reads the byte following a control byte.
+	arg := func() (int, error) {
+		if ipos >= len(in) {
+			return 0, errSASCompression
+		}
+		ipos++
+		return int(in[ipos-1]), nil
+	}
+
+	for ipos < len(in) {
+		ctrl := in[ipos] & 0xf0
+		low := int(in[ipos] & 0x0f)
+		ipos++
+
+		var err error
+
+		switch ctrl {
+		case 0x00:
+			var n int
+			if n, err = arg(); err == nil {
+				err = copyIn(n + 64 + low*256)
+			}
+		case 0x40:
+			var n, b int
+			if n, err = arg(); err == nil {
+				if b, err = arg(); err == nil {
+					err = fill(n+18+low*256, byte(b))
+				}
+			}
+		case 0x60:
+			var n int
+			if n, err = arg(); err == nil {
+				err = fill(n+17+low*256, ' ')
+			}
+		case 0x70:
+			var n int
+			if n, err = arg(); err == nil {
+				err = fill(n+17+low*256, 0)
+			}
+		case 0x80:
+			err = copyIn(low + 1)
+		case 0x90:
+			err = copyIn(low + 17)
+		case 0xa0:
+			err = copyIn(low + 33)
+		case 0xb0:
+			err = copyIn(low + 49)
+		case 0xc0:
+			var b int
+			if b, err = arg(); err == nil {
+				err = fill(low+3, byte(b))
+			}
+		case 0xd0:
+			err = fill(low+2, '@')
+		case 0xe0:
+			err = fill(low+2, ' ')
+		case 0xf0:
+			err = fill(low+2, 0)
+		default:
+			err = fmt.Errorf("unknown control byte %x", ctrl)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if rpos != len(out) {
+		return errSASCompression
+	}
+
+	return nil
+}
+
+// sasRDCDecompress decompresses a row compressed with Ross data compression
+// into the buffer, which must be the length of the row.
+func sasRDCDecompress(out, in []byte) error {
+	var (
+		ipos, rpos int
+		bits, mask uint16
+	)
+
+	for ipos < len(in) {
+		mask >>= 1
+		if mask == 0 {
+			if ipos+1 >= len(in) {
+				return errSASCompression
+			}
+			bits = uint16(in[ipos])<<8 | uint16(in[ipos+1])
+			ipos += 2
+			mask = 0x8000
+		}
+
+		if ipos >= len(in) {
+			return errSASCompression
+		}
+
+		// Literal byte.
+		if bits&mask == 0 {
+			if rpos >= len(out) {
+				return errSASCompression
+			}
+			out[rpos] = in[ipos]
+			rpos++
+			ipos++
+			continue
+		}
+
+		cmd := int(in[ipos]>>4) & 0x0f
+		cnt := int(in[ipos] & 0x0f)
+		ipos++
+
+		var (
+			run    bool
+			b      byte
+			ofs, n int
+		)
+
+		// missing returns true if fewer than k bytes of input are left.
+		missing := func(k int) bool {
+			return ipos+k > len(in)
+		}
+
+		switch cmd {
+		case 0:
+			// Short run.
+			if missing(1) {
+				return errSASCompression
+			}
+			run, n, b = true, cnt+3, in[ipos]
+			ipos++
+		case 1:
+			// Long run.
+			if missing(2) {
+				return errSASCompression
+			}
+			run, n, b = true, cnt+int(in[ipos])<<4+19, in[ipos+1]
+			ipos += 2
+		case 2:
+			// Long pattern.
+			if missing(2) {
+				return errSASCompression
+			}
+			ofs = cnt + 3 + int(in[ipos])<<4
+			n = int(in[ipos+1]) + 16
+			ipos += 2
+		default:
+			// Short pattern.
+			if missing(1) {
+				return errSASCompression
+			}
+			ofs = cnt + 3 + int(in[ipos])<<4
+			n = cmd
+			ipos++
+		}
+
+		if rpos+n > len(out) || (!run && ofs > rpos) {
+			return errSASCompression
+		}
+
+		for k := 0; k < n; k++ {
+			if run {
+				out[rpos+k] = b
+			} else {
+				out[rpos+k] = out[rpos-ofs+k]
+			}
+		}
+		rpos += n
+	}
+
+	if rpos != len(out) {
+		return errSASCompression
+	}
+
+	return nil
+}
+
+// value returns the value of the column in the row. Missing numeric values
+// are nil.
+func (c *sasColumn) value(r *sasReader, row []byte) interface{} {
+	b := row[c.offset : c.offset+c.length]
+
+	if c.kind == "string" {
+		return r.decode(bytes.TrimRight(b, "\x00 "))
+	}
+
+	// Numbers may be truncated to their most significant bytes.
+	var buf [8]byte
+	if r.bo == binary.LittleEndian {
+		copy(buf[8-len(b):], b)
+	} else {
+		copy(buf[:], b)
+	}
+
+	f := math.Float64frombits(r.bo.Uint64(buf[:]))
+
+	// Missing values, including special missing values, are NaN.
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+
+	switch c.kind {
+	case "date":
+		return sasEpoch.AddDate(0, 0, int(math.Floor(f)))
+	case "datetime":
+		return sasEpoch.Add(time.Duration(math.Round(f*1e6)) * time.Microsecond)
+	case "time":
+		return time.Duration(math.Round(f*1e6)) * time.Microsecond
+	}
+
+	return f
+}
+
+// SASTable returns a table of the rows of a SAS7BDAT dataset. Files are read
+// page by page, so they can be read from stdin or decompressed without
+// random access, and rows compressed by SAS are supported.
+//
+// Character columns are typed string and numeric columns number unless
+// their format is a date, datetime or time format, such as DATE9. or
+// DATETIME20., in which case they are typed date, datetime or time. Dates
+// and datetimes are returned as time.Time values and times as
+// time.Duration values. Missing values are null.
+func SASTable(r io.Reader, key []string, renames map[string]string) (Table, error) {
+	sr, err := newSASReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, k := range key {
+		if n, ok := renames[k]; ok {
+			key[i] = n
+		}
+	}
+
+	t := &sasTable{
+		r:       sr,
+		key:     key,
+		cols:    make(map[string]string, len(sr.cols)),
+		colIdxs: make(map[string]int, len(sr.cols)),
+	}
+
+	for i, c := range sr.cols {
+		name := c.name
+		if n, ok := renames[name]; ok {
+			name = n
+		}
+
+		if _, ok := t.colIdxs[name]; ok {
+			return nil, fmt.Errorf("duplicate column `%s`", name)
+		}
+
+		t.cols[name] = c.kind
+		t.colIdxs[name] = i
+
+		col := &Column{
+			Name:        name,
+			Type:        c.kind,
+			Nullable:    c.kind != "string",
+			HasNullable: true,
+		}
+		if c.kind == "string" {
+			col.Length = int64(c.length)
+			col.HasLength = true
+		}
+
+		t.colInfo = append(t.colInfo, col)
+	}
+
+	return t, nil
+}
+
+type sasTable struct {
+	r       *sasReader
+	key     []string
+	cols    map[string]string
+	colInfo []*Column
+	colIdxs map[string]int
+
+	row []byte
+}
+
+func (t *sasTable) Key() []string {
+	return t.key
+}
+
+func (t *sasTable) Cols() map[string]string {
+	return t.cols
+}
+
+// Columns returns the columns in dataset order. Numeric columns are
+// nullable and character columns have their length in bytes.
+func (t *sasTable) Columns() []*Column {
+	return t.colInfo
+}
+
+func (t *sasTable) Row() Row {
+	return &sasRow{
+		r:       t.r,
+		colIdxs: t.colIdxs,
+		row:     t.row,
+	}
+}
+
+func (t *sasTable) Next() (bool, error) {
+	row, err := t.r.next()
+	if err != nil {
+		return false, err
+	}
+
+	t.row = row
+
+	return row != nil, nil
+}
+
+type sasRow struct {
+	r       *sasReader
+	colIdxs map[string]int
+	row     []byte
+}
+
+func (r *sasRow) Bytes(col string) []byte {
+	i, ok := r.colIdxs[col]
+	if !ok {
+		return nil
+	}
+
+	c := r.r.cols[i]
+
+	return datasetBytes(c.kind, c.value(r.r, r.row))
+}
+
+func (r *sasRow) Value(col string) interface{} {
+	i, ok := r.colIdxs[col]
+	if !ok {
+		return nil
+	}
+
+	return r.r.cols[i].value(r.r, r.row)
+}
@@ -0,0 +1,376 @@
+package difftable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+type sasTestColumn struct {
+	name   string
+	format string
+
+	// Length of character columns. Numeric columns are 8 bytes.
+	length int
+}
+
+// sasSubheader is a subheader of a meta page with its compression and type
+// flags.
+type sasSubheader struct {
+	data []byte
+	comp byte
+	typ  byte
+}
+
+// writeSAS writes a 64-bit little-endian SAS7BDAT file with a meta page
+// followed by a data page or, if compressed, with the rows stored as RLE
+// compressed subheaders of the meta page.
+func writeSAS(t *testing.T, cols []sasTestColumn, rows [][]interface{}, compress bool) []byte {
+	const (
+		headerLen = 1024
+		pageLen   = 4096
+	)
+
+	le := binary.LittleEndian
+
+	header := make([]byte, headerLen)
+	copy(header, sasMagic)
+	header[32] = '3'
+	header[37] = 0x01
+	header[39] = '1'
+	header[70] = 20
+	le.PutUint32(header[196:], headerLen)
+	le.PutUint32(header[200:], pageLen)
+
+	// Text of the column text subheader.
+	text := make([]byte, 8)
+	if compress {
+		text = append(text, sasRLE...)
+	}
+
+	addText := func(s string) (int, int) {
+		off := len(text)
+		text = append(text, s...)
+		// Keep offsets aligned like SAS does.
+		for len(text)%4 != 0 {
+			text = append(text, ' ')
+		}
+		return off, len(s)
+	}
+
+	type textRef struct{ off, n int }
+
+	var (
+		names, formats []textRef
+		offsets        []int
+		rowLength      int
+	)
+
+	for _, c := range cols {
+		o, n := addText(c.name)
+		names = append(names, textRef{o, n})
+
+		var f textRef
+		if c.format != "" {
+			f.off, f.n = addText(c.format)
+		}
+		formats = append(formats, f)
+
+		offsets = append(offsets, rowLength)
+		if c.length == 0 {
+			rowLength += 8
+		} else {
+			rowLength += c.length
+		}
+	}
+
+	le.PutUint16(text, uint16(len(text)))
+
+	sig := func(s uint32, n int) []byte {
+		b := make([]byte, n)
+		le.PutUint32(b, s)
+		if s == sasColumnText || s == sasColumnName || s == sasColumnAttrs || s == sasFormatAndLabel {
+			for i := 4; i < 8; i++ {
+				b[i] = 0xff
+			}
+		}
+		return b
+	}
+
+	var subheaders []sasSubheader
+
+	rowSize := sig(sasRowSize, 808)
+	le.PutUint64(rowSize[40:], uint64(rowLength))
+	le.PutUint64(rowSize[48:], uint64(len(rows)))
+	le.PutUint64(rowSize[120:], uint64(len(rows)))
+	subheaders = append(subheaders, sasSubheader{data: rowSize})
+
+	colSize := sig(sasColumnSize, 24)
+	le.PutUint64(colSize[8:], uint64(len(cols)))
+	subheaders = append(subheaders, sasSubheader{data: colSize})
+
+	colText := append(sig(sasColumnText, 8), text...)
+	subheaders = append(subheaders, sasSubheader{data: colText})
+
+	colNames := sig(sasColumnName, 28+8*len(cols))
+	for i, n := range names {
+		p := 16 + 8*i
+		le.PutUint16(colNames[p+2:], uint16(n.off))
+		le.PutUint16(colNames[p+4:], uint16(n.n))
+	}
+	subheaders = append(subheaders, sasSubheader{data: colNames})
+
+	colAttrs := sig(sasColumnAttrs, 28+16*len(cols))
+	for i, c := range cols {
+		p := 16 + 16*i
+		le.PutUint64(colAttrs[p:], uint64(offsets[i]))
+		if c.length == 0 {
+			le.PutUint32(colAttrs[p+8:], 8)
+			colAttrs[p+14] = 1
+		} else {
+			le.PutUint32(colAttrs[p+8:], uint32(c.length))
+			colAttrs[p+14] = 2
+		}
+	}
+	subheaders = append(subheaders, sasSubheader{data: colAttrs})
+
+	for _, f := range formats {
+		fl := sig(sasFormatAndLabel, 64)
+		le.PutUint16(fl[24+24:], uint16(f.off))
+		le.PutUint16(fl[24+26:], uint16(f.n))
+		subheaders = append(subheaders, sasSubheader{data: fl})
+	}
+
+	// Encode the rows.
+	var data [][]byte
+	for _, r := range rows {
+		b := make([]byte, rowLength)
+		for i, v := range r {
+			switch v := v.(type) {
+			case nil:
+				le.PutUint64(b[offsets[i]:], math.Float64bits(math.NaN()))
+			case float64:
+				le.PutUint64(b[offsets[i]:], math.Float64bits(v))
+			case string:
+				copy(b[offsets[i]:offsets[i]+cols[i].length], v+string(bytes.Repeat([]byte(" "), cols[i].length-len(v))))
+			}
+		}
+		data = append(data, b)
+	}
+
+	if compress {
+		for _, b := range data {
+			// Copy literals 16 bytes at a time.
+			var c []byte
+			for len(b) > 0 {
+				n := len(b)
+				if n > 16 {
+					n = 16
+				}
+				c = append(c, 0x80|byte(n-1))
+				c = append(c, b[:n]...)
+				b = b[n:]
+			}
+			subheaders = append(subheaders, sasSubheader{data: c, comp: sasSubheaderCompressed, typ: 1})
+		}
+	}
+
+	meta := make([]byte, pageLen)
+	le.PutUint16(meta[32:], sasPageMeta)
+	le.PutUint16(meta[36:], uint16(len(subheaders)))
+
+	// Subheaders are stored from the end of the page.
+	end := pageLen
+	for i, sh := range subheaders {
+		end -= len(sh.data)
+		copy(meta[end:], sh.data)
+
+		p := 40 + 24*i
+		le.PutUint64(meta[p:], uint64(end))
+		le.PutUint64(meta[p+8:], uint64(len(sh.data)))
+		meta[p+16] = sh.comp
+		meta[p+17] = sh.typ
+	}
+
+	out := append(header, meta...)
+
+	if !compress {
+		page := make([]byte, pageLen)
+		le.PutUint16(page[32:], sasPageData)
+		le.PutUint16(page[34:], uint16(len(data)))
+		for i, b := range data {
+			copy(page[40+i*rowLength:], b)
+		}
+		out = append(out, page...)
+	}
+
+	return out
+}
+
+func sasDays(t time.Time) float64 {
+	return t.Sub(sasEpoch).Hours() / 24
+}
+
+func TestSASTable(t *testing.T) {
+	cols := []sasTestColumn{
+		{name: "id"},
+		{name: "name", length: 6},
+		{name: "born", format: "DATE9"},
+		{name: "seen", format: "DATETIME20"},
+		{name: "score", format: "BEST12"},
+	}
+
+	born := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	seen := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	rows := [][]interface{}{
+		{1.0, "José", sasDays(born), seen.Sub(sasEpoch).Seconds(), 1.5},
+		{2.0, "", nil, nil, nil},
+		{10.0, "Sam", sasDays(born) + 1, seen.Sub(sasEpoch).Seconds(), -3.0},
+	}
+
+	for _, compress := range []bool{false, true} {
+		data := writeSAS(t, cols, rows, compress)
+
+		tb, err := SASTable(bytes.NewReader(data), []string{"id"}, map[string]string{"name": "full_name"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{
+			"id":        "number",
+			"full_name": "string",
+			"born":      "date",
+			"seen":      "datetime",
+			"score":     "number",
+		}
+
+		if s1, s2, ok := jsonEqual(expected, tb.Cols()); !ok {
+			t.Errorf("column types don't match. expected:\n%s\ngot:\n%s", s1, s2)
+		}
+
+		var out []map[string]interface{}
+		for {
+			ok, err := tb.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+
+			r := tb.Row()
+			row := make(map[string]interface{})
+			for c := range expected {
+				if b := r.Bytes(c); b != nil {
+					row[c] = string(b)
+				} else {
+					row[c] = nil
+				}
+			}
+			out = append(out, row)
+		}
+
+		expectedRows := []map[string]interface{}{
+			{"id": "1", "full_name": "José", "born": "2018-01-02", "seen": "2019-03-04 05:06:07", "score": "1.5"},
+			{"id": "2", "full_name": "", "born": nil, "seen": nil, "score": nil},
+			{"id": "10", "full_name": "Sam", "born": "2018-01-03", "seen": "2019-03-04 05:06:07", "score": "-3"},
+		}
+
+		if s1, s2, ok := jsonEqual(expectedRows, out); !ok {
+			t.Errorf("unexpected rows (compressed %v). expected:\n%s\ngot:\n%s", compress, s1, s2)
+		}
+	}
+}
+
+func TestSASTableValues(t *testing.T) {
+	data := writeSAS(t, []sasTestColumn{
+		{name: "id"},
+		{name: "born", format: "MMDDYY10."},
+		{name: "at", format: "TIME8"},
+	}, [][]interface{}{
+		{1.0, 0.0, 3723.5},
+	}, false)
+
+	tb, err := SASTable(bytes.NewReader(data), []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := tb.Next(); !ok || err != nil {
+		t.Fatalf("expected a row, got %v", err)
+	}
+
+	r := tb.Row()
+
+	if v := r.Value("id"); v != 1.0 {
+		t.Errorf("expected id 1, got %#v", v)
+	}
+	if v, ok := r.Value("born").(time.Time); !ok || !v.Equal(sasEpoch) {
+		t.Errorf("expected born 1960-01-01, got %#v", r.Value("born"))
+	}
+	if v := r.Value("at"); v != time.Hour+2*time.Minute+3500*time.Millisecond {
+		t.Errorf("expected at 01:02:03.5, got %#v", v)
+	}
+	if b := string(r.Bytes("at")); b != "01:02:03.5" {
+		t.Errorf("expected at 01:02:03.5, got %s", b)
+	}
+}
+
+func TestSASTableInvalid(t *testing.T) {
+	if _, err := SASTable(bytes.NewReader(make([]byte, 1024)), nil, nil); err == nil {
+		t.Error("expected an error for a file without the magic number")
+	}
+}
+
+func TestSASRLEDecompress(t *testing.T) {
+	in := []byte{
+		0x82, 'a', 'b', 'c', // copy 3 bytes
+		0xc1, 'x', // 4 x's
+		0xe0,       // 2 spaces
+		0xf1,       // 3 zeros
+		0xd0,       // 2 @'s
+		0x60, 0x00, // 17 spaces
+	}
+
+	out := make([]byte, 31)
+	if err := sasRLEDecompress(out, in); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "abcxxxx  \x00\x00\x00@@" + string(bytes.Repeat([]byte(" "), 17))
+	if string(out) != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	if err := sasRLEDecompress(make([]byte, 30), in); err == nil {
+		t.Error("expected an error for a short buffer")
+	}
+}
+
+func TestSASRDCDecompress(t *testing.T) {
+	in := []byte{
+		0x18, 0x00, // control bits: literal, literal, literal, run, pattern
+		'a', 'b', 'c',
+		0x02, 'z', // 5 z's
+		0x35, 0x00, // copy 3 bytes from 8 back
+	}
+
+	out := make([]byte, 11)
+	if err := sasRDCDecompress(out, in); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "abczzzzzabc" {
+		t.Errorf("expected abczzzzzabc, got %q", out)
+	}
+}
+
+func TestSASTableFixtures(t *testing.T) {
+	testFixtures(t, "sas/*.sas7bdat", func(f *os.File) (Table, error) {
+		return SASTable(f, nil, nil)
+	})
+}
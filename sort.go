@@ -0,0 +1,543 @@
+package difftable
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultSortMemory is the default approximate number of bytes of row data
+// held in memory while sorting before a sorted run is spilled to disk.
+const DefaultSortMemory = 64 << 20
+
+// SortOptions are options for sorting a table whose rows are not ordered
+// by the key.
+type SortOptions struct {
+	// MemoryLimit is the approximate number of bytes of row data held in
+	// memory before a sorted run is written to disk. Defaults to
+	// DefaultSortMemory.
+	MemoryLimit int64
+
+	// TempDir is the directory sorted runs are written to. Defaults to
+	// the system temporary directory.
+	TempDir string
+}
+
+// sortRecord is a materialized row. Canonical holds the canonical
+// encoding of the values if the row is a CanonicalRow.
+type sortRecord struct {
+	Bytes     [][]byte
+	Values    []interface{}
+	Canonical [][]byte
+}
+
+// size approximates the memory used by the record.
+func (r *sortRecord) size() int64 {
+	n := int64(64)
+	for i, b := range r.Bytes {
+		n += int64(len(b)) + 24
+		n += valueSize(r.Values[i])
+	}
+	for _, b := range r.Canonical {
+		n += int64(len(b)) + 24
+	}
+	return n
+}
+
+func valueSize(v interface{}) int64 {
+	switch x := v.(type) {
+	case string:
+		return int64(len(x)) + 32
+	case []byte:
+		return int64(len(x)) + 40
+	}
+	return 16
+}
+
+// spillRecord is the encoded form of a sortRecord. Gob does not
+// distinguish nil and empty byte slices so nil values are flagged.
+type spillRecord struct {
+	Bytes     [][]byte
+	Nil       []bool
+	Values    []interface{}
+	Canonical [][]byte
+}
+
+// sortRow implements Row for a materialized row.
+type sortRow struct {
+	colIdxs map[string]int
+	rec     *sortRecord
+}
+
+func (r *sortRow) Bytes(col string) []byte {
+	i, ok := r.colIdxs[col]
+	if !ok {
+		return nil
+	}
+
+	return r.rec.Bytes[i]
+}
+
+func (r *sortRow) Value(col string) interface{} {
+	i, ok := r.colIdxs[col]
+	if !ok {
+		return nil
+	}
+
+	return r.rec.Values[i]
+}
+
+// canonicalSortRow is a sortRow of a CanonicalRow.
+type canonicalSortRow struct {
+	sortRow
+}
+
+func (r *canonicalSortRow) CanonicalBytes(col string) []byte {
+	i, ok := r.colIdxs[col]
+	if !ok {
+		return nil
+	}
+
+	return r.rec.Canonical[i]
+}
+
+// newSortRow returns the row of a record, which is a CanonicalRow if the
+// record was materialized from one.
+func newSortRow(colIdxs map[string]int, rec *sortRecord) Row {
+	if rec != nil && rec.Canonical != nil {
+		return &canonicalSortRow{sortRow{colIdxs: colIdxs, rec: rec}}
+	}
+	return &sortRow{colIdxs: colIdxs, rec: rec}
+}
+
+// sorter accumulates rows and orders them by key, spilling sorted runs to
+// disk when the memory limit is exceeded.
+type sorter struct {
+	opts   SortOptions
+	keyIdx []int
+	cmps   []Comparator
+
+	recs []*sortRecord
+	size int64
+	runs []*os.File
+}
+
+func (s *sorter) compare(r1, r2 *sortRecord) int {
+	for i, x := range s.keyIdx {
+		if p := s.cmps[i](r1.Bytes[x], r2.Bytes[x]); p != 0 {
+			return p
+		}
+	}
+	return 0
+}
+
+func (s *sorter) sortRecs() {
+	sort.SliceStable(s.recs, func(i, j int) bool {
+		return s.compare(s.recs[i], s.recs[j]) < 0
+	})
+}
+
+func (s *sorter) add(rec *sortRecord) error {
+	s.recs = append(s.recs, rec)
+	s.size += rec.size()
+
+	if s.size >= s.opts.MemoryLimit {
+		return s.spill()
+	}
+
+	return nil
+}
+
+// spill sorts the buffered records and writes them to a temporary file.
+func (s *sorter) spill() error {
+	s.sortRecs()
+
+	f, err := ioutil.TempFile(s.opts.TempDir, "diff-table-sort-")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+
+	bw := bufio.NewWriter(f)
+	enc := gob.NewEncoder(bw)
+
+	for _, rec := range s.recs {
+		sr := spillRecord{
+			Bytes:     rec.Bytes,
+			Nil:       make([]bool, len(rec.Bytes)),
+			Values:    rec.Values,
+			Canonical: rec.Canonical,
+		}
+		for i, b := range rec.Bytes {
+			sr.Nil[i] = b == nil
+		}
+
+		if err := enc.Encode(&sr); err != nil {
+			return err
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.recs = nil
+	s.size = 0
+
+	return nil
+}
+
+// cleanup closes and removes the spilled runs.
+func (s *sorter) cleanup() error {
+	var err error
+	for _, f := range s.runs {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if rerr := os.Remove(f.Name()); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	s.runs = nil
+	return err
+}
+
+// runReader reads records from a spilled run.
+type runReader struct {
+	dec *gob.Decoder
+	rec *sortRecord
+	idx int
+}
+
+func (r *runReader) next() (bool, error) {
+	var sr spillRecord
+	if err := r.dec.Decode(&sr); err != nil {
+		if err == io.EOF {
+			r.rec = nil
+			return false, nil
+		}
+		return false, err
+	}
+
+	for i, b := range sr.Bytes {
+		if b == nil && !sr.Nil[i] {
+			sr.Bytes[i] = []byte{}
+		}
+	}
+
+	// Canonical encodings of values are never empty, only nil for nulls.
+	for i, b := range sr.Canonical {
+		if len(b) == 0 {
+			sr.Canonical[i] = nil
+		}
+	}
+
+	r.rec = &sortRecord{
+		Bytes:     sr.Bytes,
+		Values:    sr.Values,
+		Canonical: sr.Canonical,
+	}
+
+	return true, nil
+}
+
+// runHeap implements heap.Interface ordering runs by their current record.
+// Ties are broken by run index to keep the sort stable.
+type runHeap struct {
+	s    *sorter
+	runs []*runReader
+}
+
+func (h *runHeap) Len() int {
+	return len(h.runs)
+}
+
+func (h *runHeap) Less(i, j int) bool {
+	p := h.s.compare(h.runs[i].rec, h.runs[j].rec)
+	if p == 0 {
+		return h.runs[i].idx < h.runs[j].idx
+	}
+	return p < 0
+}
+
+func (h *runHeap) Swap(i, j int) {
+	h.runs[i], h.runs[j] = h.runs[j], h.runs[i]
+}
+
+func (h *runHeap) Push(x interface{}) {
+	h.runs = append(h.runs, x.(*runReader))
+}
+
+func (h *runHeap) Pop() interface{} {
+	n := len(h.runs)
+	r := h.runs[n-1]
+	h.runs = h.runs[:n-1]
+	return r
+}
+
+func init() {
+	// Register common value types so they can be spilled to disk.
+	gob.Register(time.Time{})
+	gob.Register(time.Duration(0))
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+// Sort returns a table yielding the rows of t ordered by its key. Rows are
+// sorted in memory up to the memory limit in the options and the remaining
+// rows are sorted in runs spilled to disk and merged. Key values are ordered
+// using the comparators of the key columns, see RegisterComparator.
+//
+// All rows of t are read before Sort returns. Values are copied as returned
+// by Row.Value and spilled values must be encodable by encoding/gob. Values
+// of types other than the common ones must be registered with gob.Register.
+func Sort(t Table, opts SortOptions) (Table, error) {
+	st, err := sortTable(t, opts)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// sortTable reads all rows from the table and returns a table yielding the
+// rows ordered by the key.
+func sortTable(t Table, opts SortOptions) (*sortedTable, error) {
+	if opts.MemoryLimit <= 0 {
+		opts.MemoryLimit = DefaultSortMemory
+	}
+
+	key := t.Key()
+	cols := t.Cols()
+
+	// Fixed column order for the materialized rows.
+	names := make([]string, 0, len(cols))
+	for c := range cols {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+
+	colIdxs := make(map[string]int, len(names))
+	for i, c := range names {
+		colIdxs[c] = i
+	}
+
+	keyIdx := make([]int, len(key))
+	for i, k := range key {
+		x, ok := colIdxs[k]
+		if !ok {
+			return nil, fmt.Errorf("table does not have key column `%s`", k)
+		}
+		keyIdx[i] = x
+	}
+
+	s := &sorter{
+		opts:   opts,
+		keyIdx: keyIdx,
+		cmps:   keyComparators(t, t, key, key),
+	}
+
+	for {
+		ok, err := t.Next()
+		if err != nil {
+			s.cleanup()
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+
+		if err := s.add(materializeRow(t.Row(), names)); err != nil {
+			s.cleanup()
+			return nil, err
+		}
+	}
+
+	st := &sortedTable{
+		src:     t,
+		key:     key,
+		cols:    cols,
+		colIdxs: colIdxs,
+		sorter:  s,
+	}
+
+	// Everything fit in memory.
+	if len(s.runs) == 0 {
+		s.sortRecs()
+		st.recs = s.recs
+		s.recs = nil
+		return st, nil
+	}
+
+	// Spill the remainder so all runs are merged the same way.
+	if len(s.recs) > 0 {
+		if err := s.spill(); err != nil {
+			s.cleanup()
+			return nil, err
+		}
+	}
+
+	h := &runHeap{s: s}
+
+	for i, f := range s.runs {
+		r := &runReader{
+			dec: gob.NewDecoder(bufio.NewReader(f)),
+			idx: i,
+		}
+
+		ok, err := r.next()
+		if err != nil {
+			s.cleanup()
+			return nil, err
+		}
+		if ok {
+			h.runs = append(h.runs, r)
+		}
+	}
+
+	heap.Init(h)
+	st.heap = h
+
+	return st, nil
+}
+
+// materializeRow copies the bytes, values and, for a CanonicalRow, the
+// canonical encoding of the values of the row.
+func materializeRow(r Row, cols []string) *sortRecord {
+	rec := &sortRecord{
+		Bytes:  make([][]byte, len(cols)),
+		Values: make([]interface{}, len(cols)),
+	}
+
+	cr, ok := r.(CanonicalRow)
+	if ok {
+		rec.Canonical = make([][]byte, len(cols))
+	}
+
+	for i, c := range cols {
+		if b := r.Bytes(c); b != nil {
+			rec.Bytes[i] = append([]byte{}, b...)
+		}
+		rec.Values[i] = r.Value(c)
+
+		if ok {
+			if b := cr.CanonicalBytes(c); b != nil {
+				rec.Canonical[i] = append([]byte{}, b...)
+			}
+		}
+	}
+
+	return rec
+}
+
+// sortedTable yields the rows of a sorter in key order, either from memory
+// or by merging the spilled runs.
+type sortedTable struct {
+	src     Table
+	key     []string
+	cols    map[string]string
+	colIdxs map[string]int
+
+	sorter *sorter
+
+	// In-memory records.
+	recs []*sortRecord
+	idx  int
+
+	// Spilled runs.
+	heap *runHeap
+
+	rec *sortRecord
+}
+
+func (t *sortedTable) Key() []string {
+	return t.key
+}
+
+func (t *sortedTable) Cols() map[string]string {
+	return t.cols
+}
+
+// Comparator defers to the source table if it defines its own ordering.
+func (t *sortedTable) Comparator(col string) Comparator {
+	if cc, ok := t.src.(ColumnComparer); ok {
+		return cc.Comparator(col)
+	}
+	return nil
+}
+
+// Schema defers to the source table if it describes its schema.
+func (t *sortedTable) Schema() interface{} {
+	if sd, ok := t.src.(SchemaDiffer); ok {
+		return sd.Schema()
+	}
+	return nil
+}
+
+// DiffSchema defers to the source table if it describes its schema.
+func (t *sortedTable) DiffSchema(old interface{}) (*SchemaDiff, error) {
+	if sd, ok := t.src.(SchemaDiffer); ok {
+		return sd.DiffSchema(old)
+	}
+	return nil, nil
+}
+
+func (t *sortedTable) Row() Row {
+	return newSortRow(t.colIdxs, t.rec)
+}
+
+func (t *sortedTable) Next() (bool, error) {
+	t.rec = nil
+
+	if t.heap == nil {
+		if t.idx == len(t.recs) {
+			t.recs = nil
+			return false, nil
+		}
+
+		t.rec = t.recs[t.idx]
+		t.recs[t.idx] = nil
+		t.idx++
+
+		return true, nil
+	}
+
+	if t.heap.Len() == 0 {
+		return false, t.Close()
+	}
+
+	r := t.heap.runs[0]
+	t.rec = r.rec
+
+	ok, err := r.next()
+	if err != nil {
+		t.Close()
+		return false, err
+	}
+
+	if ok {
+		heap.Fix(t.heap, 0)
+	} else {
+		heap.Pop(t.heap)
+	}
+
+	return true, nil
+}
+
+// Close removes any runs spilled to disk. This is done automatically once
+// all rows have been read.
+func (t *sortedTable) Close() error {
+	if t.heap != nil {
+		t.heap.runs = nil
+	}
+	return t.sorter.cleanup()
+}
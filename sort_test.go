@@ -0,0 +1,261 @@
+package difftable
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUnsortedCsvTableSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff-table-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	buf.WriteString("id,name\n")
+	for i := 999; i >= 0; i-- {
+		fmt.Fprintf(&buf, "%03d,name %d\n", i, i)
+	}
+
+	opts := SortOptions{
+		MemoryLimit: 4096,
+		TempDir:     dir,
+	}
+
+	tb, err := UnsortedCSVTableWithOptions(NewCSVReader(&buf, ','), []string{"id"}, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) < 2 {
+		t.Fatalf("expected multiple spilled runs, got %d", len(files))
+	}
+
+	var n int
+	for {
+		ok, err := tb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+
+		r := tb.Row()
+		if id := fmt.Sprintf("%03d", n); string(r.Bytes("id")) != id {
+			t.Fatalf("expected id %s, got %s", id, r.Bytes("id"))
+		}
+		if name := fmt.Sprintf("name %d", n); r.Value("name") != name {
+			t.Fatalf("expected name %s, got %v", name, r.Value("name"))
+		}
+
+		n++
+	}
+
+	if n != 1000 {
+		t.Errorf("expected 1000 rows, got %d", n)
+	}
+
+	files, _ = ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("expected spilled runs to be removed, got %d", len(files))
+	}
+}
+
+func TestUnsortedCsvTableKeyTuple(t *testing.T) {
+	// Joining the key values with "|" would compare "a|b|x" to "a|z" and
+	// order "a|b" before "a".
+	data := `k1,k2
+a|b,x
+a,z
+`
+
+	tb, err := UnsortedCSVTable(NewCSVReader(bytes.NewBufferString(data), ','), []string{"k1", "k2"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for {
+		ok, err := tb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		keys = append(keys, string(tb.Row().Bytes("k1")))
+	}
+
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "a|b" {
+		t.Errorf("unexpected order %v", keys)
+	}
+}
+
+// sliceTable is a table over in-memory rows of typed values.
+type sliceTable struct {
+	key  []string
+	cols map[string]string
+	rows []map[string]interface{}
+	idx  int
+}
+
+func (t *sliceTable) Key() []string {
+	return t.key
+}
+
+func (t *sliceTable) Cols() map[string]string {
+	return t.cols
+}
+
+func (t *sliceTable) Row() Row {
+	return sliceRow(t.rows[t.idx-1])
+}
+
+func (t *sliceTable) Next() (bool, error) {
+	if t.idx == len(t.rows) {
+		return false, nil
+	}
+	t.idx++
+	return true, nil
+}
+
+type sliceRow map[string]interface{}
+
+func (r sliceRow) Bytes(col string) []byte {
+	v, ok := r[col]
+	if !ok || v == nil {
+		return nil
+	}
+	return []byte(fmt.Sprint(v))
+}
+
+func (r sliceRow) Value(col string) interface{} {
+	return r[col]
+}
+
+func TestSort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff-table-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	st := &sliceTable{
+		key: []string{"id"},
+		cols: map[string]string{
+			"id":      "long",
+			"created": "timestamp",
+			"note":    "string",
+		},
+	}
+
+	base := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 500; i > 0; i-- {
+		var note interface{}
+		if i%2 == 0 {
+			note = fmt.Sprintf("note %d", i)
+		}
+
+		st.rows = append(st.rows, map[string]interface{}{
+			"id":      int64(i),
+			"created": base.Add(time.Duration(i) * time.Hour),
+			"note":    note,
+		})
+	}
+
+	tb, err := Sort(st, SortOptions{
+		MemoryLimit: 2048,
+		TempDir:     dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int64
+	for {
+		ok, err := tb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		n++
+
+		r := tb.Row()
+		if id := r.Value("id"); id != n {
+			t.Fatalf("expected id %d, got %v", n, id)
+		}
+		if c, ok := r.Value("created").(time.Time); !ok || !c.Equal(base.Add(time.Duration(n)*time.Hour)) {
+			t.Fatalf("unexpected created value %v", r.Value("created"))
+		}
+		if n%2 == 1 && (r.Value("note") != nil || r.Bytes("note") != nil) {
+			t.Fatalf("expected nil note, got %v", r.Value("note"))
+		}
+	}
+
+	if n != 500 {
+		t.Errorf("expected 500 rows, got %d", n)
+	}
+}
+
+func TestSortSpillDurations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff-table-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Times of day, such as Avro time-millis and SAS, Stata and SPSS time
+	// formats, are read as durations.
+	st := &sliceTable{
+		key: []string{"id"},
+		cols: map[string]string{
+			"id":   "long",
+			"time": "time",
+		},
+	}
+
+	for i := 200; i > 0; i-- {
+		st.rows = append(st.rows, map[string]interface{}{
+			"id":   int64(i),
+			"time": time.Duration(i) * time.Minute,
+		})
+	}
+
+	tb, err := Sort(st, SortOptions{
+		MemoryLimit: 1000,
+		TempDir:     dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int64
+	for {
+		ok, err := tb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		n++
+
+		if d := tb.Row().Value("time"); d != time.Duration(n)*time.Minute {
+			t.Fatalf("expected time %s, got %v", time.Duration(n)*time.Minute, d)
+		}
+	}
+
+	if n != 200 {
+		t.Errorf("expected 200 rows, got %d", n)
+	}
+}
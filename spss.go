@@ -0,0 +1,783 @@
+package difftable
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Compression of the data of an SPSS system file.
+const (
+	spssUncompressed = 0
+	spssBytecode     = 1
+	spssZlib         = 2
+)
+
+// spssEpoch is the start of the Gregorian calendar from which SPSS counts
+// dates and datetimes in seconds.
+var spssEpoch = time.Date(1582, 10, 14, 0, 0, 0, 0, time.UTC)
+
+// spssFormatKinds maps print format types to the kind of column. Other
+// numeric formats are numbers.
+var spssFormatKinds = map[int]string{
+	20: "date",     // DATE
+	23: "date",     // ADATE
+	24: "date",     // JDATE
+	28: "date",     // QYR
+	29: "date",     // MOYR
+	30: "date",     // WKYR
+	38: "date",     // EDATE
+	39: "date",     // SDATE
+	22: "datetime", // DATETIME
+	41: "datetime", // YMDHMS
+	21: "time",     // TIME
+	25: "time",     // DTIME
+	40: "time",     // MTIME
+}
+
+// Very long strings are stored as segments of 255 bytes of which 252 are
+// used.
+const spssSegmentLen = 252
+
+// SPSSOptions are options for reading an SPSS dataset.
+type SPSSOptions struct {
+	// ValueLabels returns the label of labeled values from Row.Value rather
+	// than the value. Row.Bytes always returns the value so rows compare
+	// the same whether or not labels are applied.
+	ValueLabels bool
+}
+
+// spssSlot is a variable record of the dictionary. Strings longer than 8
+// bytes are followed by a continuation record, stored as a nil slot, for
+// each additional 8 bytes.
+type spssSlot struct {
+	name   string
+	width  int
+	format int
+
+	// Value labels keyed by the raw value.
+	labels map[[8]byte][]byte
+}
+
+type spssVar struct {
+	name  string
+	kind  string
+	width int
+
+	// Offsets of the segments in a case. Strings up to 255 bytes have one
+	// segment.
+	segments []int
+
+	// Value labels keyed by the encoded value.
+	labels map[string]string
+}
+
+// spssCounter counts the bytes read so the end of compressed data can be
+// found.
+type spssCounter struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *spssCounter) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *spssCounter) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// spssZlibReader reads the zlib streams of a ZSAV file which end at the
+// trailer.
+type spssZlibReader struct {
+	r   *spssCounter
+	end int64
+	z   io.ReadCloser
+	eof bool
+}
+
+func (r *spssZlibReader) Read(b []byte) (int, error) {
+	for {
+		if r.eof {
+			if r.r.n >= r.end {
+				return 0, io.EOF
+			}
+
+			var err error
+			if r.z == nil {
+				r.z, err = zlib.NewReader(r.r)
+			} else {
+				err = r.z.(zlib.Resetter).Reset(r.r, nil)
+			}
+			if err != nil {
+				return 0, err
+			}
+
+			r.eof = false
+		}
+
+		n, err := r.z.Read(b)
+		if err == io.EOF {
+			r.eof = true
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+type spssReader struct {
+	r  *spssCounter
+	bo binary.ByteOrder
+
+	compression int
+	bias        float64
+	sysmis      float64
+
+	// Number of cases or -1 if unknown.
+	ncases int64
+
+	slots []*spssSlot
+	vars  []*spssVar
+
+	// Data and the command bytes of compressed data.
+	data io.Reader
+	cmds []byte
+	done bool
+
+	decode func([]byte) string
+}
+
+func (d *spssReader) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (d *spssReader) int32() (int, error) {
+	b, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int(int32(d.bo.Uint32(b))), nil
+}
+
+func newSPSSReader(r io.Reader) (*spssReader, error) {
+	d := &spssReader{
+		r:      &spssCounter{r: bufio.NewReader(r)},
+		sysmis: -math.MaxFloat64,
+	}
+
+	h, err := d.read(176)
+	if err != nil {
+		return nil, fmt.Errorf("header: %s", err)
+	}
+
+	if s := string(h[:4]); s != "$FL2" && s != "$FL3" {
+		return nil, errors.New("not an SPSS system file")
+	}
+
+	// The layout code is 2 or 3 in the byte order of the file.
+	d.bo = binary.LittleEndian
+	if c := d.bo.Uint32(h[64:]); c != 2 && c != 3 {
+		d.bo = binary.BigEndian
+	}
+
+	d.compression = int(d.bo.Uint32(h[72:]))
+	d.ncases = int64(int32(d.bo.Uint32(h[80:])))
+	d.bias = math.Float64frombits(d.bo.Uint64(h[84:]))
+
+	if d.compression > spssZlib {
+		return nil, fmt.Errorf("unknown compression %d", d.compression)
+	}
+
+	if err := d.readDictionary(); err != nil {
+		return nil, fmt.Errorf("dictionary: %s", err)
+	}
+
+	d.data = d.r
+
+	if d.compression == spssZlib {
+		b, err := d.read(24)
+		if err != nil {
+			return nil, fmt.Errorf("zlib header: %s", err)
+		}
+
+		d.data = bufio.NewReader(&spssZlibReader{
+			r:   d.r,
+			end: int64(d.bo.Uint64(b[8:])),
+			eof: true,
+		})
+	}
+
+	return d, nil
+}
+
+// readDictionary reads the records up to the end of the dictionary.
+func (d *spssReader) readDictionary() error {
+	var (
+		encoding  string
+		longNames map[string]string
+		widths    map[string]int
+	)
+
+	for {
+		typ, err := d.int32()
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case 2:
+			if err := d.readVariable(); err != nil {
+				return fmt.Errorf("variable %d: %s", len(d.slots)+1, err)
+			}
+
+		case 3:
+			if err := d.readValueLabels(); err != nil {
+				return fmt.Errorf("value labels: %s", err)
+			}
+
+		case 6:
+			n, err := d.int32()
+			if err != nil {
+				return err
+			}
+			if _, err := d.read(80 * n); err != nil {
+				return err
+			}
+
+		case 7:
+			sub, err := d.int32()
+			if err != nil {
+				return err
+			}
+			size, err := d.int32()
+			if err != nil {
+				return err
+			}
+			count, err := d.int32()
+			if err != nil {
+				return err
+			}
+
+			b, err := d.read(size * count)
+			if err != nil {
+				return err
+			}
+
+			switch sub {
+			case 3:
+				// The character code is the last of 8 integers.
+				if len(b) >= 32 && encoding == "" {
+					if c := int32(d.bo.Uint32(b[28:])); c == 65001 {
+						encoding = "utf-8"
+					} else {
+						encoding = strconv.Itoa(int(c))
+					}
+				}
+
+			case 4:
+				if len(b) >= 8 {
+					d.sysmis = math.Float64frombits(d.bo.Uint64(b))
+				}
+
+			case 13:
+				longNames = make(map[string]string)
+				for _, p := range strings.Split(string(b), "\t") {
+					if i := strings.IndexByte(p, '='); i > 0 {
+						longNames[p[:i]] = p[i+1:]
+					}
+				}
+
+			case 14:
+				widths = make(map[string]int)
+				for _, p := range strings.Split(string(b), "\t") {
+					p = strings.Trim(p, "\x00")
+					if i := strings.IndexByte(p, '='); i > 0 {
+						w, err := strconv.Atoi(p[i+1:])
+						if err != nil {
+							return fmt.Errorf("very long string `%s`: invalid width", p[:i])
+						}
+						widths[p[:i]] = w
+					}
+				}
+
+			case 20:
+				encoding = string(b)
+			}
+
+		case 999:
+			if _, err := d.read(4); err != nil {
+				return err
+			}
+
+			d.decode = textDecoder(encoding)
+
+			return d.variables(longNames, widths)
+
+		default:
+			return fmt.Errorf("unknown record type %d", typ)
+		}
+	}
+}
+
+// readVariable reads a variable record.
+func (d *spssReader) readVariable() error {
+	b, err := d.read(28)
+	if err != nil {
+		return err
+	}
+
+	typ := int(int32(d.bo.Uint32(b)))
+	hasLabel := d.bo.Uint32(b[4:]) == 1
+	missing := int(int32(d.bo.Uint32(b[8:])))
+	format := int(d.bo.Uint32(b[12:]))
+	name := strings.TrimRight(string(b[20:28]), " ")
+
+	if hasLabel {
+		n, err := d.int32()
+		if err != nil {
+			return err
+		}
+		if _, err := d.read((n + 3) / 4 * 4); err != nil {
+			return err
+		}
+	}
+
+	// A negative count is a range of missing values.
+	if missing < 0 {
+		missing = -missing
+	}
+	if _, err := d.read(8 * missing); err != nil {
+		return err
+	}
+
+	if typ == -1 {
+		if len(d.slots) == 0 {
+			return errors.New("continuation without a variable")
+		}
+		d.slots = append(d.slots, nil)
+		return nil
+	}
+
+	d.slots = append(d.slots, &spssSlot{
+		name:   name,
+		width:  typ,
+		format: format,
+	})
+
+	return nil
+}
+
+// readValueLabels reads a value label record and the record of the
+// variables it applies to that follows.
+func (d *spssReader) readValueLabels() error {
+	n, err := d.int32()
+	if err != nil {
+		return err
+	}
+
+	labels := make(map[[8]byte][]byte, n)
+
+	for i := 0; i < n; i++ {
+		b, err := d.read(9)
+		if err != nil {
+			return err
+		}
+
+		// The label and its length are padded to a multiple of 8 bytes.
+		l, err := d.read((int(b[8])+8)/8*8 - 1)
+		if err != nil {
+			return err
+		}
+
+		var v [8]byte
+		copy(v[:], b)
+		labels[v] = l[:b[8]]
+	}
+
+	typ, err := d.int32()
+	if err != nil {
+		return err
+	}
+	if typ != 4 {
+		return fmt.Errorf("expected record type 4, got %d", typ)
+	}
+
+	count, err := d.int32()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		idx, err := d.int32()
+		if err != nil {
+			return err
+		}
+		if idx < 1 || idx > len(d.slots) || d.slots[idx-1] == nil {
+			return fmt.Errorf("variable %d out of range", idx)
+		}
+
+		d.slots[idx-1].labels = labels
+	}
+
+	return nil
+}
+
+// variables combines the slots into variables, naming them by their long
+// names and joining the segments of very long strings.
+func (d *spssReader) variables(longNames map[string]string, widths map[string]int) error {
+	for i := 0; i < len(d.slots); i++ {
+		s := d.slots[i]
+		if s == nil {
+			continue
+		}
+
+		v := &spssVar{
+			name:     s.name,
+			width:    s.width,
+			segments: []int{8 * i},
+		}
+
+		if n, ok := longNames[s.name]; ok {
+			v.name = n
+		}
+
+		if s.width == 0 {
+			v.kind = "number"
+			if k, ok := spssFormatKinds[s.format>>16&0xff]; ok {
+				v.kind = k
+			}
+		} else {
+			v.kind = "string"
+		}
+
+		// The remaining segments are the following variables.
+		if w, ok := widths[s.name]; ok && w > 255 {
+			v.width = w
+
+			for n := (w + spssSegmentLen - 1) / spssSegmentLen; len(v.segments) < n; {
+				i++
+				if i >= len(d.slots) {
+					return fmt.Errorf("very long string `%s`: missing segments", v.name)
+				}
+				if d.slots[i] != nil {
+					v.segments = append(v.segments, 8*i)
+				}
+			}
+		}
+
+		if s.labels != nil {
+			v.labels = make(map[string]string, len(s.labels))
+			for x, l := range s.labels {
+				var k interface{}
+				if v.width == 0 {
+					k = math.Float64frombits(d.bo.Uint64(x[:]))
+				} else {
+					k = d.decode(bytes.TrimRight(x[:], " "))
+				}
+				v.labels[string(datasetBytes(v.kind, k))] = d.decode(l)
+			}
+		}
+
+		d.vars = append(d.vars, v)
+	}
+
+	return nil
+}
+
+// next reads the next case into row.
+func (d *spssReader) next(row []byte) (bool, error) {
+	if d.compression == spssUncompressed {
+		_, err := io.ReadFull(d.data, row)
+		if err == io.EOF {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	for i := 0; i < len(row); i += 8 {
+		err := d.slot(row[i : i+8])
+		if err == io.EOF && i == 0 {
+			return false, nil
+		}
+		if err == io.EOF {
+			return false, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// slot decompresses the next 8 bytes of compressed data. Each byte of a
+// block of 8 command bytes is followed by the data it needs.
+func (d *spssReader) slot(b []byte) error {
+	for {
+		if d.done {
+			return io.EOF
+		}
+
+		if len(d.cmds) == 0 {
+			d.cmds = make([]byte, 8)
+			if _, err := io.ReadFull(d.data, d.cmds); err != nil {
+				return err
+			}
+		}
+
+		c := d.cmds[0]
+		d.cmds = d.cmds[1:]
+
+		switch c {
+		case 0:
+			continue
+		case 252:
+			d.done = true
+			continue
+		case 253:
+			_, err := io.ReadFull(d.data, b)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		case 254:
+			copy(b, "        ")
+		case 255:
+			d.bo.PutUint64(b, math.Float64bits(d.sysmis))
+		default:
+			d.bo.PutUint64(b, math.Float64bits(float64(c)-d.bias))
+		}
+
+		return nil
+	}
+}
+
+// code returns the value of the variable as it is stored, a float64 or a
+// string. System-missing values are nil.
+func (d *spssReader) code(v *spssVar, row []byte) interface{} {
+	if v.width == 0 {
+		f := math.Float64frombits(d.bo.Uint64(row[v.segments[0]:]))
+		if f == d.sysmis || math.IsNaN(f) {
+			return nil
+		}
+		return f
+	}
+
+	if len(v.segments) == 1 {
+		return d.decode(bytes.TrimRight(row[v.segments[0]:v.segments[0]+v.width], " "))
+	}
+
+	var s []byte
+	for _, o := range v.segments {
+		n := v.width - len(s)
+		if n > spssSegmentLen {
+			n = spssSegmentLen
+		}
+		s = append(s, row[o:o+n]...)
+	}
+
+	return d.decode(bytes.TrimRight(s, " "))
+}
+
+// value returns the value of the variable. Dates and datetimes are
+// returned as time.Time values and times as time.Duration values.
+func (d *spssReader) value(v *spssVar, row []byte) interface{} {
+	x := d.code(v, row)
+
+	f, ok := x.(float64)
+	if !ok {
+		return x
+	}
+
+	switch v.kind {
+	case "date", "datetime":
+		// The epoch is too far back for the seconds to fit a duration.
+		days := math.Floor(f / 86400)
+		t := spssEpoch.AddDate(0, 0, int(days))
+		if v.kind == "date" {
+			return t
+		}
+		return t.Add(time.Duration(math.Round((f-days*86400)*1e6)) * time.Microsecond)
+	case "time":
+		return time.Duration(math.Round(f*1e6)) * time.Microsecond
+	}
+
+	return f
+}
+
+// SPSSTable returns a table of the cases of an SPSS system file using the
+// default options.
+func SPSSTable(r io.Reader, key []string, renames map[string]string) (Table, error) {
+	return SPSSTableWithOptions(r, key, renames, SPSSOptions{})
+}
+
+// SPSSTableWithOptions returns a table of the cases of an SPSS system file
+// (.sav) or ZSAV file (.zsav). Files are read sequentially, so they can be
+// read from stdin, and compressed files are supported.
+//
+// Columns are named by their long names and typed string or number unless
+// their format is a date, datetime or time format, such as DATE11 or
+// DATETIME20, in which case they are typed date, datetime or time. Dates
+// and datetimes are returned as time.Time values and times as
+// time.Duration values. System-missing values are null.
+func SPSSTableWithOptions(r io.Reader, key []string, renames map[string]string, opts SPSSOptions) (Table, error) {
+	d, err := newSPSSReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, k := range key {
+		if n, ok := renames[k]; ok {
+			key[i] = n
+		}
+	}
+
+	t := &spssTable{
+		d:       d,
+		key:     key,
+		opts:    opts,
+		cols:    make(map[string]string, len(d.vars)),
+		colIdxs: make(map[string]int, len(d.vars)),
+		row:     make([]byte, 8*len(d.slots)),
+	}
+
+	for i, v := range d.vars {
+		name := v.name
+		if n, ok := renames[name]; ok {
+			name = n
+		}
+
+		if _, ok := t.colIdxs[name]; ok {
+			return nil, fmt.Errorf("duplicate column `%s`", name)
+		}
+
+		t.cols[name] = v.kind
+		t.colIdxs[name] = i
+
+		col := &Column{
+			Name:        name,
+			Type:        v.kind,
+			Nullable:    v.kind != "string",
+			HasNullable: true,
+		}
+		if v.kind == "string" {
+			col.Length = int64(v.width)
+			col.HasLength = true
+		}
+
+		t.colInfo = append(t.colInfo, col)
+	}
+
+	return t, nil
+}
+
+type spssTable struct {
+	d    *spssReader
+	key  []string
+	opts SPSSOptions
+
+	cols    map[string]string
+	colInfo []*Column
+	colIdxs map[string]int
+
+	// Number of cases read.
+	cases int64
+	row   []byte
+}
+
+func (t *spssTable) Key() []string {
+	return t.key
+}
+
+func (t *spssTable) Cols() map[string]string {
+	return t.cols
+}
+
+// Columns returns the variables in dictionary order. Numeric variables are
+// nullable and strings have their width in bytes.
+func (t *spssTable) Columns() []*Column {
+	return t.colInfo
+}
+
+func (t *spssTable) Row() Row {
+	return &spssRow{
+		t:   t,
+		row: t.row,
+	}
+}
+
+func (t *spssTable) Next() (bool, error) {
+	if t.d.ncases >= 0 && t.cases >= t.d.ncases {
+		return false, nil
+	}
+
+	ok, err := t.d.next(t.row)
+	if err != nil {
+		return false, fmt.Errorf("case %d: %s", t.cases+1, err)
+	}
+
+	if ok {
+		t.cases++
+	}
+
+	return ok, nil
+}
+
+type spssRow struct {
+	t   *spssTable
+	row []byte
+}
+
+func (r *spssRow) Bytes(col string) []byte {
+	i, ok := r.t.colIdxs[col]
+	if !ok {
+		return nil
+	}
+
+	v := r.t.d.vars[i]
+
+	return datasetBytes(v.kind, r.t.d.value(v, r.row))
+}
+
+// Value returns the value of the column or, if value labels are applied,
+// its label.
+func (r *spssRow) Value(col string) interface{} {
+	i, ok := r.t.colIdxs[col]
+	if !ok {
+		return nil
+	}
+
+	v := r.t.d.vars[i]
+
+	if r.t.opts.ValueLabels && v.labels != nil {
+		if x := r.t.d.code(v, r.row); x != nil {
+			if l, ok := v.labels[string(datasetBytes(v.kind, x))]; ok {
+				return l
+			}
+		}
+	}
+
+	return r.t.d.value(v, r.row)
+}
@@ -0,0 +1,344 @@
+package difftable
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type spssTestVar struct {
+	name   string
+	width  int
+	format int
+	labels map[float64]string
+}
+
+// writeSPSS writes a little-endian SPSS system file with the compression.
+// Strings longer than 255 bytes are written as very long strings and ZSAV
+// data is split into two zlib streams.
+func writeSPSS(t *testing.T, compression int, vars []spssTestVar, rows [][]interface{}) []byte {
+	le := binary.LittleEndian
+
+	var (
+		dict  bytes.Buffer
+		names []string
+		vls   []string
+
+		// Width of each segment of each variable.
+		segments [][]int
+		slots    int
+	)
+
+	put := func(w *bytes.Buffer, v interface{}) {
+		binary.Write(w, le, v)
+	}
+
+	variable := func(name string, width, format int) {
+		put(&dict, int32(2))
+		put(&dict, int32(width))
+		put(&dict, []int32{0, 0, int32(format<<16 | 8<<8), int32(format<<16 | 8<<8)})
+		dict.WriteString(fmt.Sprintf("%-8s", name))
+		slots++
+
+		for i := 8; i < width; i += 8 {
+			put(&dict, []int32{2, -1, 0, 0, 0, 0})
+			dict.WriteString("        ")
+			slots++
+		}
+	}
+
+	var labelRecords bytes.Buffer
+
+	for i, v := range vars {
+		short := fmt.Sprintf("V%d", i+1)
+		names = append(names, short+"="+v.name)
+
+		format := v.format
+		if format == 0 {
+			format = 5
+			if v.width > 0 {
+				format = 1
+			}
+		}
+
+		if v.width <= 255 {
+			if v.labels != nil {
+				put(&labelRecords, []int32{3, int32(len(v.labels))})
+				for x, l := range v.labels {
+					put(&labelRecords, math.Float64bits(x))
+					labelRecords.WriteByte(byte(len(l)))
+					labelRecords.WriteString(l)
+					labelRecords.Write(make([]byte, (len(l)+8)/8*8-1-len(l)))
+				}
+				put(&labelRecords, []int32{4, 1, int32(slots + 1)})
+			}
+
+			variable(short, v.width, format)
+			segments = append(segments, []int{v.width})
+			continue
+		}
+
+		vls = append(vls, fmt.Sprintf("%s=%05d\x00", short, v.width))
+
+		var seg []int
+		for w := v.width; w > 0; w -= spssSegmentLen {
+			n := 255
+			if w <= spssSegmentLen {
+				n = w
+			}
+			name := short
+			if len(seg) > 0 {
+				name = fmt.Sprintf("%s_%d", short, len(seg))
+			}
+			variable(name, n, format)
+			seg = append(seg, n)
+		}
+		segments = append(segments, seg)
+	}
+
+	dict.Write(labelRecords.Bytes())
+
+	ext := func(sub int, b string) {
+		put(&dict, []int32{7, int32(sub), 1, int32(len(b))})
+		dict.WriteString(b)
+	}
+	ext(13, strings.Join(names, "\t"))
+	if len(vls) > 0 {
+		ext(14, strings.Join(vls, "\t"))
+	}
+	ext(20, "UTF-8")
+	put(&dict, []int32{999, 0})
+
+	header := make([]byte, 176)
+	copy(header, "$FL2")
+	le.PutUint32(header[64:], 2)
+	le.PutUint32(header[68:], uint32(slots))
+	le.PutUint32(header[72:], uint32(compression))
+	le.PutUint32(header[80:], uint32(len(rows)))
+	le.PutUint64(header[84:], math.Float64bits(100))
+
+	// Encode the cases as 8-byte slots.
+	var cases [][]byte
+	for _, r := range rows {
+		for i := range vars {
+			switch x := r[i].(type) {
+			case nil:
+				cases = append(cases, make([]byte, 8))
+				le.PutUint64(cases[len(cases)-1], math.Float64bits(-math.MaxFloat64))
+			case float64:
+				cases = append(cases, make([]byte, 8))
+				le.PutUint64(cases[len(cases)-1], math.Float64bits(x))
+			case string:
+				for j, n := range segments[i] {
+					s := x
+					if len(s) > j*spssSegmentLen {
+						s = s[j*spssSegmentLen:]
+					} else {
+						s = ""
+					}
+					if len(s) > n {
+						s = s[:n]
+					}
+					if len(s) > spssSegmentLen {
+						s = s[:spssSegmentLen]
+					}
+
+					b := []byte(s + strings.Repeat(" ", (n+7)/8*8-len(s)))
+					for len(b) > 0 {
+						cases = append(cases, b[:8])
+						b = b[8:]
+					}
+				}
+			}
+		}
+	}
+
+	var data bytes.Buffer
+
+	if compression == spssUncompressed {
+		for _, c := range cases {
+			data.Write(c)
+		}
+	} else {
+		var (
+			cmds []byte
+			raw  bytes.Buffer
+		)
+
+		flush := func() {
+			for len(cmds) < 8 {
+				cmds = append(cmds, 0)
+			}
+			data.Write(cmds)
+			data.Write(raw.Bytes())
+			cmds = cmds[:0]
+			raw.Reset()
+		}
+
+		for _, c := range cases {
+			f := math.Float64frombits(le.Uint64(c))
+
+			switch {
+			case bytes.Equal(c, []byte("        ")):
+				cmds = append(cmds, 254)
+			case f == -math.MaxFloat64:
+				cmds = append(cmds, 255)
+			case f == math.Trunc(f) && f >= -99 && f <= 151:
+				cmds = append(cmds, byte(f+100))
+			default:
+				cmds = append(cmds, 253)
+				raw.Write(c)
+			}
+
+			if len(cmds) == 8 {
+				flush()
+			}
+		}
+
+		cmds = append(cmds, 252)
+		flush()
+	}
+
+	out := append(header, dict.Bytes()...)
+
+	if compression != spssZlib {
+		return append(out, data.Bytes()...)
+	}
+
+	// Compress the data as two streams.
+	var z bytes.Buffer
+	b := data.Bytes()
+	for _, p := range [][]byte{b[:len(b)/2], b[len(b)/2:]} {
+		w := zlib.NewWriter(&z)
+		w.Write(p)
+		w.Close()
+	}
+
+	zheader := make([]byte, 24)
+	le.PutUint64(zheader, uint64(len(out)))
+	le.PutUint64(zheader[8:], uint64(len(out)+24+z.Len()))
+	le.PutUint64(zheader[16:], 24)
+
+	out = append(out, zheader...)
+	out = append(out, z.Bytes()...)
+
+	return append(out, make([]byte, 24)...)
+}
+
+// spssSeconds returns the seconds since the SPSS epoch, which is too far
+// back for a duration.
+func spssSeconds(t time.Time) float64 {
+	return float64(t.Unix() - spssEpoch.Unix())
+}
+
+var spssTestVars = []spssTestVar{
+	{name: "id"},
+	{name: "full_name", width: 10},
+	{name: "note", width: 300},
+	{name: "born", format: 20},
+	{name: "seen", format: 22},
+	{name: "at", format: 21},
+	{name: "grade", labels: map[float64]string{1: "low", 2: "high"}},
+}
+
+func TestSPSSTable(t *testing.T) {
+	born := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	seen := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	note := strings.Repeat("abcdefghij", 28)
+
+	rows := [][]interface{}{
+		{1.0, "José", note, spssSeconds(born), spssSeconds(seen), 3723.5, 1.0},
+		{2.0, "", "", nil, nil, nil, nil},
+		{1000.5, "Sam", "short", spssSeconds(born.AddDate(0, 0, 1)), spssSeconds(seen), 0.0, 3.0},
+	}
+
+	expectedRows := []map[string]interface{}{
+		{"id": "1", "name": "José", "note": note, "born": "2018-01-02", "seen": "2019-03-04 05:06:07", "at": "01:02:03.5", "grade": "1"},
+		{"id": "2", "name": "", "note": "", "born": nil, "seen": nil, "at": nil, "grade": nil},
+		{"id": "1000.5", "name": "Sam", "note": "short", "born": "2018-01-03", "seen": "2019-03-04 05:06:07", "at": "00:00:00", "grade": "3"},
+	}
+
+	expected := map[string]string{
+		"id":    "number",
+		"name":  "string",
+		"note":  "string",
+		"born":  "date",
+		"seen":  "datetime",
+		"at":    "time",
+		"grade": "number",
+	}
+
+	for _, compression := range []int{spssUncompressed, spssBytecode, spssZlib} {
+		data := writeSPSS(t, compression, spssTestVars, rows)
+
+		tb, err := SPSSTable(bytes.NewReader(data), []string{"id"}, map[string]string{"full_name": "name"})
+		if err != nil {
+			t.Fatalf("compression %d: %s", compression, err)
+		}
+
+		if s1, s2, ok := jsonEqual(expected, tb.Cols()); !ok {
+			t.Errorf("column types don't match. expected:\n%s\ngot:\n%s", s1, s2)
+		}
+
+		if s1, s2, ok := jsonEqual(expectedRows, datasetRows(t, tb, false)); !ok {
+			t.Errorf("unexpected rows (compression %d). expected:\n%s\ngot:\n%s", compression, s1, s2)
+		}
+	}
+}
+
+func TestSPSSTableValueLabels(t *testing.T) {
+	data := writeSPSS(t, spssBytecode, spssTestVars, [][]interface{}{
+		{1.0, "Pam", "", 0.0, 0.0, 0.0, 2.0},
+		{2.0, "Sam", "", 0.0, 0.0, 0.0, 3.0},
+	})
+
+	tb, err := SPSSTableWithOptions(bytes.NewReader(data), []string{"id"}, nil, SPSSOptions{ValueLabels: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var grades []interface{}
+	for {
+		ok, err := tb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+
+		r := tb.Row()
+		grades = append(grades, r.Value("grade"))
+
+		if v, ok := r.Value("born").(time.Time); !ok || !v.Equal(spssEpoch) {
+			t.Errorf("expected born 1582-10-14, got %#v", r.Value("born"))
+		}
+	}
+
+	if s1, s2, ok := jsonEqual([]interface{}{"high", 3}, grades); !ok {
+		t.Errorf("unexpected values. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestSPSSTableInvalid(t *testing.T) {
+	if _, err := SPSSTable(bytes.NewReader(make([]byte, 176)), nil, nil); err == nil {
+		t.Error("expected an error for a file without the magic number")
+	}
+
+	data := writeSPSS(t, spssBytecode, spssTestVars[:1], [][]interface{}{{1.0}})
+	if _, err := SPSSTable(bytes.NewReader(data[:200]), nil, nil); err == nil {
+		t.Error("expected an error for a truncated dictionary")
+	}
+}
+
+func TestSPSSTableFixtures(t *testing.T) {
+	testFixtures(t, "spss/*sav", func(f *os.File) (Table, error) {
+		return SPSSTable(f, nil, nil)
+	})
+}
@@ -2,14 +2,64 @@ package difftable
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 )
 
+// maxPrecision is the largest decimal precision supported by common
+// databases. Unconstrained numeric columns report a bogus precision with
+// some drivers.
+const maxPrecision = 1000
+
+// sqlColumn returns the column metadata reported by the driver.
+func sqlColumn(name string, ct *sql.ColumnType) *Column {
+	c := &Column{
+		Name: name,
+		Type: strings.ToLower(ct.DatabaseTypeName()),
+	}
+
+	c.Nullable, c.HasNullable = ct.Nullable()
+
+	if p, s, ok := ct.DecimalSize(); ok && p > 0 && p <= maxPrecision && s >= 0 && s <= p {
+		c.Precision = p
+		c.Scale = s
+		c.HasPrecisionScale = true
+	}
+
+	// Unbounded types such as text report the max int64 as the length.
+	if l, ok := ct.Length(); ok && l > 0 && l < 1<<31 {
+		c.Length = l
+		c.HasLength = true
+	}
+
+	return c
+}
+
+// sqlColumnType formats the type of a column including its modifiers, e.g.
+// numeric(10,2) or varchar(255), so changes to them are reported.
+func sqlColumnType(c *Column) string {
+	switch {
+	case c.Type == "":
+		return ""
+	case c.HasPrecisionScale:
+		return fmt.Sprintf("%s(%d,%d)", c.Type, c.Precision, c.Scale)
+	case c.HasLength:
+		return fmt.Sprintf("%s(%d)", c.Type, c.Length)
+	}
+	return c.Type
+}
+
 func SQLTable(rows *sql.Rows, key []string, renames map[string]string) (Table, error) {
 	cols, err := rows.Columns()
 	if err != nil {
 		return nil, err
 	}
 
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
 	for i, k := range key {
 		if n, ok := renames[k]; ok {
 			key[i] = n
@@ -18,14 +68,16 @@ func SQLTable(rows *sql.Rows, key []string, renames map[string]string) (Table, e
 
 	// Create map of column name to index in the array.
 	colIdxs := make(map[string]int, len(cols))
-	colTypes := make(map[string]string, len(cols))
+	colInfo := make([]*Column, len(cols))
+	types := make(map[string]string, len(cols))
 
 	for i, c := range cols {
 		if n, ok := renames[c]; ok {
 			c = n
 		}
 		colIdxs[c] = i
-		colTypes[c] = ""
+		colInfo[i] = sqlColumn(c, colTypes[i])
+		types[c] = sqlColumnType(colInfo[i])
 	}
 
 	// Bytes.
@@ -46,7 +98,8 @@ func SQLTable(rows *sql.Rows, key []string, renames map[string]string) (Table, e
 		key:      key,
 		cols:     cols,
 		colIdxs:  colIdxs,
-		colTypes: colTypes,
+		colTypes: types,
+		colInfo:  colInfo,
 		bvals:    bvals,
 		bdest:    bdest,
 		rvals:    rvals,
@@ -61,6 +114,7 @@ type sqlTable struct {
 	cols     []string
 	colIdxs  map[string]int
 	colTypes map[string]string
+	colInfo  []*Column
 
 	bdest []interface{}
 	bvals [][]byte
@@ -77,6 +131,12 @@ func (t *sqlTable) Cols() map[string]string {
 	return t.colTypes
 }
 
+// Columns returns the column metadata reported by the driver in the order
+// of the result set.
+func (t *sqlTable) Columns() []*Column {
+	return t.colInfo
+}
+
 func (t *sqlTable) Row() Row {
 	return &sqlRow{
 		colTypes: t.colTypes,
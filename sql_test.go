@@ -0,0 +1,194 @@
+package difftable
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testDriver is a minimal driver returning a fixed result set with column
+// type metadata for any query. All columns are nullable if the name is
+// nullable, otherwise the first is not.
+type testDriver struct{}
+
+func (testDriver) Open(name string) (driver.Conn, error) {
+	return &testConn{nullable: name == "nullable"}, nil
+}
+
+type testConn struct {
+	nullable bool
+}
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	return &testStmt{nullable: c.nullable}, nil
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+
+type testStmt struct {
+	nullable bool
+}
+
+func (s *testStmt) Close() error {
+	return nil
+}
+
+func (s *testStmt) NumInput() int {
+	return -1
+}
+
+func (s *testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("exec not supported")
+}
+
+func (s *testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &testRows{
+		nullable: s.nullable,
+		rows: [][]driver.Value{
+			{int64(1), "1.50", "John"},
+			{int64(2), "2.25", nil},
+		},
+	}, nil
+}
+
+type testRows struct {
+	nullable bool
+	rows     [][]driver.Value
+	idx      int
+}
+
+func (r *testRows) Columns() []string {
+	return []string{"id", "amount", "name"}
+}
+
+func (r *testRows) Close() error {
+	return nil
+}
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.idx == len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.idx])
+	r.idx++
+	return nil
+}
+
+func (r *testRows) ColumnTypeDatabaseTypeName(i int) string {
+	return []string{"INT4", "NUMERIC", "VARCHAR"}[i]
+}
+
+func (r *testRows) ColumnTypeNullable(i int) (bool, bool) {
+	return r.nullable || i != 0, true
+}
+
+func (r *testRows) ColumnTypePrecisionScale(i int) (int64, int64, bool) {
+	if i == 1 {
+		return 10, 2, true
+	}
+	return 0, 0, false
+}
+
+func (r *testRows) ColumnTypeLength(i int) (int64, bool) {
+	if i == 2 {
+		return 255, true
+	}
+	return 0, false
+}
+
+func init() {
+	sql.Register("difftable-test", testDriver{})
+}
+
+func TestSQLTableColumns(t *testing.T) {
+	db, err := sql.Open("difftable-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	tb, err := SQLTable(rows, []string{"id"}, map[string]string{"amount": "total"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"id":    "int4",
+		"total": "numeric(10,2)",
+		"name":  "varchar(255)",
+	}
+
+	if s1, s2, ok := jsonEqual(expected, tb.Cols()); !ok {
+		t.Errorf("column types don't match. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	cols := tb.(ColumnDescriber).Columns()
+
+	if c := cols[0]; c.Name != "id" || !c.HasNullable || c.Nullable {
+		t.Errorf("expected id to be not nullable, got %+v", c)
+	}
+
+	if c := cols[1]; c.Name != "total" || !c.HasPrecisionScale || c.Precision != 10 || c.Scale != 2 {
+		t.Errorf("expected total to be numeric(10,2), got %+v", c)
+	}
+
+	if c := cols[2]; c.Name != "name" || !c.HasLength || c.Length != 255 || !c.Nullable {
+		t.Errorf("expected name to be nullable varchar(255), got %+v", c)
+	}
+}
+
+func TestSQLTableNullability(t *testing.T) {
+	table := func(dsn string) Table {
+		db, err := sql.Open("difftable-test", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		rows, err := db.Query("select")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { rows.Close() })
+
+		tb, err := SQLTable(rows, []string{"id"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tb
+	}
+
+	var events []*Event
+	err := DiffEvents(table(""), table("nullable"), func(e *Event) error {
+		e.Time = 0
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	no, yes := false, true
+
+	expected := []*Event{
+		{Type: EventNullability, Column: "id", OldNullable: &no, NewNullable: &yes},
+	}
+
+	if s1, s2, ok := jsonEqualEvents(expected, events); !ok {
+		t.Errorf("unexpected events. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
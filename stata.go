@@ -0,0 +1,867 @@
+package difftable
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Storage types of Stata 13 and later files. Types of older files are
+// converted to these. Types from 1 to 2045 are fixed-length strings.
+const (
+	stataStrL   = 32768
+	stataDouble = 65526
+	stataFloat  = 65527
+	stataLong   = 65528
+	stataInt    = 65529
+	stataByte   = 65530
+)
+
+// StataOptions are options for reading a Stata dataset.
+type StataOptions struct {
+	// ValueLabels returns the label of labeled values from Row.Value rather
+	// than the value. Row.Bytes always returns the value so rows compare
+	// the same whether or not labels are applied.
+	ValueLabels bool
+}
+
+type stataVar struct {
+	name string
+	typ  int
+
+	// Offset and width of the value in a row.
+	offset int
+	width  int
+
+	// Name of the value label table.
+	labels string
+}
+
+// stataType returns the name of the storage type.
+func stataType(typ int) string {
+	switch typ {
+	case stataStrL:
+		return "strL"
+	case stataDouble:
+		return "double"
+	case stataFloat:
+		return "float"
+	case stataLong:
+		return "long"
+	case stataInt:
+		return "int"
+	case stataByte:
+		return "byte"
+	}
+	return fmt.Sprintf("str%d", typ)
+}
+
+// stataWidth returns the width in bytes of a value of the type.
+func stataWidth(typ int) (int, error) {
+	switch {
+	case typ >= 1 && typ <= 2045:
+		return typ, nil
+	case typ == stataStrL, typ == stataDouble:
+		return 8, nil
+	case typ == stataFloat, typ == stataLong:
+		return 4, nil
+	case typ == stataInt:
+		return 2, nil
+	case typ == stataByte:
+		return 1, nil
+	}
+	return 0, fmt.Errorf("unknown variable type %d", typ)
+}
+
+// stataReader reads the metadata of a Stata dataset and the strLs and value
+// labels stored after the data.
+type stataReader struct {
+	r       io.ReadSeeker
+	bo      binary.ByteOrder
+	release int
+	nobs    int64
+	vars    []*stataVar
+	rowLen  int
+
+	// Offset of the first row.
+	data int64
+
+	// Value labels keyed by table name and value.
+	labels map[string]map[int64]string
+
+	// Long strings keyed by variable and observation.
+	strls map[[2]uint64]string
+
+	decode func([]byte) string
+}
+
+func (d *stataReader) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (d *stataReader) seek(off int64) error {
+	_, err := d.r.Seek(off, io.SeekStart)
+	return err
+}
+
+// uint reads an unsigned integer of n bytes.
+func (d *stataReader) uint(n int) (uint64, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return 0, err
+	}
+	return stataUint(d.bo, b), nil
+}
+
+func stataUint(bo binary.ByteOrder, b []byte) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(bo.Uint16(b))
+	case 4:
+		return uint64(bo.Uint32(b))
+	}
+	return bo.Uint64(b)
+}
+
+// expect reads the tag.
+func (d *stataReader) expect(tag string) error {
+	b, err := d.read(len(tag))
+	if err != nil {
+		return err
+	}
+	if string(b) != tag {
+		return fmt.Errorf("expected %s, got %q", tag, b)
+	}
+	return nil
+}
+
+// strs reads fixed-length strings ending at the first null byte.
+func (d *stataReader) strs(count, n int) ([]string, error) {
+	b, err := d.read(count * n)
+	if err != nil {
+		return nil, err
+	}
+
+	s := make([]string, count)
+	for i := range s {
+		s[i] = d.decode(stataCString(b[i*n : (i+1)*n]))
+	}
+	return s, nil
+}
+
+func stataCString(b []byte) []byte {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return b[:i]
+	}
+	return b
+}
+
+func newStataReader(r io.ReadSeeker) (*stataReader, error) {
+	d := &stataReader{
+		r:      r,
+		labels: make(map[string]map[int64]string),
+		strls:  make(map[[2]uint64]string),
+		decode: textDecoder(""),
+	}
+
+	b, err := d.read(11)
+	if err != nil {
+		return nil, fmt.Errorf("header: %s", err)
+	}
+
+	if string(b) == "<stata_dta>" {
+		err = d.readXML()
+	} else {
+		if err = d.seek(0); err == nil {
+			err = d.readBinary()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range d.vars {
+		v.width, err = stataWidth(v.typ)
+		if err != nil {
+			return nil, fmt.Errorf("variable `%s`: %s", v.name, err)
+		}
+		v.offset = d.rowLen
+		d.rowLen += v.width
+	}
+
+	return d, nil
+}
+
+// readXML reads the metadata of a Stata 13 or later file, release 117 to
+// 119, whose sections are delimited by tags and located by a map.
+func (d *stataReader) readXML() error {
+	if err := d.expect("<header><release>"); err != nil {
+		return err
+	}
+
+	b, err := d.read(3)
+	if err != nil {
+		return err
+	}
+
+	d.release, _ = strconv.Atoi(string(b))
+	if d.release < 117 || d.release > 119 {
+		return fmt.Errorf("unsupported release %s", b)
+	}
+
+	if err := d.expect("</release><byteorder>"); err != nil {
+		return err
+	}
+
+	if b, err = d.read(3); err != nil {
+		return err
+	}
+
+	switch string(b) {
+	case "MSF":
+		d.bo = binary.BigEndian
+	case "LSF":
+		d.bo = binary.LittleEndian
+	default:
+		return fmt.Errorf("unknown byte order %s", b)
+	}
+
+	// Sizes of fields that differ between releases.
+	kLen, nLen, labelLen, nameLen, oLen := 2, 8, 2, 129, 8
+	if d.release == 117 {
+		nLen, labelLen, nameLen, oLen = 4, 1, 33, 4
+	}
+	if d.release == 119 {
+		kLen = 4
+	}
+
+	if err := d.expect("</byteorder><K>"); err != nil {
+		return err
+	}
+	k, err := d.uint(kLen)
+	if err != nil {
+		return err
+	}
+
+	if err := d.expect("</K><N>"); err != nil {
+		return err
+	}
+	n, err := d.uint(nLen)
+	if err != nil {
+		return err
+	}
+	d.nobs = int64(n)
+
+	if err := d.expect("</N><label>"); err != nil {
+		return err
+	}
+	ln, err := d.uint(labelLen)
+	if err != nil {
+		return err
+	}
+	if _, err := d.read(int(ln)); err != nil {
+		return err
+	}
+
+	if err := d.expect("</label><timestamp>"); err != nil {
+		return err
+	}
+	ln, err = d.uint(1)
+	if err != nil {
+		return err
+	}
+	if _, err := d.read(int(ln)); err != nil {
+		return err
+	}
+
+	if err := d.expect("</timestamp></header><map>"); err != nil {
+		return err
+	}
+
+	offsets := make([]int64, 14)
+	for i := range offsets {
+		o, err := d.uint(8)
+		if err != nil {
+			return err
+		}
+		offsets[i] = int64(o)
+	}
+
+	// Variable types.
+	if err := d.section(offsets[2], "<variable_types>"); err != nil {
+		return err
+	}
+
+	d.vars = make([]*stataVar, k)
+	for i := range d.vars {
+		t, err := d.uint(2)
+		if err != nil {
+			return err
+		}
+		d.vars[i] = &stataVar{typ: int(t)}
+	}
+
+	// Variable names.
+	if err := d.section(offsets[3], "<varnames>"); err != nil {
+		return err
+	}
+
+	names, err := d.strs(int(k), nameLen)
+	if err != nil {
+		return err
+	}
+
+	// Names of value label tables.
+	if err := d.section(offsets[6], "<value_label_names>"); err != nil {
+		return err
+	}
+
+	tables, err := d.strs(int(k), nameLen)
+	if err != nil {
+		return err
+	}
+
+	for i, v := range d.vars {
+		v.name = names[i]
+		v.labels = tables[i]
+	}
+
+	// Long strings.
+	if err := d.section(offsets[10], "<strls>"); err != nil {
+		return err
+	}
+	if err := d.readStrLs(oLen); err != nil {
+		return fmt.Errorf("strls: %s", err)
+	}
+
+	// Value labels.
+	if err := d.section(offsets[11], "<value_labels>"); err != nil {
+		return err
+	}
+
+	for {
+		b, err := d.read(5)
+		if err != nil {
+			return err
+		}
+		if string(b) != "<lbl>" {
+			break
+		}
+
+		ln, err := d.uint(4)
+		if err != nil {
+			return err
+		}
+
+		name, err := d.strs(1, nameLen)
+		if err != nil {
+			return err
+		}
+
+		// Padding.
+		if _, err := d.read(3); err != nil {
+			return err
+		}
+
+		if err := d.readLabels(name[0], int(ln)); err != nil {
+			return fmt.Errorf("value labels `%s`: %s", name[0], err)
+		}
+
+		if err := d.expect("</lbl>"); err != nil {
+			return err
+		}
+	}
+
+	d.data = offsets[9] + int64(len("<data>"))
+
+	return nil
+}
+
+// section seeks to the section at the offset.
+func (d *stataReader) section(off int64, tag string) error {
+	if err := d.seek(off); err != nil {
+		return err
+	}
+	return d.expect(tag)
+}
+
+// readStrLs reads the long strings, each stored as a GSO record.
+func (d *stataReader) readStrLs(oLen int) error {
+	for {
+		b, err := d.read(3)
+		if err != nil {
+			return err
+		}
+		if string(b) != "GSO" {
+			return nil
+		}
+
+		v, err := d.uint(4)
+		if err != nil {
+			return err
+		}
+		o, err := d.uint(oLen)
+		if err != nil {
+			return err
+		}
+		t, err := d.uint(1)
+		if err != nil {
+			return err
+		}
+		n, err := d.uint(4)
+		if err != nil {
+			return err
+		}
+
+		s, err := d.read(int(n))
+		if err != nil {
+			return err
+		}
+
+		// Text is null-terminated, binary is not.
+		if t == 130 {
+			d.strls[[2]uint64{v, o}] = d.decode(stataCString(s))
+		} else {
+			d.strls[[2]uint64{v, o}] = string(s)
+		}
+	}
+}
+
+// readLabels reads a value label table of n bytes.
+func (d *stataReader) readLabels(name string, n int) error {
+	b, err := d.read(n)
+	if err != nil {
+		return err
+	}
+
+	if len(b) < 8 {
+		return errors.New("table too short")
+	}
+
+	count := int(d.bo.Uint32(b))
+	txtLen := int(d.bo.Uint32(b[4:]))
+
+	if 8+8*count+txtLen > len(b) {
+		return errors.New("table too short")
+	}
+
+	offs := b[8 : 8+4*count]
+	vals := b[8+4*count : 8+8*count]
+	txt := b[8+8*count : 8+8*count+txtLen]
+
+	labels := make(map[int64]string, count)
+
+	for i := 0; i < count; i++ {
+		off := int(d.bo.Uint32(offs[4*i:]))
+		if off >= len(txt) {
+			return errors.New("label out of bounds")
+		}
+
+		val := int32(d.bo.Uint32(vals[4*i:]))
+		labels[int64(val)] = d.decode(stataCString(txt[off:]))
+	}
+
+	d.labels[name] = labels
+
+	return nil
+}
+
+// readBinary reads the metadata of a Stata 8 to 12 file, release 113 to
+// 115. Value labels are read from the end of the file.
+func (d *stataReader) readBinary() error {
+	h, err := d.read(109)
+	if err != nil {
+		return fmt.Errorf("header: %s", err)
+	}
+
+	d.release = int(h[0])
+	if d.release < 113 || d.release > 115 {
+		return fmt.Errorf("unsupported release %d", d.release)
+	}
+
+	switch h[1] {
+	case 1:
+		d.bo = binary.BigEndian
+	case 2:
+		d.bo = binary.LittleEndian
+	default:
+		return fmt.Errorf("unknown byte order %d", h[1])
+	}
+
+	k := int(d.bo.Uint16(h[4:]))
+	d.nobs = int64(d.bo.Uint32(h[6:]))
+
+	types, err := d.read(k)
+	if err != nil {
+		return err
+	}
+
+	names, err := d.strs(k, 33)
+	if err != nil {
+		return err
+	}
+
+	fmtLen := 49
+	if d.release == 113 {
+		fmtLen = 12
+	}
+
+	// Sort list and formats.
+	if _, err := d.read(2*(k+1) + k*fmtLen); err != nil {
+		return err
+	}
+
+	tables, err := d.strs(k, 33)
+	if err != nil {
+		return err
+	}
+
+	// Variable labels.
+	if _, err := d.read(81 * k); err != nil {
+		return err
+	}
+
+	d.vars = make([]*stataVar, k)
+	for i := range d.vars {
+		t := int(types[i])
+
+		switch t {
+		case 251:
+			t = stataByte
+		case 252:
+			t = stataInt
+		case 253:
+			t = stataLong
+		case 254:
+			t = stataFloat
+		case 255:
+			t = stataDouble
+		}
+
+		d.vars[i] = &stataVar{
+			name:   names[i],
+			typ:    t,
+			labels: tables[i],
+		}
+	}
+
+	// Expansion fields end with a field of type 0 and length 0.
+	for {
+		t, err := d.uint(1)
+		if err != nil {
+			return err
+		}
+		n, err := d.uint(4)
+		if err != nil {
+			return err
+		}
+		if t == 0 && n == 0 {
+			break
+		}
+		if _, err := d.r.Seek(int64(n), io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+
+	if d.data, err = d.r.Seek(0, io.SeekCurrent); err != nil {
+		return err
+	}
+
+	var rowLen int64
+	for _, v := range d.vars {
+		w, err := stataWidth(v.typ)
+		if err != nil {
+			return fmt.Errorf("variable `%s`: %s", v.name, err)
+		}
+		rowLen += int64(w)
+	}
+
+	if err := d.seek(d.data + d.nobs*rowLen); err != nil {
+		return err
+	}
+
+	for {
+		ln, err := d.uint(4)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name, err := d.strs(1, 33)
+		if err != nil {
+			return err
+		}
+
+		// Padding.
+		if _, err := d.read(3); err != nil {
+			return err
+		}
+
+		if err := d.readLabels(name[0], int(ln)); err != nil {
+			return fmt.Errorf("value labels `%s`: %s", name[0], err)
+		}
+	}
+}
+
+// value returns the value of the variable in the row. Missing values,
+// including extended missing values such as .a, are nil.
+func (d *stataReader) value(v *stataVar, row []byte) interface{} {
+	b := row[v.offset : v.offset+v.width]
+
+	switch v.typ {
+	case stataByte:
+		if x := int8(b[0]); x <= 100 {
+			return int64(x)
+		}
+	case stataInt:
+		if x := int16(d.bo.Uint16(b)); x <= 32740 {
+			return int64(x)
+		}
+	case stataLong:
+		if x := int32(d.bo.Uint32(b)); x <= 2147483620 {
+			return int64(x)
+		}
+	case stataFloat:
+		if x := d.bo.Uint32(b); int32(x) <= 0x7effffff {
+			return math.Float32frombits(x)
+		}
+	case stataDouble:
+		if x := d.bo.Uint64(b); int64(x) <= 0x7fdfffffffffffff {
+			return math.Float64frombits(x)
+		}
+	case stataStrL:
+		var key [2]uint64
+		switch d.release {
+		case 117:
+			key = [2]uint64{uint64(d.bo.Uint32(b)), uint64(d.bo.Uint32(b[4:]))}
+		default:
+			// The variable is stored in 2 bytes and the observation in 6,
+			// or in 3 and 5 bytes in release 119.
+			vBits := uint(16)
+			if d.release == 119 {
+				vBits = 24
+			}
+			x := d.bo.Uint64(b)
+			if d.bo == binary.BigEndian {
+				key = [2]uint64{x >> (64 - vBits), x & (1<<(64-vBits) - 1)}
+			} else {
+				key = [2]uint64{x & (1<<vBits - 1), x >> vBits}
+			}
+		}
+		// A zero key is an empty string.
+		return d.strls[key]
+	default:
+		return d.decode(stataCString(b))
+	}
+
+	return nil
+}
+
+// StataTable returns a table of the observations of a Stata dataset using
+// the default options.
+func StataTable(r io.ReadSeeker, key []string, renames map[string]string) (Table, error) {
+	return StataTableWithOptions(r, key, renames, StataOptions{})
+}
+
+// StataTableWithOptions returns a table of the observations of a Stata
+// dataset (.dta) written by Stata 8 or later. Since long strings and value
+// labels are stored after the observations, they are read into memory
+// first, which requires seeking.
+//
+// Columns are typed by their storage type: byte, int, long, float, double,
+// strL or str followed by the length, such as str12. Integers are returned
+// as int64 values and missing values are null.
+func StataTableWithOptions(r io.ReadSeeker, key []string, renames map[string]string, opts StataOptions) (Table, error) {
+	d, err := newStataReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.seek(d.data); err != nil {
+		return nil, err
+	}
+
+	for i, k := range key {
+		if n, ok := renames[k]; ok {
+			key[i] = n
+		}
+	}
+
+	t := &stataTable{
+		d:       d,
+		br:      bufio.NewReader(r),
+		key:     key,
+		opts:    opts,
+		cols:    make(map[string]string, len(d.vars)),
+		colIdxs: make(map[string]int, len(d.vars)),
+		row:     make([]byte, d.rowLen),
+	}
+
+	for i, v := range d.vars {
+		name := v.name
+		if n, ok := renames[name]; ok {
+			name = n
+		}
+
+		if _, ok := t.colIdxs[name]; ok {
+			return nil, fmt.Errorf("duplicate column `%s`", name)
+		}
+
+		typ := stataType(v.typ)
+
+		t.cols[name] = typ
+		t.colIdxs[name] = i
+
+		col := &Column{
+			Name:        name,
+			Type:        typ,
+			Nullable:    v.typ > stataStrL,
+			HasNullable: true,
+		}
+		if v.typ < stataStrL {
+			col.Length = int64(v.typ)
+			col.HasLength = true
+		}
+
+		t.colInfo = append(t.colInfo, col)
+	}
+
+	return t, nil
+}
+
+type stataTable struct {
+	d    *stataReader
+	br   *bufio.Reader
+	key  []string
+	opts StataOptions
+
+	cols    map[string]string
+	colInfo []*Column
+	colIdxs map[string]int
+
+	// Number of observations read.
+	obs int64
+	row []byte
+}
+
+func (t *stataTable) Key() []string {
+	return t.key
+}
+
+func (t *stataTable) Cols() map[string]string {
+	return t.cols
+}
+
+// Columns returns the variables in dataset order. Numeric variables are
+// nullable and fixed-length strings have their length.
+func (t *stataTable) Columns() []*Column {
+	return t.colInfo
+}
+
+// Comparator orders numeric variables by value.
+func (t *stataTable) Comparator(col string) Comparator {
+	i, ok := t.colIdxs[col]
+	if !ok {
+		return nil
+	}
+
+	switch t.d.vars[i].typ {
+	case stataByte, stataInt, stataLong:
+		return CompareNumeric
+	case stataFloat, stataDouble:
+		return CompareFloat
+	}
+
+	return nil
+}
+
+func (t *stataTable) Row() Row {
+	return &stataRow{
+		t:   t,
+		row: t.row,
+	}
+}
+
+func (t *stataTable) Next() (bool, error) {
+	if t.obs >= t.d.nobs {
+		return false, nil
+	}
+
+	if _, err := io.ReadFull(t.br, t.row); err != nil {
+		return false, fmt.Errorf("observation %d: %s", t.obs+1, err)
+	}
+
+	t.obs++
+
+	return true, nil
+}
+
+type stataRow struct {
+	t   *stataTable
+	row []byte
+}
+
+func (r *stataRow) Bytes(col string) []byte {
+	i, ok := r.t.colIdxs[col]
+	if !ok {
+		return nil
+	}
+
+	v := r.t.d.vars[i]
+
+	return datasetBytes(stataType(v.typ), r.t.d.value(v, r.row))
+}
+
+// Value returns the value of the column or, if value labels are applied,
+// its label.
+func (r *stataRow) Value(col string) interface{} {
+	i, ok := r.t.colIdxs[col]
+	if !ok {
+		return nil
+	}
+
+	v := r.t.d.vars[i]
+	x := r.t.d.value(v, r.row)
+
+	if !r.t.opts.ValueLabels || v.labels == "" {
+		return x
+	}
+
+	var code int64
+
+	switch n := x.(type) {
+	case int64:
+		code = n
+	case float32:
+		if float32(int64(n)) != n {
+			return x
+		}
+		code = int64(n)
+	case float64:
+		if float64(int64(n)) != n {
+			return x
+		}
+		code = int64(n)
+	default:
+		return x
+	}
+
+	if l, ok := r.t.d.labels[v.labels][code]; ok {
+		return l
+	}
+
+	return x
+}
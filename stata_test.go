@@ -0,0 +1,408 @@
+package difftable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"testing"
+)
+
+type stataTestVar struct {
+	name   string
+	typ    int
+	labels string
+}
+
+// writeStata writes a Stata dataset of the release, 114, 118 or 119. Nil
+// values are written as missing and strL values are stored as GSOs.
+func writeStata(t *testing.T, release int, bo binary.ByteOrder, vars []stataTestVar, rows [][]interface{}, labels map[string]map[int32]string) []byte {
+	var (
+		data  bytes.Buffer
+		strls bytes.Buffer
+	)
+
+	for obs, r := range rows {
+		for i, v := range vars {
+			b := make([]byte, 8)
+
+			switch v.typ {
+			case stataByte:
+				if r[i] == nil {
+					data.WriteByte(101)
+				} else {
+					data.WriteByte(byte(int8(r[i].(int))))
+				}
+			case stataInt:
+				x := uint16(32741)
+				if r[i] != nil {
+					x = uint16(int16(r[i].(int)))
+				}
+				bo.PutUint16(b, x)
+				data.Write(b[:2])
+			case stataLong:
+				x := uint32(2147483621)
+				if r[i] != nil {
+					x = uint32(int32(r[i].(int)))
+				}
+				bo.PutUint32(b, x)
+				data.Write(b[:4])
+			case stataDouble:
+				x := uint64(0x7fe0000000000000)
+				if r[i] != nil {
+					x = math.Float64bits(r[i].(float64))
+				}
+				bo.PutUint64(b, x)
+				data.Write(b)
+			case stataStrL:
+				s := r[i].(string)
+				if s == "" {
+					data.Write(b)
+					continue
+				}
+
+				// The variable is stored in the first 2 bytes, or 3 in
+				// release 119.
+				vBits := uint(16)
+				if release == 119 {
+					vBits = 24
+				}
+				if bo == binary.BigEndian {
+					bo.PutUint64(b, uint64(i+1)<<(64-vBits)|uint64(obs+1))
+				} else {
+					bo.PutUint64(b, uint64(obs+1)<<vBits|uint64(i+1))
+				}
+				data.Write(b)
+
+				strls.WriteString("GSO")
+				binary.Write(&strls, bo, uint32(i+1))
+				binary.Write(&strls, bo, uint64(obs+1))
+				strls.WriteByte(130)
+				binary.Write(&strls, bo, uint32(len(s)+1))
+				strls.WriteString(s)
+				strls.WriteByte(0)
+			default:
+				s := make([]byte, v.typ)
+				copy(s, r[i].(string))
+				data.Write(s)
+			}
+		}
+	}
+
+	nameLen := 129
+	if release < 117 {
+		nameLen = 33
+	}
+
+	str := func(w *bytes.Buffer, s string, n int) {
+		b := make([]byte, n)
+		copy(b, s)
+		w.Write(b)
+	}
+
+	// Value label tables sorted by name.
+	var names []string
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var lbls bytes.Buffer
+	for _, n := range names {
+		var (
+			vals []int
+			txt  bytes.Buffer
+			tbl  bytes.Buffer
+		)
+		for v := range labels[n] {
+			vals = append(vals, int(v))
+		}
+		sort.Ints(vals)
+
+		binary.Write(&tbl, bo, uint32(len(vals)))
+		for _, v := range vals {
+			txt.WriteString(labels[n][int32(v)])
+			txt.WriteByte(0)
+		}
+		binary.Write(&tbl, bo, uint32(txt.Len()))
+
+		off := 0
+		for _, v := range vals {
+			binary.Write(&tbl, bo, uint32(off))
+			off += len(labels[n][int32(v)]) + 1
+		}
+		for _, v := range vals {
+			binary.Write(&tbl, bo, int32(v))
+		}
+		tbl.Write(txt.Bytes())
+
+		if release >= 117 {
+			lbls.WriteString("<lbl>")
+		}
+		binary.Write(&lbls, bo, uint32(tbl.Len()))
+		str(&lbls, n, nameLen)
+		lbls.Write(make([]byte, 3))
+		lbls.Write(tbl.Bytes())
+		if release >= 117 {
+			lbls.WriteString("</lbl>")
+		}
+	}
+
+	var out bytes.Buffer
+
+	if release < 117 {
+		order := byte(2)
+		if bo == binary.BigEndian {
+			order = 1
+		}
+
+		out.Write([]byte{byte(release), order, 1, 0})
+		binary.Write(&out, bo, uint16(len(vars)))
+		binary.Write(&out, bo, uint32(len(rows)))
+		out.Write(make([]byte, 81+18))
+
+		for _, v := range vars {
+			switch v.typ {
+			case stataByte:
+				out.WriteByte(251)
+			case stataInt:
+				out.WriteByte(252)
+			case stataLong:
+				out.WriteByte(253)
+			case stataDouble:
+				out.WriteByte(255)
+			default:
+				out.WriteByte(byte(v.typ))
+			}
+		}
+		for _, v := range vars {
+			str(&out, v.name, 33)
+		}
+		out.Write(make([]byte, 2*(len(vars)+1)+49*len(vars)))
+		for _, v := range vars {
+			str(&out, v.labels, 33)
+		}
+		out.Write(make([]byte, 81*len(vars)+5))
+
+		out.Write(data.Bytes())
+		out.Write(lbls.Bytes())
+
+		return out.Bytes()
+	}
+
+	order := "LSF"
+	if bo == binary.BigEndian {
+		order = "MSF"
+	}
+
+	fmt.Fprintf(&out, "<stata_dta><header><release>%d</release><byteorder>%s</byteorder><K>", release, order)
+	if release == 119 {
+		binary.Write(&out, bo, uint32(len(vars)))
+	} else {
+		binary.Write(&out, bo, uint16(len(vars)))
+	}
+	out.WriteString("</K><N>")
+	binary.Write(&out, bo, uint64(len(rows)))
+	out.WriteString("</N><label>")
+	binary.Write(&out, bo, uint16(0))
+	out.WriteString("</label><timestamp>\x00</timestamp></header>")
+
+	var (
+		offsets = make([]uint64, 14)
+		body    bytes.Buffer
+	)
+
+	// The map is 14 offsets between the tags.
+	mapOff := out.Len()
+	base := mapOff + 5 + 14*8 + 6
+
+	section := func(i int, s []byte) {
+		offsets[i] = uint64(base + body.Len())
+		body.Write(s)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("<variable_types>")
+	for _, v := range vars {
+		binary.Write(&b, bo, uint16(v.typ))
+	}
+	b.WriteString("</variable_types>")
+	section(2, b.Bytes())
+
+	b.Reset()
+	b.WriteString("<varnames>")
+	for _, v := range vars {
+		str(&b, v.name, nameLen)
+	}
+	b.WriteString("</varnames>")
+	section(3, b.Bytes())
+
+	b.Reset()
+	b.WriteString("<sortlist>")
+	b.Write(make([]byte, 2*(len(vars)+1)))
+	b.WriteString("</sortlist>")
+	section(4, b.Bytes())
+
+	b.Reset()
+	b.WriteString("<formats>")
+	b.Write(make([]byte, 57*len(vars)))
+	b.WriteString("</formats>")
+	section(5, b.Bytes())
+
+	b.Reset()
+	b.WriteString("<value_label_names>")
+	for _, v := range vars {
+		str(&b, v.labels, nameLen)
+	}
+	b.WriteString("</value_label_names>")
+	section(6, b.Bytes())
+
+	b.Reset()
+	b.WriteString("<variable_labels>")
+	b.Write(make([]byte, 321*len(vars)))
+	b.WriteString("</variable_labels>")
+	section(7, b.Bytes())
+
+	section(8, []byte("<characteristics></characteristics>"))
+	section(9, []byte("<data>"+data.String()+"</data>"))
+	section(10, []byte("<strls>"+strls.String()+"</strls>"))
+	section(11, []byte("<value_labels>"+lbls.String()+"</value_labels>"))
+	section(12, []byte("</stata_dta>"))
+	offsets[13] = uint64(base + body.Len())
+	offsets[1] = uint64(mapOff)
+
+	out.WriteString("<map>")
+	for _, o := range offsets {
+		binary.Write(&out, bo, o)
+	}
+	out.WriteString("</map>")
+	out.Write(body.Bytes())
+
+	return out.Bytes()
+}
+
+func TestStataTable(t *testing.T) {
+	vars := []stataTestVar{
+		{name: "id", typ: stataLong},
+		{name: "name", typ: 6},
+		{name: "note", typ: stataStrL},
+		{name: "score", typ: stataDouble},
+		{name: "grade", typ: stataByte, labels: "grades"},
+	}
+
+	rows := [][]interface{}{
+		{1, "José", "a long note", 1.5, 1},
+		{2, "", "", nil, nil},
+		{10, "Sam", "another note", -3.0, 3},
+	}
+
+	labels := map[string]map[int32]string{
+		"grades": {1: "low", 2: "high"},
+	}
+
+	expectedRows := []map[string]interface{}{
+		{"id": "1", "full_name": "José", "note": "a long note", "score": "1.5", "grade": "1"},
+		{"id": "2", "full_name": "", "note": "", "score": nil, "grade": nil},
+		{"id": "10", "full_name": "Sam", "note": "another note", "score": "-3", "grade": "3"},
+	}
+
+	for _, test := range []struct {
+		release int
+		bo      binary.ByteOrder
+	}{
+		{118, binary.LittleEndian},
+		{118, binary.BigEndian},
+		{119, binary.LittleEndian},
+		{119, binary.BigEndian},
+	} {
+		bo := test.bo
+		data := writeStata(t, test.release, bo, vars, rows, labels)
+
+		tb, err := StataTable(bytes.NewReader(data), []string{"id"}, map[string]string{"name": "full_name"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{
+			"id":        "long",
+			"full_name": "str6",
+			"note":      "strL",
+			"score":     "double",
+			"grade":     "byte",
+		}
+
+		if s1, s2, ok := jsonEqual(expected, tb.Cols()); !ok {
+			t.Errorf("column types don't match. expected:\n%s\ngot:\n%s", s1, s2)
+		}
+
+		if s1, s2, ok := jsonEqual(expectedRows, datasetRows(t, tb, false)); !ok {
+			t.Errorf("unexpected rows (release %d, %s). expected:\n%s\ngot:\n%s", test.release, bo, s1, s2)
+		}
+	}
+}
+
+func TestStataTableValueLabels(t *testing.T) {
+	vars := []stataTestVar{
+		{name: "id", typ: stataInt},
+		{name: "name", typ: 5},
+		{name: "grade", typ: stataByte, labels: "grades"},
+	}
+
+	rows := [][]interface{}{
+		{1, "Pam", 1},
+		{2, "Sam", 3},
+		{3, "Kim", nil},
+	}
+
+	labels := map[string]map[int32]string{
+		"grades": {1: "low", 2: "high"},
+	}
+
+	for _, release := range []int{114, 118} {
+		data := writeStata(t, release, binary.BigEndian, vars, rows, labels)
+
+		tb, err := StataTableWithOptions(bytes.NewReader(data), []string{"id"}, nil, StataOptions{ValueLabels: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []map[string]interface{}{
+			{"id": 1, "name": "Pam", "grade": "low"},
+			{"id": 2, "name": "Sam", "grade": 3},
+			{"id": 3, "name": "Kim", "grade": nil},
+		}
+
+		if s1, s2, ok := jsonEqual(expected, datasetRows(t, tb, true)); !ok {
+			t.Errorf("unexpected values (release %d). expected:\n%s\ngot:\n%s", release, s1, s2)
+		}
+
+		// Rows are compared on the values, not the labels.
+		tb, err = StataTableWithOptions(bytes.NewReader(data), []string{"id"}, nil, StataOptions{ValueLabels: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tb.Next()
+		if b := string(tb.Row().Bytes("grade")); b != "1" {
+			t.Errorf("expected grade 1, got %s", b)
+		}
+	}
+}
+
+func TestStataTableInvalid(t *testing.T) {
+	if _, err := StataTable(bytes.NewReader([]byte("<stata_dta><header><release>120</release>")), nil, nil); err == nil {
+		t.Error("expected an error for an unsupported release")
+	}
+
+	if _, err := StataTable(bytes.NewReader(make([]byte, 200)), nil, nil); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+}
+
+func TestStataTableFixtures(t *testing.T) {
+	testFixtures(t, "stata/*.dta", func(f *os.File) (Table, error) {
+		return StataTable(f, nil, nil)
+	})
+}
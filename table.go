@@ -2,8 +2,10 @@ package difftable
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 )
 
@@ -21,10 +23,55 @@ type Row interface {
 	Value(col string) interface{}
 }
 
+// CanonicalRow is an optional interface a Row can implement to return the
+// canonical encoding of its values, see AppendCanonical. When the rows of
+// both tables implement it, values are compared by their canonical encoding
+// so values of different types, such as 1 and "1", are never equal.
+// Otherwise values are compared by Bytes.
+type CanonicalRow interface {
+	CanonicalBytes(col string) []byte
+}
+
+// valuesEqual reports whether the values of the columns of two rows are
+// equal, comparing their canonical encoding if both rows have one.
+func valuesEqual(r1 Row, c1 string, r2 Row, c2 string) bool {
+	if cr1, ok := r1.(CanonicalRow); ok {
+		if cr2, ok := r2.(CanonicalRow); ok {
+			return bytes.Equal(cr1.CanonicalBytes(c1), cr2.CanonicalBytes(c2))
+		}
+	}
+
+	return bytes.Equal(r1.Bytes(c1), r2.Bytes(c2))
+}
+
+// Column describes a column in more detail than the type name returned by
+// Table.Cols.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	Nullable    bool `json:"nullable,omitempty"`
+	HasNullable bool `json:"-"`
+
+	Precision         int64 `json:"precision,omitempty"`
+	Scale             int64 `json:"scale,omitempty"`
+	HasPrecisionScale bool  `json:"-"`
+
+	Length    int64 `json:"length,omitempty"`
+	HasLength bool  `json:"-"`
+}
+
+// ColumnDescriber is an optional interface a Table can implement to provide
+// detailed column metadata.
+type ColumnDescriber interface {
+	Columns() []*Column
+}
+
 type TableDiff struct {
 	TotalRows   int64                    `json:"total_rows"`
 	ColsAdded   []string                 `json:"columns_added"`
 	ColsDropped []string                 `json:"columns_dropped"`
+	ColsRenamed map[string]string        `json:"columns_renamed,omitempty"`
 	TypeChanges map[string]*TypeChange   `json:"type_changes"`
 	RowsAdded   int                      `json:"rows_added"`
 	RowsDeleted int                      `json:"rows_deleted"`
@@ -61,23 +108,75 @@ const (
 	EventRowChanged    = "row-changed"
 	EventRowRemoved    = "row-removed"
 	EventRowStored     = "row-stored"
+
+	// Schema events emitted for tables implementing SchemaDiffer, except
+	// nullability-changed, which is also emitted for tables describing
+	// their columns, see ColumnDescriber. Events about a field nested in a
+	// column set Field to its path, e.g. address.zip, with [] for the items
+	// of arrays and values of maps.
+	EventColumnRenamed  = "column-renamed"
+	EventNullability    = "nullability-changed"
+	EventDefaultChanged = "default-changed"
+	EventSymbolsChanged = "symbols-changed"
+	EventFieldAdded     = "field-added"
+	EventFieldChanged   = "field-changed"
+	EventFieldRemoved   = "field-removed"
+	EventFieldRenamed   = "field-renamed"
 )
 
 type Event struct {
-	Type    string                  `json:"type"`
-	Time    int64                   `json:"time"`
-	Offset  int64                   `json:"offset,omitempty"`
-	Column  string                  `json:"column,omitempty"`
-	OldType string                  `json:"old_type,omitempty"`
-	NewType string                  `json:"new_type,omitempty"`
+	Type      string `json:"type"`
+	Time      int64  `json:"time"`
+	Offset    int64  `json:"offset,omitempty"`
+	Column    string `json:"column,omitempty"`
+	OldColumn string `json:"old_column,omitempty"`
+	Field     string `json:"field,omitempty"`
+	OldField  string `json:"old_field,omitempty"`
+	OldType   string `json:"old_type,omitempty"`
+	NewType   string `json:"new_type,omitempty"`
+
+	OldNullable    *bool           `json:"old_nullable,omitempty"`
+	NewNullable    *bool           `json:"new_nullable,omitempty"`
+	OldDefault     json.RawMessage `json:"old_default,omitempty"`
+	NewDefault     json.RawMessage `json:"new_default,omitempty"`
+	SymbolsAdded   []string        `json:"symbols_added,omitempty"`
+	SymbolsRemoved []string        `json:"symbols_removed,omitempty"`
+
 	Key     map[string]interface{}  `json:"key,omitempty"`
 	Data    map[string]interface{}  `json:"data,omitempty"`
 	Changes map[string]*ValueChange `json:"changes,omitempty"`
 }
 
-func compareRows(r1, r2 [][]byte) int {
+// SchemaDiffer is an optional interface a Table can implement to report
+// how its schema evolved beyond the column types, such as nullability,
+// defaults or renamed columns. If both tables implement it, DiffSchema is
+// called on the new table with the schema of the old table, and the events
+// are emitted after the column events and before any row events.
+type SchemaDiffer interface {
+	// Schema returns the schema of the table. The value is only
+	// meaningful to tables of the same kind.
+	Schema() interface{}
+
+	// DiffSchema compares the schema of the table to the schema of an
+	// older table. It returns nil if the schema is of another kind.
+	DiffSchema(old interface{}) (*SchemaDiff, error)
+}
+
+// SchemaDiff describes changes to a schema.
+type SchemaDiff struct {
+	// Renamed maps new column names to the old names they were renamed
+	// from. Values of renamed columns are compared to the old column
+	// rather than the column being reported as removed and added.
+	Renamed map[string]string
+
+	// Events are the schema events in the order they are emitted.
+	Events []*Event
+}
+
+// compareRows compares two key tuples using the comparator of each key column.
+func compareRows(r1, r2 [][]byte, cmps []Comparator) int {
 	for i, v1 := range r1 {
-		if p := bytes.Compare(v1, r2[i]); p != 0 {
+		if p := cmps[i](v1, r2[i]); p != 0 {
 			return p
 		}
 	}
@@ -159,22 +258,202 @@ func Snapshot(t1 Table, h func(e *Event) error) error {
 	return nil
 }
 
-func DiffEvents(t1, t2 Table, h func(e *Event) error) error {
+// OrderPolicy determines how keys that are out of order or repeated are
+// handled while diffing.
+type OrderPolicy int
+
+const (
+	// OrderFail aborts the diff with an *OrderError.
+	OrderFail OrderPolicy = iota
+
+	// OrderWarn reports the *OrderError to DiffOptions.Warn and continues.
+	OrderWarn
+
+	// OrderIgnore does not check the key order.
+	OrderIgnore
+)
+
+// ParseOrderPolicy parses the name of an order policy.
+func ParseOrderPolicy(s string) (OrderPolicy, error) {
+	switch s {
+	case "fail":
+		return OrderFail, nil
+	case "warn":
+		return OrderWarn, nil
+	case "ignore":
+		return OrderIgnore, nil
+	}
+	return 0, fmt.Errorf("unknown order policy `%s`", s)
+}
+
+// DiffOptions are options for diffing tables.
+type DiffOptions struct {
+	// OrderPolicy determines how keys that are out of order or repeated
+	// are handled.
+	OrderPolicy OrderPolicy
+
+	// HashJoin matches rows using a hash index of table 1 rather than
+	// merging the tables in key order. See HashDiffEvents.
+	HashJoin bool
+
+	// Warn is called with errors when the OrderWarn policy is used.
+	// Defaults to logging the error.
+	Warn func(err error)
+}
+
+// OrderError describes a row whose key is out of order or a duplicate of
+// the previous row's key.
+type OrderError struct {
+	// Table is 1 or 2 denoting which table the row is from.
+	Table int
+
+	// Offset is the 1-based offset of the row in the table.
+	Offset int64
+
+	// Key of the offending row.
+	Key map[string]interface{}
+
+	// Duplicate is true if the key is the same as the previous row's key,
+	// otherwise it sorts before it.
+	Duplicate bool
+}
+
+func (e *OrderError) Error() string {
+	if e.Duplicate {
+		return fmt.Sprintf("table %d: duplicate key %v at row %d", e.Table, e.Key, e.Offset)
+	}
+	return fmt.Sprintf("table %d: key %v at row %d is out of order", e.Table, e.Key, e.Offset)
+}
+
+// orderChecker tracks the previous key of a table to check rows are
+// sorted and unique on the key.
+type orderChecker struct {
+	table  int
+	key    []string
+	cmps   []Comparator
+	prev   [][]byte
+	offset int64
+}
+
+func newOrderChecker(table int, key []string, cmps []Comparator) *orderChecker {
+	return &orderChecker{
+		table: table,
+		key:   key,
+		cmps:  cmps,
+	}
+}
+
+// check compares the key of the current row to the previous one.
+func (c *orderChecker) check(r Row, k [][]byte) error {
+	c.offset++
+
+	var err error
+
+	if c.prev != nil {
+		if p := compareRows(c.prev, k, c.cmps); p >= 0 {
+			err = &OrderError{
+				Table:     c.table,
+				Offset:    c.offset,
+				Key:       newKeyMap(r, c.key),
+				Duplicate: p == 0,
+			}
+		}
+	} else {
+		c.prev = make([][]byte, len(k))
+	}
+
+	// Copy in case the table reuses the underlying buffers, keeping nulls
+	// distinct from empty values.
+	for i, v := range k {
+		switch {
+		case v == nil:
+			c.prev[i] = nil
+		case c.prev[i] == nil:
+			c.prev[i] = append([]byte{}, v...)
+		default:
+			c.prev[i] = append(c.prev[i][:0], v...)
+		}
+	}
+
+	return err
+}
+
+// warn returns the function warnings are reported to.
+func (o *DiffOptions) warn() func(err error) {
+	if o.Warn != nil {
+		return o.Warn
+	}
+	return func(err error) {
+		log.Print(err)
+	}
+}
+
+// columnDiff describes how the columns of two tables relate.
+type columnDiff struct {
+	key1  []string
+	key2  []string
+	cols1 map[string]string
+	cols2 map[string]string
+
+	// Columns to check when comparing rows.
+	cmpCols  []string
+	dropCols []string
+	newCols  []string
+
+	// Old names of renamed columns keyed by the new name.
+	renamed map[string]string
+}
+
+// diffSchema returns the schema changes if both tables implement
+// SchemaDiffer. Renames of columns that are not in both tables under the
+// new and old names respectively are ignored.
+func diffSchema(t1, t2 Table, cols1, cols2 map[string]string) (*SchemaDiff, error) {
+	sd1, ok1 := t1.(SchemaDiffer)
+	sd2, ok2 := t2.(SchemaDiffer)
+	if !ok1 || !ok2 {
+		return nil, nil
+	}
+
+	old := sd1.Schema()
+	if old == nil {
+		return nil, nil
+	}
+
+	diff, err := sd2.DiffSchema(old)
+	if err != nil || diff == nil {
+		return nil, err
+	}
+
+	for c2, c1 := range diff.Renamed {
+		_, ok1 := cols1[c1]
+		_, ok2 := cols2[c2]
+		_, dup1 := cols1[c2]
+		_, dup2 := cols2[c1]
+
+		if !ok1 || !ok2 || dup1 || dup2 {
+			delete(diff.Renamed, c2)
+		}
+	}
+
+	return diff, nil
+}
+
+// diffColumns validates the keys of the tables, emits the column-level
+// events and determines the columns to compare.
+func diffColumns(t1, t2 Table, ts int64, h func(e *Event) error) (*columnDiff, error) {
 	key1 := t1.Key()
 	key2 := t2.Key()
 
 	if len(key1) != len(key2) {
-		return errors.New("keys are different lengths")
+		return nil, errors.New("keys are different lengths")
 	}
 
 	keyLen := len(key1)
 
 	if keyLen == 0 {
-		return errors.New("a key must be provided")
+		return nil, errors.New("a key must be provided")
 	}
 
-	ts := time.Now().Unix()
-
 	cols1 := t1.Cols()
 	cols2 := t2.Cols()
 
@@ -183,7 +462,7 @@ func DiffEvents(t1, t2 Table, h func(e *Event) error) error {
 	key1Set := make(map[string]struct{}, keyLen)
 	for _, c := range key1 {
 		if _, ok := cols1[c]; !ok {
-			return fmt.Errorf("table 1 does not have key column `%s`", c)
+			return nil, fmt.Errorf("table 1 does not have key column `%s`", c)
 		}
 		key1Set[c] = struct{}{}
 	}
@@ -191,26 +470,63 @@ func DiffEvents(t1, t2 Table, h func(e *Event) error) error {
 	key2Set := make(map[string]struct{}, keyLen)
 	for _, c := range key2 {
 		if _, ok := cols2[c]; !ok {
-			return fmt.Errorf("table 2 does not have key column `%s`", c)
+			return nil, fmt.Errorf("table 2 does not have key column `%s`", c)
 		}
 		key2Set[c] = struct{}{}
 	}
 
-	// Columns to check when comparing rows.
-	var (
-		cmpCols  []string
-		dropCols []string
-		newCols  []string
-	)
+	sdiff, err := diffSchema(t1, t2, cols1, cols2)
+	if err != nil {
+		return nil, fmt.Errorf("schema: %s", err)
+	}
+
+	cd := &columnDiff{
+		key1:    key1,
+		key2:    key2,
+		cols1:   cols1,
+		cols2:   cols2,
+		renamed: make(map[string]string),
+	}
+
+	// Old names of renamed columns.
+	renamedFrom := make(map[string]string)
+
+	if sdiff != nil {
+		for c2, c1 := range sdiff.Renamed {
+			cd.renamed[c2] = c1
+			renamedFrom[c1] = c2
+		}
+	}
 
 	for c, ty1 := range cols1 {
+		// Renamed columns are compared under the new name.
+		if c2, ok := renamedFrom[c]; ok {
+			_, ok1 := key1Set[c]
+			_, ok2 := key2Set[c2]
+			if !(ok1 && ok2) {
+				cd.cmpCols = append(cd.cmpCols, c2)
+			}
+
+			if err := h(&Event{
+				Type:      EventColumnRenamed,
+				Time:      ts,
+				Column:    c2,
+				OldColumn: c,
+				OldType:   ty1,
+				NewType:   cols2[c2],
+			}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
 		// Both exist check for type changes.
 		if ty2, ok := cols2[c]; ok {
 			// Not a shared key column. Mark for comparison.
 			_, ok1 := key1Set[c]
 			_, ok2 := key2Set[c]
 			if !(ok1 && ok2) {
-				cmpCols = append(cmpCols, c)
+				cd.cmpCols = append(cd.cmpCols, c)
 			}
 
 			// Emit the type difference.
@@ -222,41 +538,195 @@ func DiffEvents(t1, t2 Table, h func(e *Event) error) error {
 					OldType: ty1,
 					NewType: ty2,
 				}); err != nil {
-					return err
+					return nil, err
 				}
 			}
 
 			// Does not exist in new cols. Mark as dropped.
 		} else {
-			dropCols = append(dropCols, c)
+			cd.dropCols = append(cd.dropCols, c)
 
 			if err := h(&Event{
-				Type:   EventColumnRemoved,
-				Time:   ts,
-				Column: c,
+				Type:    EventColumnRemoved,
+				Time:    ts,
+				Column:  c,
+				OldType: ty1,
 			}); err != nil {
-				return err
+				return nil, err
 			}
 
 		}
 	}
 
 	// Check for new columns.
-	for c := range cols2 {
+	for c, ty2 := range cols2 {
+		if _, ok := cd.renamed[c]; ok {
+			continue
+		}
+
 		// New column.
 		if _, ok := cols1[c]; !ok {
-			newCols = append(newCols, c)
+			cd.newCols = append(cd.newCols, c)
 
 			if err := h(&Event{
-				Type:   EventColumnAdded,
-				Time:   ts,
-				Column: c,
+				Type:    EventColumnAdded,
+				Time:    ts,
+				Column:  c,
+				NewType: ty2,
 			}); err != nil {
-				return err
+				return nil, err
+			}
+		}
+	}
+
+	var schemaEvents []*Event
+
+	if sdiff != nil {
+		schemaEvents = sdiff.Events
+	} else {
+		schemaEvents = nullabilityChanges(t1, t2)
+	}
+
+	for _, e := range schemaEvents {
+		e.Time = ts
+		if err := h(e); err != nil {
+			return nil, err
+		}
+	}
+
+	return cd, nil
+}
+
+// nullabilityChanges returns nullability-changed events for the columns in
+// both tables whose nullability is known and differs, if both tables
+// describe their columns.
+func nullabilityChanges(t1, t2 Table) []*Event {
+	cd1, ok1 := t1.(ColumnDescriber)
+	cd2, ok2 := t2.(ColumnDescriber)
+	if !ok1 || !ok2 {
+		return nil
+	}
+
+	old := make(map[string]*Column)
+	for _, c := range cd1.Columns() {
+		if c.HasNullable {
+			old[c.Name] = c
+		}
+	}
+
+	var events []*Event
+
+	for _, c := range cd2.Columns() {
+		o, ok := old[c.Name]
+		if !ok || !c.HasNullable || o.Nullable == c.Nullable {
+			continue
+		}
+
+		on, nn := o.Nullable, c.Nullable
+
+		events = append(events, &Event{
+			Type:        EventNullability,
+			Column:      c.Name,
+			OldNullable: &on,
+			NewNullable: &nn,
+		})
+	}
+
+	return events
+}
+
+// rowChanges compares the column-level values of two rows with the same key.
+func (cd *columnDiff) rowChanges(r1, r2 Row) map[string]*ValueChange {
+	changes := make(map[string]*ValueChange)
+
+	for _, c := range cd.cmpCols {
+		c1 := c
+		if o, ok := cd.renamed[c]; ok {
+			c1 = o
+		}
+
+		if !valuesEqual(r1, c1, r2, c) {
+			changes[c] = &ValueChange{
+				Old: r1.Value(c1),
+				New: r2.Value(c),
 			}
 		}
 	}
 
+	// Columns that have been dropped.
+	for _, c := range cd.dropCols {
+		changes[c] = &ValueChange{
+			Old: r1.Value(c),
+			New: nil,
+		}
+	}
+
+	// Columns that are new, just set the changes.
+	for _, c := range cd.newCols {
+		changes[c] = &ValueChange{
+			Old: nil,
+			New: r2.Value(c),
+		}
+	}
+
+	return changes
+}
+
+func DiffEvents(t1, t2 Table, h func(e *Event) error) error {
+	return DiffEventsWithOptions(t1, t2, nil, h)
+}
+
+// DiffEventsWithOptions diffs the tables using the options. A nil opts
+// uses the defaults.
+func DiffEventsWithOptions(t1, t2 Table, opts *DiffOptions, h func(e *Event) error) error {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+
+	if opts.HashJoin {
+		return hashDiffEvents(t1, t2, opts, h)
+	}
+
+	ts := time.Now().Unix()
+
+	cd, err := diffColumns(t1, t2, ts, h)
+	if err != nil {
+		return err
+	}
+
+	key1 := cd.key1
+	key2 := cd.key2
+	cols1 := cd.cols1
+	cols2 := cd.cols2
+
+	keyLen := len(key1)
+
+	// Comparators for ordering the key values. These must agree with the
+	// order the rows are produced by the tables.
+	cmps := keyComparators(t1, t2, key1, key2)
+
+	var order1, order2 *orderChecker
+	if opts.OrderPolicy != OrderIgnore {
+		order1 = newOrderChecker(1, key1, cmps)
+		order2 = newOrderChecker(2, key2, cmps)
+	}
+
+	warn := opts.warn()
+
+	checkOrder := func(c *orderChecker, r Row, k [][]byte) error {
+		if c == nil {
+			return nil
+		}
+
+		err := c.check(r, k)
+		if err != nil && opts.OrderPolicy == OrderWarn {
+			warn(err)
+			return nil
+		}
+
+		return err
+	}
+
 	var (
 		// Flags for whether to call next for the respective table.
 		n1 = true
@@ -269,8 +739,6 @@ func DiffEvents(t1, t2 Table, h func(e *Event) error) error {
 		// Next call was ok.
 		ok1 bool
 		ok2 bool
-
-		err error
 	)
 
 	// Single references.
@@ -295,6 +763,10 @@ func DiffEvents(t1, t2 Table, h func(e *Event) error) error {
 				for i, c := range key1 {
 					k1[i] = r1.Bytes(c)
 				}
+
+				if err := checkOrder(order1, r1, k1); err != nil {
+					return err
+				}
 			}
 		}
 
@@ -312,6 +784,10 @@ func DiffEvents(t1, t2 Table, h func(e *Event) error) error {
 				for i, c := range key2 {
 					k2[i] = r2.Bytes(c)
 				}
+
+				if err := checkOrder(order2, r2, k2); err != nil {
+					return err
+				}
 			}
 		}
 
@@ -353,10 +829,10 @@ func DiffEvents(t1, t2 Table, h func(e *Event) error) error {
 		}
 
 		// Check if keys match.
-		p := compareRows(k1, k2)
+		p := compareRows(k1, k2, cmps)
 
 		// Row seen in old table, but not new table, thus it has been deleted.
-		if p == -1 {
+		if p < 0 {
 			n1 = true
 
 			if err := h(&Event{
@@ -372,7 +848,7 @@ func DiffEvents(t1, t2 Table, h func(e *Event) error) error {
 		}
 
 		// Row seen in new table, but not old table, thus it has been added.
-		if p == 1 {
+		if p > 0 {
 			n2 = true
 
 			if err := h(&Event{
@@ -388,32 +864,7 @@ func DiffEvents(t1, t2 Table, h func(e *Event) error) error {
 		}
 
 		// Records have the same key. Compare the column-level values.
-		changes := make(map[string]*ValueChange)
-
-		for _, c := range cmpCols {
-			if !bytes.Equal(r1.Bytes(c), r2.Bytes(c)) {
-				changes[c] = &ValueChange{
-					Old: r1.Value(c),
-					New: r2.Value(c),
-				}
-			}
-		}
-
-		// Columns that have been dropped.
-		for _, c := range dropCols {
-			changes[c] = &ValueChange{
-				Old: r1.Value(c),
-				New: nil,
-			}
-		}
-
-		// Columns that are new, just set the changes.
-		for _, c := range newCols {
-			changes[c] = &ValueChange{
-				Old: nil,
-				New: r2.Value(c),
-			}
-		}
+		changes := cd.rowChanges(r1, r2)
 
 		if len(changes) > 0 {
 			if err := h(&Event{
@@ -439,6 +890,12 @@ func DiffEvents(t1, t2 Table, h func(e *Event) error) error {
 // Diff takes two tables and diffs them. If diffRows is true, value-level changes
 // will be reported as well.
 func Diff(t1, t2 Table, diffRows bool) (*TableDiff, error) {
+	return DiffWithOptions(t1, t2, diffRows, nil)
+}
+
+// DiffWithOptions diffs the tables using the options. A nil opts uses the
+// defaults.
+func DiffWithOptions(t1, t2 Table, diffRows bool, opts *DiffOptions) (*TableDiff, error) {
 	// Initial empty values for proper JSON encoding..
 	diff := TableDiff{
 		ColsAdded:   make([]string, 0),
@@ -449,7 +906,7 @@ func Diff(t1, t2 Table, diffRows bool) (*TableDiff, error) {
 		DeletedRows: make([]map[string]interface{}, 0),
 	}
 
-	err := DiffEvents(t1, t2, func(e *Event) error {
+	err := DiffEventsWithOptions(t1, t2, opts, func(e *Event) error {
 		diff.TotalRows = e.Offset
 
 		switch e.Type {
@@ -459,6 +916,19 @@ func Diff(t1, t2 Table, diffRows bool) (*TableDiff, error) {
 		case EventColumnRemoved:
 			diff.ColsDropped = append(diff.ColsDropped, e.Column)
 
+		case EventColumnRenamed:
+			if diff.ColsRenamed == nil {
+				diff.ColsRenamed = make(map[string]string)
+			}
+			diff.ColsRenamed[e.Column] = e.OldColumn
+
+			if e.OldType != e.NewType {
+				diff.TypeChanges[e.Column] = &TypeChange{
+					Old: e.OldType,
+					New: e.NewType,
+				}
+			}
+
 		case EventColumnChanged:
 			diff.TypeChanges[e.Column] = &TypeChange{
 				Old: e.OldType,
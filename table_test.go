@@ -0,0 +1,142 @@
+package difftable
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDiffEventsOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		offset    int64
+		duplicate bool
+	}{
+		{
+			name: "out of order",
+			data: `id,name
+1,John
+3,Sam
+2,Pam
+`,
+			offset: 3,
+		},
+		{
+			name: "duplicate",
+			data: `id,name
+1,John
+2,Pam
+2,Pam
+`,
+			offset:    3,
+			duplicate: true,
+		},
+	}
+
+	sorted := `id,name
+1,John
+2,Pam
+3,Sam
+`
+
+	key := []string{"id"}
+
+	for _, test := range tests {
+		t1, _ := CSVTable(NewCSVReader(bytes.NewBufferString(sorted), ','), key, nil)
+		t2, _ := CSVTable(NewCSVReader(bytes.NewBufferString(test.data), ','), key, nil)
+
+		err := DiffEvents(t1, t2, func(e *Event) error {
+			return nil
+		})
+
+		oerr, ok := err.(*OrderError)
+		if !ok {
+			t.Errorf("%s: expected order error, got %v", test.name, err)
+			continue
+		}
+
+		if oerr.Table != 2 || oerr.Offset != test.offset || oerr.Duplicate != test.duplicate {
+			t.Errorf("%s: unexpected error %s", test.name, oerr)
+		}
+	}
+}
+
+func TestDiffEventsOrderNullKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		ids       []interface{}
+		offset    int64
+		duplicate bool
+	}{
+		{
+			name:      "repeated null",
+			ids:       []interface{}{int64(1), nil, nil},
+			offset:    3,
+			duplicate: true,
+		},
+		{
+			name:   "value after null",
+			ids:    []interface{}{int64(1), nil, int64(2)},
+			offset: 3,
+		},
+	}
+
+	key := []string{"id"}
+	cols := map[string]string{"id": "long"}
+
+	for _, test := range tests {
+		var rows []map[string]interface{}
+		for _, id := range test.ids {
+			rows = append(rows, map[string]interface{}{"id": id})
+		}
+
+		t1 := &sliceTable{key: key, cols: cols, rows: []map[string]interface{}{{"id": int64(1)}}}
+		t2 := &sliceTable{key: key, cols: cols, rows: rows}
+
+		err := DiffEvents(t1, t2, func(e *Event) error {
+			return nil
+		})
+
+		oerr, ok := err.(*OrderError)
+		if !ok {
+			t.Errorf("%s: expected order error, got %v", test.name, err)
+			continue
+		}
+
+		if oerr.Table != 2 || oerr.Offset != test.offset || oerr.Duplicate != test.duplicate {
+			t.Errorf("%s: unexpected error %s", test.name, oerr)
+		}
+	}
+}
+
+func TestDiffEventsOrderWarn(t *testing.T) {
+	data := `id,name
+2,Pam
+1,John
+`
+
+	key := []string{"id"}
+
+	t1, _ := CSVTable(NewCSVReader(bytes.NewBufferString(data), ','), key, nil)
+	t2, _ := CSVTable(NewCSVReader(bytes.NewBufferString(data), ','), key, nil)
+
+	var warnings []error
+
+	opts := &DiffOptions{
+		OrderPolicy: OrderWarn,
+		Warn: func(err error) {
+			warnings = append(warnings, err)
+		},
+	}
+
+	err := DiffEventsWithOptions(t1, t2, opts, func(e *Event) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(warnings) != 2 {
+		t.Errorf("expected 2 warnings, got %d", len(warnings))
+	}
+}
@@ -0,0 +1,536 @@
+package difftable
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// DefaultXLSXSampleSize is the default number of rows read to discover the
+// column types of a worksheet.
+const DefaultXLSXSampleSize = 100
+
+// XLSXOptions are options for reading a worksheet as a table.
+type XLSXOptions struct {
+	// Sheet is the name of the worksheet. Defaults to the first sheet.
+	Sheet string
+
+	// HeaderRow is the 1-based row number of the column names. Rows above
+	// it are ignored. Defaults to 1.
+	HeaderRow int
+
+	// SampleSize is the number of rows read to discover the column types.
+	// Defaults to DefaultXLSXSampleSize.
+	SampleSize int
+}
+
+// XLSXTable returns a table of the first worksheet in the workbook using
+// the first row as the column names.
+func XLSXTable(f *excelize.File, key []string, renames map[string]string) (Table, error) {
+	return XLSXTableWithOptions(f, key, renames, XLSXOptions{})
+}
+
+// XLSXTableWithOptions returns a table of a worksheet in the workbook.
+// Columns without a name in the header row are ignored and rows without any
+// values are skipped.
+//
+// Column types are one of string, integer, number, boolean, date, time or
+// datetime based on the cell types and number formats of the sampled rows.
+// A column with mixed types is typed string. Dates and times are returned
+// as time.Time values and encoded as YYYY-MM-DD, HH:MM:SS and
+// YYYY-MM-DD HH:MM:SS so they compare the same as other text sources.
+func XLSXTableWithOptions(f *excelize.File, key []string, renames map[string]string, opts XLSXOptions) (Table, error) {
+	if opts.HeaderRow <= 0 {
+		opts.HeaderRow = 1
+	}
+	if opts.SampleSize <= 0 {
+		opts.SampleSize = DefaultXLSXSampleSize
+	}
+
+	sheet := opts.Sheet
+	if sheet == "" {
+		sheets := f.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, fmt.Errorf("workbook has no sheets")
+		}
+		sheet = sheets[0]
+	}
+
+	for i, k := range key {
+		if n, ok := renames[k]; ok {
+			key[i] = n
+		}
+	}
+
+	props, err := f.GetWorkbookProps()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := f.Rows(sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &xlsxTable{
+		f:       f,
+		sheet:   sheet,
+		rows:    rows,
+		key:     key,
+		formats: make(map[int]xlsxFormat),
+	}
+
+	if props.Date1904 != nil {
+		t.date1904 = *props.Date1904
+	}
+
+	var header []string
+
+	for t.rowNum < opts.HeaderRow {
+		if !rows.Next() {
+			rows.Close()
+			if err := rows.Error(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("sheet `%s` has no row %d", sheet, opts.HeaderRow)
+		}
+		t.rowNum++
+
+		if t.rowNum == opts.HeaderRow {
+			header, err = rows.Columns()
+			if err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("row %d: %s", t.rowNum, err)
+			}
+		}
+	}
+
+	t.cols = make(map[string]string)
+	t.colIdxs = make(map[string]int)
+
+	for i, c := range header {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if n, ok := renames[c]; ok {
+			c = n
+		}
+		if _, ok := t.colIdxs[c]; ok {
+			rows.Close()
+			return nil, fmt.Errorf("duplicate column `%s` in header", c)
+		}
+		t.colIdxs[c] = i
+		t.cols[c] = ""
+		t.width = i + 1
+	}
+
+	for len(t.sample) < opts.SampleSize {
+		rec, err := t.read()
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if rec == nil {
+			break
+		}
+
+		t.sample = append(t.sample, rec)
+
+		for c, i := range t.colIdxs {
+			if rec[i].typ != "" {
+				t.cols[c] = mergeXLSXType(t.cols[c], rec[i].typ)
+			}
+		}
+	}
+
+	// Columns without sampled values are assumed to be text.
+	for c, ty := range t.cols {
+		if ty == "" {
+			t.cols[c] = "string"
+		}
+	}
+
+	return t, nil
+}
+
+// mergeXLSXType returns the type of a column given the type of another
+// value in it.
+func mergeXLSXType(t1, t2 string) string {
+	switch {
+	case t1 == "" || t1 == t2:
+		return t2
+	case t1 == "integer" && t2 == "number", t1 == "number" && t2 == "integer":
+		return "number"
+	case t1 == "date" && t2 == "datetime", t1 == "datetime" && t2 == "date":
+		return "datetime"
+	}
+	return "string"
+}
+
+// xlsxFormat classifies the number format of a cell.
+type xlsxFormat int
+
+const (
+	xlsxNumber xlsxFormat = iota
+	xlsxDate
+	xlsxTime
+	xlsxDateTime
+)
+
+// Built-in number formats for dates and times. Formats 27-36 and 50-58 are
+// locale-specific date formats.
+var xlsxBuiltinFormats = map[int]xlsxFormat{
+	14: xlsxDate,
+	15: xlsxDate,
+	16: xlsxDate,
+	17: xlsxDate,
+	18: xlsxTime,
+	19: xlsxTime,
+	20: xlsxTime,
+	21: xlsxTime,
+	22: xlsxDateTime,
+	45: xlsxTime,
+	46: xlsxTime,
+	47: xlsxTime,
+}
+
+func init() {
+	for i := 27; i <= 36; i++ {
+		xlsxBuiltinFormats[i] = xlsxDate
+	}
+	for i := 50; i <= 58; i++ {
+		xlsxBuiltinFormats[i] = xlsxDate
+	}
+}
+
+// parseXLSXFormat classifies a custom number format code by the date and
+// time tokens in its first section. An "m" is taken to be minutes if hours
+// or seconds are present and months otherwise.
+func parseXLSXFormat(code string) xlsxFormat {
+	code = stripXLSXLiterals(strings.ToLower(code))
+	code = strings.NewReplacer("am/pm", "", "a/p", "").Replace(code)
+
+	date := strings.ContainsAny(code, "yd")
+	clock := strings.ContainsAny(code, "hs")
+
+	if !clock && strings.ContainsRune(code, 'm') {
+		date = true
+	}
+
+	switch {
+	case date && clock:
+		return xlsxDateTime
+	case date:
+		return xlsxDate
+	case clock:
+		return xlsxTime
+	}
+	return xlsxNumber
+}
+
+// stripXLSXLiterals returns the first section of a format code without
+// quoted text, escaped characters and bracketed colors or locales. Elapsed
+// time tokens such as [h] are kept without the brackets.
+func stripXLSXLiterals(code string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(code); i++ {
+		switch c := code[i]; c {
+		case ';':
+			return b.String()
+		case '"':
+			j := strings.IndexByte(code[i+1:], '"')
+			if j < 0 {
+				return b.String()
+			}
+			i += j + 1
+		case '\\', '_', '*':
+			i++
+		case '[':
+			j := strings.IndexByte(code[i:], ']')
+			if j < 0 {
+				return b.String()
+			}
+			if tok := code[i+1 : i+j]; tok != "" && strings.Trim(tok, "hms") == "" {
+				b.WriteString(tok)
+			}
+			i += j
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// xlsxCell is a decoded cell value.
+type xlsxCell struct {
+	typ   string
+	bytes []byte
+	value interface{}
+}
+
+type xlsxTable struct {
+	f        *excelize.File
+	sheet    string
+	rows     *excelize.Rows
+	date1904 bool
+
+	key     []string
+	cols    map[string]string
+	colIdxs map[string]int
+
+	// Number of cells in a record.
+	width int
+
+	// Number formats keyed by style index.
+	formats map[int]xlsxFormat
+
+	// Rows read while discovering the column types.
+	sample [][]xlsxCell
+
+	// Row number of the last row read.
+	rowNum int
+
+	record []xlsxCell
+	done   bool
+}
+
+// read returns the cells of the next row with a value in any column. A nil
+// record is returned when the sheet is exhausted.
+func (t *xlsxTable) read() ([]xlsxCell, error) {
+	if t.done {
+		return nil, nil
+	}
+
+	for t.rows.Next() {
+		t.rowNum++
+
+		vals, err := t.rows.Columns(excelize.Options{RawCellValue: true})
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %s", t.rowNum, err)
+		}
+
+		var rec []xlsxCell
+
+		for _, i := range t.colIdxs {
+			if i >= len(vals) || vals[i] == "" {
+				continue
+			}
+
+			if rec == nil {
+				rec = make([]xlsxCell, t.width)
+			}
+
+			rec[i], err = t.cell(i, vals[i])
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %s", t.rowNum, err)
+			}
+
+		}
+
+		if rec != nil {
+			return rec, nil
+		}
+	}
+
+	t.done = true
+
+	if err := t.rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// cell decodes the raw value of the cell in the column index of the
+// current row.
+func (t *xlsxTable) cell(i int, raw string) (xlsxCell, error) {
+	name, err := excelize.CoordinatesToCellName(i+1, t.rowNum)
+	if err != nil {
+		return xlsxCell{}, err
+	}
+
+	ct, err := t.f.GetCellType(t.sheet, name)
+	if err != nil {
+		return xlsxCell{}, err
+	}
+
+	switch ct {
+	case excelize.CellTypeBool:
+		v := raw == "1" || strings.EqualFold(raw, "true")
+		return xlsxCell{
+			typ:   "boolean",
+			bytes: []byte(strconv.FormatBool(v)),
+			value: v,
+		}, nil
+
+	case excelize.CellTypeDate:
+		if tm, ok := parseTime([]byte(raw)); ok {
+			typ := "datetime"
+			if !strings.ContainsRune(raw, 'T') {
+				typ = "date"
+			}
+			return xlsxTimeCell(typ, tm), nil
+		}
+
+	case excelize.CellTypeNumber, excelize.CellTypeUnset:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			break
+		}
+
+		format, err := t.format(name)
+		if err != nil {
+			return xlsxCell{}, err
+		}
+
+		if format != xlsxNumber && f >= 0 {
+			tm, err := excelize.ExcelDateToTime(f, t.date1904)
+			if err != nil {
+				return xlsxCell{}, err
+			}
+
+			switch format {
+			case xlsxDate:
+				y, m, d := tm.Date()
+				return xlsxTimeCell("date", time.Date(y, m, d, 0, 0, 0, 0, time.UTC)), nil
+			case xlsxTime:
+				return xlsxTimeCell("time", tm), nil
+			}
+			return xlsxTimeCell("datetime", tm), nil
+		}
+
+		n := canonicalNumber(raw)
+		if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+			return xlsxCell{
+				typ:   "integer",
+				bytes: []byte(n),
+				value: i,
+			}, nil
+		}
+
+		return xlsxCell{
+			typ:   "number",
+			bytes: []byte(n),
+			value: f,
+		}, nil
+	}
+
+	return xlsxCell{
+		typ:   "string",
+		bytes: []byte(raw),
+		value: raw,
+	}, nil
+}
+
+// xlsxTimeCell returns a date, time or datetime cell.
+func xlsxTimeCell(typ string, tm time.Time) xlsxCell {
+	var layout string
+
+	switch typ {
+	case "date":
+		layout = "2006-01-02"
+	case "time":
+		layout = "15:04:05"
+	default:
+		layout = "2006-01-02 15:04:05"
+	}
+
+	return xlsxCell{
+		typ:   typ,
+		bytes: []byte(tm.Format(layout)),
+		value: tm,
+	}
+}
+
+// format returns the number format of the cell.
+func (t *xlsxTable) format(name string) (xlsxFormat, error) {
+	idx, err := t.f.GetCellStyle(t.sheet, name)
+	if err != nil {
+		return xlsxNumber, err
+	}
+
+	if format, ok := t.formats[idx]; ok {
+		return format, nil
+	}
+
+	style, err := t.f.GetStyle(idx)
+	if err != nil {
+		return xlsxNumber, err
+	}
+
+	var format xlsxFormat
+
+	if style.CustomNumFmt != nil {
+		format = parseXLSXFormat(*style.CustomNumFmt)
+	} else {
+		format = xlsxBuiltinFormats[style.NumFmt]
+	}
+
+	t.formats[idx] = format
+
+	return format, nil
+}
+
+func (t *xlsxTable) Key() []string {
+	return t.key
+}
+
+func (t *xlsxTable) Cols() map[string]string {
+	return t.cols
+}
+
+func (t *xlsxTable) Row() Row {
+	return &xlsxRow{
+		colIdxs: t.colIdxs,
+		record:  t.record,
+	}
+}
+
+func (t *xlsxTable) Next() (bool, error) {
+	t.record = nil
+
+	if len(t.sample) > 0 {
+		t.record = t.sample[0]
+		t.sample[0] = nil
+		t.sample = t.sample[1:]
+		return true, nil
+	}
+
+	rec, err := t.read()
+	if err != nil || rec == nil {
+		return false, err
+	}
+
+	t.record = rec
+
+	return true, nil
+}
+
+type xlsxRow struct {
+	colIdxs map[string]int
+	record  []xlsxCell
+}
+
+func (r *xlsxRow) Bytes(col string) []byte {
+	i, ok := r.colIdxs[col]
+	if !ok || r.record == nil {
+		return nil
+	}
+
+	return r.record[i].bytes
+}
+
+func (r *xlsxRow) Value(col string) interface{} {
+	i, ok := r.colIdxs[col]
+	if !ok || r.record == nil {
+		return nil
+	}
+
+	return r.record[i].value
+}
@@ -0,0 +1,202 @@
+package difftable
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// writeXLSX writes the rows to an in-memory workbook starting at the row
+// and reopens it. Cells in the born column are formatted as dates and cells
+// in the seen column as timestamps.
+func writeXLSX(t *testing.T, start int, rows [][]interface{}) *excelize.File {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 14})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	format := "yyyy-mm-dd hh:mm:ss"
+	timeStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: &format})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, r := range rows {
+		cell, err := excelize.CoordinatesToCellName(1, start+i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.SetSheetRow("Sheet1", cell, &r); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			continue
+		}
+		if err := f.SetCellStyle("Sheet1", "C"+cell[1:], "C"+cell[1:], dateStyle); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.SetCellStyle("Sheet1", "D"+cell[1:], "D"+cell[1:], timeStyle); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := excelize.OpenReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+var (
+	xlsxBorn = time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+	xlsxSeen = time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+)
+
+func TestXLSXTable(t *testing.T) {
+	f := writeXLSX(t, 3, [][]interface{}{
+		{"id", "name", "born", "seen", "score", "active"},
+		{10, "Sam", xlsxBorn, xlsxSeen, 1.5, true},
+		{},
+		{2, nil, xlsxBorn, xlsxSeen, 3, false},
+	})
+	defer f.Close()
+
+	tb, err := XLSXTableWithOptions(f, []string{"id"}, map[string]string{"name": "full_name"}, XLSXOptions{
+		HeaderRow: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"id":        "integer",
+		"full_name": "string",
+		"born":      "date",
+		"seen":      "datetime",
+		"score":     "number",
+		"active":    "boolean",
+	}
+
+	if s1, s2, ok := jsonEqual(expected, tb.Cols()); !ok {
+		t.Errorf("column types don't match. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	var rows []Row
+	for {
+		ok, err := tb.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		rows = append(rows, tb.Row())
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+
+	r := rows[0]
+
+	if v := r.Value("id"); v != int64(10) {
+		t.Errorf("expected id 10, got %#v", v)
+	}
+	if v := r.Value("full_name"); v != "Sam" {
+		t.Errorf("expected name Sam, got %#v", v)
+	}
+	if v, ok := r.Value("born").(time.Time); !ok || !v.Equal(xlsxBorn) {
+		t.Errorf("expected born %s, got %#v", xlsxBorn, r.Value("born"))
+	}
+	if v := r.Value("active"); v != true {
+		t.Errorf("expected active true, got %#v", v)
+	}
+
+	for col, exp := range map[string]string{
+		"id":     "10",
+		"born":   "2018-01-02",
+		"seen":   "2019-03-04 05:06:07",
+		"score":  "1.5",
+		"active": "true",
+	} {
+		if b := string(r.Bytes(col)); b != exp {
+			t.Errorf("expected %s %s, got %s", col, exp, b)
+		}
+	}
+
+	if b := rows[1].Bytes("full_name"); b != nil {
+		t.Errorf("expected nil name, got %s", b)
+	}
+}
+
+func TestXLSXTableSort(t *testing.T) {
+	f := writeXLSX(t, 1, [][]interface{}{
+		{"id", "name"},
+		{10, "Sam"},
+		{2, "Pam"},
+		{1, "John"},
+	})
+	defer f.Close()
+
+	tb, err := XLSXTable(f, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := Sort(tb, SortOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for {
+		ok, err := st.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		ids = append(ids, string(st.Row().Bytes("id")))
+	}
+
+	if s1, s2, ok := jsonEqual([]string{"1", "2", "10"}, ids); !ok {
+		t.Errorf("expected ids in numeric order. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestParseXLSXFormat(t *testing.T) {
+	tests := map[string]xlsxFormat{
+		"General":                xlsxNumber,
+		"0.00":                   xlsxNumber,
+		"#,##0;[Red]-#,##0":      xlsxNumber,
+		"yyyy-mm-dd":             xlsxDate,
+		"mmm-yy":                 xlsxDate,
+		"[$-409]d-mmm-yyyy":      xlsxDate,
+		"h:mm AM/PM":             xlsxTime,
+		"[h]:mm:ss":              xlsxTime,
+		"mm:ss":                  xlsxTime,
+		"m/d/yyyy h:mm":          xlsxDateTime,
+		`"Day "0`:                xlsxNumber,
+		`0.0\d`:                  xlsxNumber,
+		"yyyy-mm-dd;@":           xlsxDate,
+		"[Blue]yyyy-mm-dd hh:mm": xlsxDateTime,
+	}
+
+	for code, exp := range tests {
+		if f := parseXLSXFormat(code); f != exp {
+			t.Errorf("%s: expected %d, got %d", code, exp, f)
+		}
+	}
+}
@@ -0,0 +1,712 @@
+package difftable
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// XMLMapping maps the elements of an XML document to rows and columns.
+type XMLMapping struct {
+	// Record is the path of the elements that are rows, such as
+	// /feed/patients/patient, or //patient for elements at any depth.
+	// Namespace prefixes are ignored.
+	Record string `json:"record"`
+
+	// Columns maps column names to XPath expressions relative to the
+	// record element, such as @id, name/family or
+	// telecom[@system='phone']/@value.
+	Columns map[string]string `json:"columns"`
+}
+
+// ReadXMLMapping reads a mapping encoded as JSON, for example:
+//
+//	{
+//	  "record": "/feed/patient",
+//	  "columns": {
+//	    "id": "@id",
+//	    "family": "name[@use='official']/family",
+//	    "phone": "telecom[system='phone'][1]/value"
+//	  }
+//	}
+func ReadXMLMapping(r io.Reader) (*XMLMapping, error) {
+	var m XMLMapping
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+
+	if _, err := m.compile(); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// compile parses the record path and the column expressions.
+func (m *XMLMapping) compile() (map[string]*xpath, error) {
+	if len(m.Columns) == 0 {
+		return nil, fmt.Errorf("mapping has no columns")
+	}
+
+	if _, err := parseXMLRecord(m.Record); err != nil {
+		return nil, fmt.Errorf("record: %s", err)
+	}
+
+	paths := make(map[string]*xpath, len(m.Columns))
+
+	for c, p := range m.Columns {
+		x, err := parseXPath(p)
+		if err != nil {
+			return nil, fmt.Errorf("column `%s`: %s", c, err)
+		}
+		paths[c] = x
+	}
+
+	return paths, nil
+}
+
+// xmlNode is an element of a record or, if the name is empty, text.
+type xmlNode struct {
+	name     string
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     string
+}
+
+// value returns the text of the node and its descendants.
+func (n *xmlNode) value() string {
+	if n.name == "" {
+		return n.text
+	}
+
+	var b strings.Builder
+	n.appendText(&b)
+	return b.String()
+}
+
+func (n *xmlNode) appendText(b *strings.Builder) {
+	for _, c := range n.children {
+		if c.name == "" {
+			b.WriteString(c.text)
+		} else {
+			c.appendText(b)
+		}
+	}
+}
+
+// descendants appends the node and its descendant elements.
+func (n *xmlNode) descendants(out []*xmlNode) []*xmlNode {
+	out = append(out, n)
+	for _, c := range n.children {
+		if c.name != "" {
+			out = c.descendants(out)
+		}
+	}
+	return out
+}
+
+// xmlRecordStep is a step of a record path.
+type xmlRecordStep struct {
+	name string
+
+	// The element may be at any depth below the previous one.
+	descendant bool
+}
+
+// parseXMLRecord parses an absolute path of element names separated by /
+// or //.
+func parseXMLRecord(s string) ([]xmlRecordStep, error) {
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("path must start with /")
+	}
+
+	var steps []xmlRecordStep
+
+	for s != "" {
+		var step xmlRecordStep
+
+		switch {
+		case strings.HasPrefix(s, "//"):
+			step.descendant = true
+			s = s[2:]
+		case strings.HasPrefix(s, "/"):
+			s = s[1:]
+		}
+
+		i := strings.IndexByte(s, '/')
+		if i < 0 {
+			i = len(s)
+		}
+
+		step.name = xmlLocalName(s[:i])
+		if step.name == "" || !xmlName(step.name) && step.name != "*" {
+			return nil, fmt.Errorf("invalid element `%s`", s[:i])
+		}
+
+		steps = append(steps, step)
+		s = s[i:]
+	}
+
+	return steps, nil
+}
+
+// matchXMLRecord returns true if the path of element names matches the
+// steps.
+func matchXMLRecord(steps []xmlRecordStep, path []string) bool {
+	if len(steps) == 0 {
+		return len(path) == 0
+	}
+
+	s := steps[0]
+
+	for i := range path {
+		if i > 0 && !s.descendant {
+			break
+		}
+
+		if (s.name == "*" || s.name == path[i]) && matchXMLRecord(steps[1:], path[i+1:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// xmlLocalName strips the namespace prefix of a name.
+func xmlLocalName(s string) string {
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// xmlName returns true if the string is a valid element or attribute name.
+func xmlName(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i, c := range s {
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c > 0x7f {
+			continue
+		}
+		if i > 0 && (c == '-' || c == '.' || c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
+
+// xpath is an expression selecting nodes relative to a record element.
+type xpath struct {
+	steps []*xpathStep
+}
+
+// xpathStep selects child elements by name, attributes, text or the node
+// itself, filtered by predicates.
+type xpathStep struct {
+	// Kind of the step: element, attr, text or self.
+	kind string
+	name string
+
+	// Apply the step to the descendants of the nodes.
+	descendant bool
+
+	preds []*xpathPred
+}
+
+// xpathPred is a position, last() or a path whose values are compared to
+// a literal or, without an operator, exist.
+type xpathPred struct {
+	pos  int
+	last bool
+	path *xpath
+	op   string
+	lit  string
+}
+
+// xpathParser parses XPath expressions.
+type xpathParser struct {
+	s string
+	i int
+}
+
+func (p *xpathParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, args...), p.i+1)
+}
+
+func (p *xpathParser) space() {
+	for p.i < len(p.s) && strings.IndexByte(" \t\n", p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+// consume consumes the string if it is next.
+func (p *xpathParser) consume(s string) bool {
+	p.space()
+	if strings.HasPrefix(p.s[p.i:], s) {
+		p.i += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *xpathParser) name() string {
+	p.space()
+	j := p.i
+	for p.i < len(p.s) && strings.IndexByte("/[]()@=!'\" \t\n", p.s[p.i]) < 0 {
+		p.i++
+	}
+	return p.s[j:p.i]
+}
+
+// path parses steps separated by / or //.
+func (p *xpathParser) path() (*xpath, error) {
+	x := &xpath{}
+
+	// Paths are relative to the record, so a leading // selects its
+	// descendants.
+	descendant := p.consume("//")
+	if !descendant && p.consume("/") {
+		return nil, p.errorf("paths are relative to the record")
+	}
+
+	for {
+		step := &xpathStep{
+			kind:       "element",
+			descendant: descendant,
+		}
+
+		switch {
+		case p.consume("@"):
+			step.kind = "attr"
+			step.name = xmlLocalName(p.name())
+			if !xmlName(step.name) && step.name != "*" {
+				return nil, p.errorf("invalid attribute `%s`", step.name)
+			}
+
+		case p.consume("text()"):
+			step.kind = "text"
+
+		default:
+			name := p.name()
+			switch {
+			case name == ".":
+				step.kind = "self"
+			case name == "*":
+				step.name = name
+			case xmlName(xmlLocalName(name)):
+				step.name = xmlLocalName(name)
+			default:
+				return nil, p.errorf("expected an element, attribute or text()")
+			}
+		}
+
+		for p.consume("[") {
+			pred, err := p.predicate()
+			if err != nil {
+				return nil, err
+			}
+			step.preds = append(step.preds, pred)
+
+			if !p.consume("]") {
+				return nil, p.errorf("expected ]")
+			}
+		}
+
+		x.steps = append(x.steps, step)
+
+		switch {
+		case p.consume("//"):
+			descendant = true
+		case p.consume("/"):
+			descendant = false
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *xpathParser) predicate() (*xpathPred, error) {
+	p.space()
+
+	j := p.i
+	for p.i < len(p.s) && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+		p.i++
+	}
+	if j < p.i {
+		n, _ := strconv.Atoi(p.s[j:p.i])
+		if n < 1 {
+			return nil, p.errorf("positions start at 1")
+		}
+		return &xpathPred{pos: n}, nil
+	}
+
+	if p.consume("last()") {
+		return &xpathPred{last: true}, nil
+	}
+
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+
+	pred := &xpathPred{path: path}
+
+	switch {
+	case p.consume("!="):
+		pred.op = "!="
+	case p.consume("="):
+		pred.op = "="
+	default:
+		return pred, nil
+	}
+
+	p.space()
+
+	if p.i < len(p.s) && (p.s[p.i] == '\'' || p.s[p.i] == '"') {
+		q := p.s[p.i]
+		end := strings.IndexByte(p.s[p.i+1:], q)
+		if end < 0 {
+			return nil, p.errorf("unterminated string")
+		}
+		pred.lit = p.s[p.i+1 : p.i+1+end]
+		p.i += end + 2
+		return pred, nil
+	}
+
+	j = p.i
+	for p.i < len(p.s) && strings.IndexByte("+-.0123456789", p.s[p.i]) >= 0 {
+		p.i++
+	}
+	if j == p.i {
+		return nil, p.errorf("expected a string or number")
+	}
+	pred.lit = p.s[j:p.i]
+
+	return pred, nil
+}
+
+// parseXPath parses an expression relative to the record element. The
+// supported subset has child elements, *, @attributes, text() and . as
+// steps separated by / or //, and predicates of positions, last() and
+// comparisons of paths to literals with = and !=.
+func parseXPath(s string) (*xpath, error) {
+	p := &xpathParser{s: s}
+
+	x, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+
+	p.space()
+	if p.i < len(p.s) {
+		return nil, p.errorf("unexpected `%s`", p.s[p.i:])
+	}
+
+	return x, nil
+}
+
+// eval returns the nodes selected from the node.
+func (x *xpath) eval(n *xmlNode) []*xmlNode {
+	nodes := []*xmlNode{n}
+
+	for _, s := range x.steps {
+		var out []*xmlNode
+
+		for _, n := range nodes {
+			ctx := []*xmlNode{n}
+			if s.descendant {
+				ctx = n.descendants(nil)
+			}
+
+			for _, c := range ctx {
+				out = append(out, s.filter(s.apply(c))...)
+			}
+		}
+
+		nodes = out
+	}
+
+	return nodes
+}
+
+// apply returns the nodes selected by the step from the node.
+func (s *xpathStep) apply(n *xmlNode) []*xmlNode {
+	var out []*xmlNode
+
+	switch s.kind {
+	case "self":
+		out = append(out, n)
+
+	case "attr":
+		for _, a := range n.attrs {
+			if s.name == "*" || a.Name.Local == s.name {
+				out = append(out, &xmlNode{text: a.Value})
+			}
+		}
+
+	case "text":
+		for _, c := range n.children {
+			if c.name == "" {
+				out = append(out, c)
+			}
+		}
+
+	default:
+		for _, c := range n.children {
+			if c.name != "" && (s.name == "*" || c.name == s.name) {
+				out = append(out, c)
+			}
+		}
+	}
+
+	return out
+}
+
+// filter applies the predicates in order.
+func (s *xpathStep) filter(nodes []*xmlNode) []*xmlNode {
+	for _, p := range s.preds {
+		var out []*xmlNode
+
+		for i, n := range nodes {
+			switch {
+			case p.pos > 0:
+				if i+1 == p.pos {
+					out = append(out, n)
+				}
+
+			case p.last:
+				if i == len(nodes)-1 {
+					out = append(out, n)
+				}
+
+			default:
+				vals := p.path.eval(n)
+
+				match := len(vals) > 0
+				if p.op != "" {
+					match = false
+					for _, v := range vals {
+						if v.value() == p.lit {
+							match = true
+							break
+						}
+					}
+					if p.op == "!=" {
+						match = !match
+					}
+				}
+
+				if match {
+					out = append(out, n)
+				}
+			}
+		}
+
+		nodes = out
+	}
+
+	return nodes
+}
+
+// XMLTable returns a table of the record elements of an XML document. The
+// document is read as a stream and only one record is held in memory at a
+// time, so records must not be nested in each other.
+//
+// Columns are typed string. The value of a column is the text of the
+// first node selected by its expression, with surrounding whitespace
+// removed, or null if no node is selected.
+func XMLTable(r io.Reader, mapping *XMLMapping, key []string, renames map[string]string) (Table, error) {
+	paths, err := mapping.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	record, _ := parseXMLRecord(mapping.Record)
+
+	for i, k := range key {
+		if n, ok := renames[k]; ok {
+			key[i] = n
+		}
+	}
+
+	dec := xml.NewDecoder(r)
+	dec.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+
+	t := &xmlTable{
+		dec:    dec,
+		record: record,
+		key:    key,
+		cols:   make(map[string]string, len(paths)),
+		paths:  make(map[string]*xpath, len(paths)),
+	}
+
+	for c, x := range paths {
+		if n, ok := renames[c]; ok {
+			c = n
+		}
+
+		if _, ok := t.cols[c]; ok {
+			return nil, fmt.Errorf("duplicate column `%s`", c)
+		}
+
+		t.cols[c] = "string"
+		t.paths[c] = x
+	}
+
+	return t, nil
+}
+
+type xmlTable struct {
+	dec    *xml.Decoder
+	record []xmlRecordStep
+	key    []string
+	cols   map[string]string
+	paths  map[string]*xpath
+
+	// Names of the open elements.
+	stack []string
+
+	// Number of records read.
+	offset int64
+
+	node *xmlNode
+}
+
+func (t *xmlTable) Key() []string {
+	return t.key
+}
+
+func (t *xmlTable) Cols() map[string]string {
+	return t.cols
+}
+
+func (t *xmlTable) Row() Row {
+	return &xmlRow{
+		cols:  t.paths,
+		node:  t.node,
+		cache: make(map[string][]byte, len(t.paths)),
+	}
+}
+
+func (t *xmlTable) Next() (bool, error) {
+	t.node = nil
+
+	for {
+		tok, err := t.dec.Token()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("record %d: %s", t.offset+1, err)
+		}
+
+		switch e := tok.(type) {
+		case xml.StartElement:
+			t.stack = append(t.stack, e.Name.Local)
+
+			if !matchXMLRecord(t.record, t.stack) {
+				continue
+			}
+
+			t.offset++
+
+			node, err := t.readNode(e)
+			if err != nil {
+				return false, fmt.Errorf("record %d: %s", t.offset, err)
+			}
+
+			t.stack = t.stack[:len(t.stack)-1]
+			t.node = node
+
+			return true, nil
+
+		case xml.EndElement:
+			t.stack = t.stack[:len(t.stack)-1]
+		}
+	}
+}
+
+// readNode reads the element up to its end.
+func (t *xmlTable) readNode(start xml.StartElement) (*xmlNode, error) {
+	n := &xmlNode{
+		name:  start.Name.Local,
+		attrs: start.Attr,
+	}
+
+	for {
+		tok, err := t.dec.Token()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch e := tok.(type) {
+		case xml.StartElement:
+			c, err := t.readNode(e)
+			if err != nil {
+				return nil, err
+			}
+			n.children = append(n.children, c)
+
+		case xml.CharData:
+			n.children = append(n.children, &xmlNode{text: string(e)})
+
+		case xml.EndElement:
+			return n, nil
+		}
+	}
+}
+
+type xmlRow struct {
+	cols  map[string]*xpath
+	node  *xmlNode
+	cache map[string][]byte
+}
+
+func (r *xmlRow) Bytes(col string) []byte {
+	x, ok := r.cols[col]
+	if !ok || r.node == nil {
+		return nil
+	}
+
+	if b, ok := r.cache[col]; ok {
+		return b
+	}
+
+	var b []byte
+	if nodes := x.eval(r.node); len(nodes) > 0 {
+		b = []byte(strings.TrimSpace(nodes[0].value()))
+	}
+
+	r.cache[col] = b
+
+	return b
+}
+
+func (r *xmlRow) Value(col string) interface{} {
+	if b := r.Bytes(col); b != nil {
+		return string(b)
+	}
+	return nil
+}
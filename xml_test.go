@@ -0,0 +1,133 @@
+package difftable
+
+import (
+	"bytes"
+	"testing"
+)
+
+const xmlFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:v="urn:vendor">
+  <header><patient id="ignored"/></header>
+  <patients>
+    <v:patient id="1">
+      <name use="nickname"><family>Bo</family></name>
+      <name use="official"><given>Ann</given> <family>Smith</family></name>
+      <telecom><system>email</system><value>ann@example.com</value></telecom>
+      <telecom><system>phone</system><value>555-0100</value></telecom>
+      <telecom><system>phone</system><value>555-0101</value></telecom>
+      <note><![CDATA[a < b]]></note>
+    </v:patient>
+    <v:patient id="2">
+      <name use="official"><family>Jones</family></name>
+      <address><line>1 Main St</line><city>Trenton</city></address>
+    </v:patient>
+  </patients>
+</feed>
+`
+
+var xmlFeedMapping = `{
+  "record": "/feed/patients/patient",
+  "columns": {
+    "id": "@id",
+    "family": "name[@use='official']/family",
+    "name": "name[@use = 'official']",
+    "phone": "telecom[system='phone'][1]/value",
+    "last_phone": "telecom[system='phone'][last()]/value",
+    "city": "//city",
+    "note": "note/text()",
+    "has_email": "telecom[system='email']/system"
+  }
+}`
+
+func TestXMLTable(t *testing.T) {
+	m, err := ReadXMLMapping(bytes.NewBufferString(xmlFeedMapping))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tb, err := XMLTable(bytes.NewBufferString(xmlFeed), m, []string{"id"}, map[string]string{"family": "surname"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"id":         "string",
+		"surname":    "string",
+		"name":       "string",
+		"phone":      "string",
+		"last_phone": "string",
+		"city":       "string",
+		"note":       "string",
+		"has_email":  "string",
+	}
+
+	if s1, s2, ok := jsonEqual(expected, tb.Cols()); !ok {
+		t.Errorf("column types don't match. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+
+	expectedRows := []map[string]interface{}{
+		{"id": "1", "surname": "Smith", "name": "Ann Smith", "phone": "555-0100", "last_phone": "555-0101", "city": nil, "note": "a < b", "has_email": "email"},
+		{"id": "2", "surname": "Jones", "name": "Jones", "phone": nil, "last_phone": nil, "city": "Trenton", "note": nil, "has_email": nil},
+	}
+
+	if s1, s2, ok := jsonEqual(expectedRows, datasetRows(t, tb, false)); !ok {
+		t.Errorf("unexpected rows. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestXMLTableDescendants(t *testing.T) {
+	m := &XMLMapping{
+		Record:  "//patient",
+		Columns: map[string]string{"id": "@id"},
+	}
+
+	tb, err := XMLTable(bytes.NewBufferString(xmlFeed), m, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRows := []map[string]interface{}{
+		{"id": "ignored"},
+		{"id": "1"},
+		{"id": "2"},
+	}
+
+	if s1, s2, ok := jsonEqual(expectedRows, datasetRows(t, tb, false)); !ok {
+		t.Errorf("unexpected rows. expected:\n%s\ngot:\n%s", s1, s2)
+	}
+}
+
+func TestXMLTableInvalid(t *testing.T) {
+	m := &XMLMapping{
+		Record:  "/feed/patients/patient",
+		Columns: map[string]string{"id": "@id"},
+	}
+
+	tb, err := XMLTable(bytes.NewBufferString(`<feed><patients><patient id="1"></patients></feed>`), m, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tb.Next(); err == nil {
+		t.Error("expected an error for mismatched tags")
+	}
+}
+
+func TestReadXMLMapping(t *testing.T) {
+	tests := []string{
+		`{"record": "/feed/entry", "columns": {}}`,
+		`{"record": "feed/entry", "columns": {"id": "@id"}}`,
+		`{"record": "/feed/entry", "columns": {"id": "/feed/@id"}}`,
+		`{"record": "/feed/entry", "columns": {"id": "name[@use='official'"}}`,
+		`{"record": "/feed/entry", "columns": {"id": "name[0]"}}`,
+		`{"record": "/feed/entry", "columns": {"id": "name[@use=official]"}}`,
+		`{"record": "/feed/entry", "columns": {"id": "count(name)"}}`,
+		`{"record": "/feed/entry", "path": "/feed", "columns": {"id": "@id"}}`,
+	}
+
+	for _, test := range tests {
+		if _, err := ReadXMLMapping(bytes.NewBufferString(test)); err == nil {
+			t.Errorf("expected an error for %s", test)
+		}
+	}
+}